@@ -0,0 +1,395 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+)
+
+// archive layout, numbers are big endian:
+//
+//	magic     8 bytes
+//	chain     1 byte length, name
+//	start     8 bytes
+//	end       8 bytes
+//	digests   32 bytes for each block from start to end
+//	blocks    stream of length prefixed packed blocks
+//	checksum  SHA-256 of everything before it
+const (
+	archiveMagic         = "UPDBLKA1"
+	maximumArchiveBlocks = 1 << 24
+	maximumChainLength   = 255
+	initialDigests       = 4096 // digests allocated before reading
+)
+
+// errors for archives
+var (
+	ErrNotArchive      = errors.New("not a block archive")
+	ErrInvalidRange    = errors.New("invalid archive block range")
+	ErrArchiveMismatch = errors.New("existing archive has a different header")
+	ErrArchiveComplete = errors.New("archive is complete")
+	ErrChecksum        = errors.New("archive checksum does not match")
+	ErrDigestMismatch  = errors.New("block does not match archive digest")
+)
+
+// ArchiveHeader - what the archive holds
+type ArchiveHeader struct {
+	Chain   string
+	Start   uint64
+	End     uint64
+	Digests []blockdigest.Digest // for each block from Start to End
+}
+
+// Count - number of blocks
+func (header *ArchiveHeader) Count() uint64 {
+	return header.End - header.Start + 1
+}
+
+// Digest - the digest of block n, false if n is outside the archive
+func (header *ArchiveHeader) Digest(n uint64) (blockdigest.Digest, bool) {
+	if n < header.Start || n > header.End {
+		return blockdigest.Digest{}, false
+	}
+	return header.Digests[n-header.Start], true
+}
+
+// check the fields agree with each other
+func (header *ArchiveHeader) valid() bool {
+	return 0 != header.Start && header.Start <= header.End &&
+		header.Count() <= maximumArchiveBlocks &&
+		uint64(len(header.Digests)) == header.Count() &&
+		len(header.Chain) <= maximumChainLength
+}
+
+func (header *ArchiveHeader) pack() []byte {
+	buffer := bytes.NewBufferString(archiveMagic)
+	buffer.WriteByte(byte(len(header.Chain)))
+	buffer.WriteString(header.Chain)
+	number := make([]byte, 8)
+	binary.BigEndian.PutUint64(number, header.Start)
+	buffer.Write(number)
+	binary.BigEndian.PutUint64(number, header.End)
+	buffer.Write(number)
+	for _, d := range header.Digests {
+		buffer.Write(d[:])
+	}
+	return buffer.Bytes()
+}
+
+// IsArchive - true if prefix starts with the archive magic
+func IsArchive(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(archiveMagic))
+}
+
+// ArchiveReader - read and verify the blocks of an archive
+//
+// each block is checked against its digest in the header and the
+// checksum is checked after the last block
+type ArchiveReader struct {
+	stream *Reader
+	header *ArchiveHeader
+	hash   hash.Hash
+	next   uint64 // block number
+	done   bool
+}
+
+// OpenArchive - read the archive header from r
+func OpenArchive(r io.Reader) (*ArchiveReader, error) {
+	archive := &ArchiveReader{
+		stream: NewReader(r),
+		header: &ArchiveHeader{},
+		hash:   sha256.New(),
+	}
+	err := archive.readHeader()
+	if nil != err {
+		return nil, err
+	}
+	archive.next = archive.header.Start
+	return archive, nil
+}
+
+func (archive *ArchiveReader) readHeader() error {
+	magic, err := archive.read(len(archiveMagic))
+	if nil != err || !IsArchive(magic) {
+		return ErrNotArchive
+	}
+
+	length, err := archive.read(1)
+	if nil != err {
+		return ErrTruncated
+	}
+	chain, err := archive.read(int(length[0]))
+	if nil != err {
+		return ErrTruncated
+	}
+	numbers, err := archive.read(16)
+	if nil != err {
+		return ErrTruncated
+	}
+
+	header := archive.header
+	header.Chain = string(chain)
+	header.Start = binary.BigEndian.Uint64(numbers[:8])
+	header.End = binary.BigEndian.Uint64(numbers[8:])
+	if 0 == header.Start || header.Start > header.End || header.Count() > maximumArchiveBlocks {
+		return ErrInvalidRange
+	}
+
+	// the count is not covered by the checksum until the end of the
+	// file, so only hold the digests actually read
+	count := header.Count()
+	capacity := count
+	if capacity > initialDigests {
+		capacity = initialDigests
+	}
+	header.Digests = make([]blockdigest.Digest, 0, capacity)
+	for i := uint64(0); i < count; i += 1 {
+		d, err := archive.read(blockdigest.Length)
+		if nil != err {
+			return ErrTruncated
+		}
+		digest := blockdigest.Digest{}
+		copy(digest[:], d)
+		header.Digests = append(header.Digests, digest)
+	}
+	return nil
+}
+
+// read and add to the checksum
+func (archive *ArchiveReader) read(n int) ([]byte, error) {
+	data, err := archive.stream.read(n)
+	if nil == err {
+		archive.hash.Write(data)
+	}
+	return data, err
+}
+
+// Header - the archive header
+func (archive *ArchiveReader) Header() *ArchiveHeader {
+	return archive.header
+}
+
+// Offset - bytes read so far
+func (archive *ArchiveReader) Offset() int64 {
+	return archive.stream.Offset()
+}
+
+// Next - the next packed block, io.EOF after the last block once the
+// checksum has been verified
+func (archive *ArchiveReader) Next() ([]byte, error) {
+	if archive.done {
+		return nil, io.EOF
+	}
+
+	if archive.next > archive.header.End {
+		checksum, err := archive.stream.read(sha256.Size)
+		if nil != err {
+			return nil, ErrTruncated
+		}
+		if !bytes.Equal(checksum, archive.hash.Sum(nil)) {
+			return nil, ErrChecksum
+		}
+		archive.done = true
+		return nil, io.EOF
+	}
+
+	prefix, err := archive.read(4)
+	if nil != err {
+		return nil, ErrTruncated
+	}
+	length := binary.BigEndian.Uint32(prefix)
+	if length > maximumBlockSize {
+		return nil, ErrBlockTooLarge
+	}
+	packed, err := archive.read(int(length))
+	if nil != err {
+		return nil, ErrTruncated
+	}
+
+	err = archive.header.check(archive.next, packed)
+	if nil != err {
+		return nil, err
+	}
+	archive.next += 1
+
+	return packed, nil
+}
+
+// the packed block must be block n with the digest from the header
+func (header *ArchiveHeader) check(n uint64, packed []byte) error {
+	h, digest, _, err := blockrecord.ExtractHeader(packed, 0)
+	if nil != err {
+		return err
+	}
+	expected, ok := header.Digest(n)
+	if !ok || h.Number != n || digest != expected {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+// ArchiveWriter - write an archive, resuming an incomplete one
+type ArchiveWriter struct {
+	file     *os.File
+	buffer   *bufio.Writer
+	stream   *Writer
+	hash     hash.Hash
+	header   *ArchiveHeader
+	next     uint64 // block number
+	complete bool
+}
+
+// CreateArchive - create the archive file
+//
+// if the file already holds blocks of an archive with the same
+// header, the writer continues after the last complete block
+func CreateArchive(filename string, header *ArchiveHeader) (*ArchiveWriter, error) {
+	if !header.valid() {
+		return nil, ErrInvalidRange
+	}
+
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if nil != err {
+		return nil, err
+	}
+
+	archive := &ArchiveWriter{
+		file:   f,
+		hash:   sha256.New(),
+		header: header,
+		next:   header.Start,
+	}
+
+	// error code for goto fail
+	errX := error(nil)
+
+	info, err := f.Stat()
+	if nil != err {
+		errX = err
+		goto fail
+	}
+	if 0 != info.Size() {
+		errX = archive.resume()
+		if nil != errX {
+			goto fail
+		}
+	}
+
+	archive.buffer = bufio.NewWriter(f)
+	archive.stream = NewWriter(io.MultiWriter(archive.buffer, archive.hash))
+
+	if 0 == info.Size() {
+		packed := header.pack()
+		archive.buffer.Write(packed)
+		archive.hash.Write(packed)
+	}
+	return archive, nil
+
+	// error handling
+fail:
+	f.Close()
+	return nil, errX
+}
+
+// find the last complete block, drop anything after it and restore
+// the checksum state
+func (archive *ArchiveWriter) resume() error {
+	existing, err := OpenArchive(archive.file)
+	if nil != err {
+		return err
+	}
+	if !bytes.Equal(existing.header.pack(), archive.header.pack()) {
+		return ErrArchiveMismatch
+	}
+
+	good := existing.Offset()
+	for {
+		_, err := existing.Next()
+		if io.EOF == err {
+			archive.complete = true
+			archive.next = archive.header.End + 1
+			return nil
+		} else if ErrChecksum == err || ErrDigestMismatch == err {
+			return err
+		} else if nil != err {
+			break
+		}
+		good = existing.Offset()
+		archive.next += 1
+	}
+
+	err = archive.file.Truncate(good)
+	if nil != err {
+		return err
+	}
+	_, err = archive.file.Seek(0, io.SeekStart)
+	if nil != err {
+		return err
+	}
+	_, err = io.CopyN(archive.hash, archive.file, good)
+	return err
+}
+
+// Next - number of the next block to write, beyond End when all
+// blocks are written
+func (archive *ArchiveWriter) Next() uint64 {
+	return archive.next
+}
+
+// Complete - true if the archive has all blocks and its checksum
+func (archive *ArchiveWriter) Complete() bool {
+	return archive.complete
+}
+
+// Write - append the next block, it must match its header digest
+func (archive *ArchiveWriter) Write(packed []byte) error {
+	if archive.next > archive.header.End {
+		return ErrArchiveComplete
+	}
+	err := archive.header.check(archive.next, packed)
+	if nil != err {
+		return err
+	}
+	err = archive.stream.Write(packed)
+	if nil != err {
+		return err
+	}
+	archive.next += 1
+	return nil
+}
+
+// Close - add the checksum if all blocks are written and close the
+// file, an incomplete archive can be resumed by CreateArchive
+func (archive *ArchiveWriter) Close() error {
+	if nil == archive.file {
+		return nil
+	}
+	f := archive.file
+	archive.file = nil
+
+	if archive.next > archive.header.End && !archive.complete {
+		archive.buffer.Write(archive.hash.Sum(nil))
+		archive.complete = true
+	}
+
+	err := archive.buffer.Flush()
+	if nil == err {
+		err = f.Sync()
+	}
+	if e := f.Close(); nil == err {
+		err = e
+	}
+	return err
+}
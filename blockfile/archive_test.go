@@ -0,0 +1,245 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockfile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/difficulty"
+)
+
+const (
+	testStart = 2
+	testEnd   = 5
+)
+
+// packed blocks testStart..testEnd and the header describing them
+func makeBlocks(t *testing.T) ([][]byte, *ArchiveHeader) {
+	header := &ArchiveHeader{
+		Chain: "testing",
+		Start: testStart,
+		End:   testEnd,
+	}
+	blocks := [][]byte{}
+	previous := blockdigest.Digest{}
+	for n := uint64(testStart); n <= testEnd; n += 1 {
+		h := blockrecord.Header{
+			Version:          blockrecord.Version,
+			TransactionCount: blockrecord.MinimumTransactions,
+			Number:           n,
+			PreviousBlock:    previous,
+			Timestamp:        1500000000 + n,
+			Difficulty:       difficulty.New(),
+		}
+		packedHeader := h.Pack()
+		packed := append(packedHeader[:], []byte("transactions of the block")...)
+
+		_, digest, _, err := blockrecord.ExtractHeader(packed, 0)
+		if nil != err {
+			t.Fatalf("extract header: %d  error: %s", n, err)
+		}
+		blocks = append(blocks, packed)
+		header.Digests = append(header.Digests, digest)
+		previous = digest
+	}
+	return blocks, header
+}
+
+// write blocks from the writer's next block up to the limit
+func writeBlocks(t *testing.T, filename string, header *ArchiveHeader, blocks [][]byte, limit uint64) *ArchiveWriter {
+	archive, err := CreateArchive(filename, header)
+	if nil != err {
+		t.Fatalf("create archive error: %s", err)
+	}
+	for n := archive.Next(); n <= limit; n += 1 {
+		err := archive.Write(blocks[n-testStart])
+		if nil != err {
+			t.Fatalf("write block: %d  error: %s", n, err)
+		}
+	}
+	err = archive.Close()
+	if nil != err {
+		t.Fatalf("close archive error: %s", err)
+	}
+	return archive
+}
+
+// read every block back, checking the header and checksum
+func readBlocks(t *testing.T, filename string, header *ArchiveHeader, blocks [][]byte) {
+	f, err := os.Open(filename)
+	if nil != err {
+		t.Fatalf("open error: %s", err)
+	}
+	defer f.Close()
+
+	archive, err := OpenArchive(f)
+	if nil != err {
+		t.Fatalf("open archive error: %s", err)
+	}
+	if !bytes.Equal(archive.Header().pack(), header.pack()) {
+		t.Fatalf("header: %+v  expected: %+v", archive.Header(), header)
+	}
+	for i, expected := range blocks {
+		packed, err := archive.Next()
+		if nil != err {
+			t.Fatalf("block: %d  error: %s", i, err)
+		}
+		if !bytes.Equal(packed, expected) {
+			t.Fatalf("block: %d  differs", i)
+		}
+	}
+	_, err = archive.Next()
+	if io.EOF != err {
+		t.Fatalf("after last block: %v  expected: %s", err, io.EOF)
+	}
+}
+
+func truncate(t *testing.T, filename string, n int64) {
+	info, err := os.Stat(filename)
+	if nil != err {
+		t.Fatalf("stat error: %s", err)
+	}
+	err = os.Truncate(filename, info.Size()-n)
+	if nil != err {
+		t.Fatalf("truncate error: %s", err)
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	blocks, header := makeBlocks(t)
+	filename := filepath.Join(t.TempDir(), "blocks.archive")
+
+	archive := writeBlocks(t, filename, header, blocks, testEnd)
+	if !archive.Complete() {
+		t.Fatalf("archive is not complete")
+	}
+	readBlocks(t, filename, header, blocks)
+
+	// a complete archive is not written again
+	archive, err := CreateArchive(filename, header)
+	if nil != err {
+		t.Fatalf("create archive error: %s", err)
+	}
+	defer archive.Close()
+	if !archive.Complete() || testEnd+1 != archive.Next() {
+		t.Fatalf("complete: %t  next: %d", archive.Complete(), archive.Next())
+	}
+	err = archive.Write(blocks[0])
+	if ErrArchiveComplete != err {
+		t.Fatalf("write error: %v  expected: %s", err, ErrArchiveComplete)
+	}
+}
+
+func TestArchiveResumeTruncatedBlock(t *testing.T) {
+	blocks, header := makeBlocks(t)
+	filename := filepath.Join(t.TempDir(), "blocks.archive")
+
+	// the last of three blocks is cut short
+	writeBlocks(t, filename, header, blocks, testStart+2)
+	truncate(t, filename, 3)
+
+	archive, err := CreateArchive(filename, header)
+	if nil != err {
+		t.Fatalf("create archive error: %s", err)
+	}
+	if testStart+2 != archive.Next() {
+		t.Fatalf("next: %d  expected: %d", archive.Next(), testStart+2)
+	}
+	archive.Close()
+
+	writeBlocks(t, filename, header, blocks, testEnd)
+	readBlocks(t, filename, header, blocks)
+}
+
+func TestArchiveResumeMissingChecksum(t *testing.T) {
+	blocks, header := makeBlocks(t)
+	filename := filepath.Join(t.TempDir(), "blocks.archive")
+
+	writeBlocks(t, filename, header, blocks, testEnd)
+	truncate(t, filename, sha256.Size)
+
+	archive := writeBlocks(t, filename, header, blocks, testEnd)
+	if !archive.Complete() {
+		t.Fatalf("archive is not complete")
+	}
+	readBlocks(t, filename, header, blocks)
+}
+
+func TestArchiveCorruptedChecksum(t *testing.T) {
+	blocks, header := makeBlocks(t)
+	filename := filepath.Join(t.TempDir(), "blocks.archive")
+
+	writeBlocks(t, filename, header, blocks, testEnd)
+
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if nil != err {
+		t.Fatalf("open error: %s", err)
+	}
+	info, err := f.Stat()
+	if nil != err {
+		t.Fatalf("stat error: %s", err)
+	}
+	last := make([]byte, 1)
+	_, err = f.ReadAt(last, info.Size()-1)
+	if nil == err {
+		last[0] ^= 0xff
+		_, err = f.WriteAt(last, info.Size()-1)
+	}
+	f.Close()
+	if nil != err {
+		t.Fatalf("corrupt checksum error: %s", err)
+	}
+
+	f, err = os.Open(filename)
+	if nil != err {
+		t.Fatalf("open error: %s", err)
+	}
+	defer f.Close()
+	reader, err := OpenArchive(f)
+	if nil != err {
+		t.Fatalf("open archive error: %s", err)
+	}
+	for range blocks {
+		_, err := reader.Next()
+		if nil != err {
+			t.Fatalf("next error: %s", err)
+		}
+	}
+	_, err = reader.Next()
+	if ErrChecksum != err {
+		t.Fatalf("after last block: %v  expected: %s", err, ErrChecksum)
+	}
+
+	// and the writer does not resume it
+	_, err = CreateArchive(filename, header)
+	if ErrChecksum != err {
+		t.Fatalf("create archive error: %v  expected: %s", err, ErrChecksum)
+	}
+}
+
+func TestArchiveTruncatedDigests(t *testing.T) {
+	// the largest range, but no digests follow the header
+	buffer := bytes.NewBufferString(archiveMagic)
+	buffer.WriteByte(0)
+	number := make([]byte, 8)
+	binary.BigEndian.PutUint64(number, 1)
+	buffer.Write(number)
+	binary.BigEndian.PutUint64(number, maximumArchiveBlocks)
+	buffer.Write(number)
+	buffer.Write(make([]byte, blockdigest.Length))
+
+	_, err := OpenArchive(buffer)
+	if ErrTruncated != err {
+		t.Fatalf("open archive error: %v  expected: %s", err, ErrTruncated)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package blockfile - files of packed blocks
+//
+// a stream is the packed data returned by the node for each "B"
+// request, preceded by its length as 4 bytes big endian:
+//
+//	[length][packed block][length][packed block]...
+//
+// an archive is a stream with a header giving the chain, the block
+// range and the digest of every block, followed by a checksum, see
+// archive.go
+//
+// blocks must be in ascending order of block number
+package blockfile
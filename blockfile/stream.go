@@ -0,0 +1,98 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// a block can never be larger than the node will send
+const maximumBlockSize = 5000000
+
+// errors for the stream
+var (
+	ErrBlockTooLarge = errors.New("block length exceeds maximum")
+	ErrTruncated     = errors.New("truncated block")
+)
+
+// Reader - read packed blocks from a stream
+type Reader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+// NewReader - read blocks from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// Next - the next packed block, io.EOF at the end of the stream
+func (reader *Reader) Next() ([]byte, error) {
+	prefix, err := reader.read(4)
+	if nil != err {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(prefix)
+	if length > maximumBlockSize {
+		return nil, ErrBlockTooLarge
+	}
+
+	packed, err := reader.read(int(length))
+	if io.EOF == err {
+		return nil, ErrTruncated
+	}
+	return packed, err
+}
+
+// exactly n bytes, io.EOF only if nothing was read
+func (reader *Reader) read(n int) ([]byte, error) {
+	buffer := make([]byte, n)
+	i, err := io.ReadFull(reader.r, buffer)
+	reader.offset += int64(i)
+	if io.EOF == err {
+		return nil, io.EOF
+	} else if nil != err {
+		return nil, ErrTruncated
+	}
+	return buffer, nil
+}
+
+// Offset - bytes read so far
+func (reader *Reader) Offset() int64 {
+	return reader.offset
+}
+
+// Writer - write packed blocks to a stream
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter - write blocks to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: w,
+	}
+}
+
+// Write - append one packed block
+func (writer *Writer) Write(packed []byte) error {
+	if len(packed) > maximumBlockSize {
+		return ErrBlockTooLarge
+	}
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, uint32(len(packed)))
+	_, err := writer.w.Write(prefix)
+	if nil != err {
+		return err
+	}
+	_, err = writer.w.Write(packed)
+	return err
+}
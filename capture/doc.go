@@ -0,0 +1,9 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// record of the traffic with the nodes: every message received by
+// the subscriber and every request and reply of the connector, one
+// JSON record per line in a rotating file, so that problems can be
+// reproduced later by replaying the file against a test database
+package capture
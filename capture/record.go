@@ -0,0 +1,81 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// sources of records
+const (
+	SourceSubscriber = "subscriber"
+	SourceConnector  = "connector"
+)
+
+// Record - one captured message
+//
+// subscriber: Frames is the whole multipart message, chain first
+// connector:  Frames is the request, Reply the frames received and
+// Error the send or receive failure, if any
+type Record struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Node    string    `json:"node"` // hex public key
+	Address string    `json:"address,omitempty"`
+	Frames  [][]byte  `json:"frames"`
+	Reply   [][]byte  `json:"reply,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Subscriber - record a message received from a node
+func Subscriber(node string, address string, frames [][]byte) {
+	write(&Record{
+		Time:    time.Now().UTC(),
+		Source:  SourceSubscriber,
+		Node:    node,
+		Address: address,
+		Frames:  frames,
+	})
+}
+
+// Connector - record a request to a node and its outcome
+func Connector(node string, address string, request [][]byte, reply [][]byte, err error) {
+	r := &Record{
+		Time:    time.Now().UTC(),
+		Source:  SourceConnector,
+		Node:    node,
+		Address: address,
+		Frames:  request,
+		Reply:   reply,
+	}
+	if nil != err {
+		r.Error = err.Error()
+	}
+	write(r)
+}
+
+// Reader - read the records of a capture file in order
+type Reader struct {
+	decoder *json.Decoder
+}
+
+// NewReader - read records from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		decoder: json.NewDecoder(r),
+	}
+}
+
+// Next - the next record, io.EOF at the end
+func (reader *Reader) Next() (*Record, error) {
+	r := &Record{}
+	err := reader.decoder.Decode(r)
+	if nil != err {
+		return nil, err
+	}
+	return r, nil
+}
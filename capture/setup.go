@@ -0,0 +1,185 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// a block of configuration data
+// this is read from a lua configuration file
+type Configuration struct {
+	File  string `gluamapper:"file" json:"file"`   // blank => no capture
+	Size  int    `gluamapper:"size" json:"size"`   // rotate when the file exceeds this many bytes
+	Count int    `gluamapper:"count" json:"count"` // number of rotated files retained
+}
+
+// globals for the capture file
+type captureData struct {
+	sync.Mutex // to serialise the records
+
+	// logger
+	log *logger.L
+
+	configuration Configuration
+	file          *os.File
+	buffer        *bufio.Writer
+	size          int64
+
+	// set once during initialise
+	initialised bool
+}
+
+// global data
+var globalData captureData
+
+// Initialise - open the capture file, nothing is recorded if the file
+// is not configured
+func Initialise(configuration *Configuration) error {
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	// no need to start if already started
+	if globalData.initialised {
+		return fault.ErrAlreadyInitialised
+	}
+
+	globalData.log = logger.New("capture")
+	if "" == configuration.File {
+		return nil
+	}
+	globalData.log.Infof("capture to: %q", configuration.File)
+
+	globalData.configuration = *configuration
+	err := globalData.open()
+	if nil != err {
+		globalData.log.Errorf("file: %q  error: %s", configuration.File, err)
+		return err
+	}
+
+	// all data initialised
+	globalData.initialised = true
+
+	return nil
+}
+
+// Finalise - flush and close the capture file
+func Finalise() error {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	if !globalData.initialised {
+		return fault.ErrNotInitialised
+	}
+
+	globalData.log.Info("shutting down…")
+	globalData.log.Flush()
+
+	err := globalData.close()
+
+	// finally...
+	globalData.initialised = false
+
+	return err
+}
+
+// Enabled - true if traffic is being captured
+func Enabled() bool {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	return globalData.initialised
+}
+
+// append a record, rotating the file when it is full
+func write(r *Record) {
+	data, err := json.Marshal(r)
+	if nil != err {
+		return
+	}
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	if !globalData.initialised {
+		return
+	}
+
+	if globalData.configuration.Size > 0 && globalData.size >= int64(globalData.configuration.Size) {
+		err := globalData.rotate()
+		if nil != err {
+			globalData.log.Errorf("rotate error: %s  capture stopped", err)
+			globalData.initialised = false
+			return
+		}
+	}
+
+	n, err := globalData.buffer.Write(append(data, '\n'))
+	globalData.size += int64(n)
+	if nil == err {
+		err = globalData.buffer.Flush()
+	}
+	if nil != err {
+		globalData.log.Errorf("write error: %s", err)
+	}
+}
+
+// open the file for appending
+func (c *captureData) open() error {
+	f, err := os.OpenFile(c.configuration.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if nil != err {
+		return err
+	}
+	info, err := f.Stat()
+	if nil != err {
+		f.Close()
+		return err
+	}
+	c.file = f
+	c.buffer = bufio.NewWriter(f)
+	c.size = info.Size()
+	return nil
+}
+
+func (c *captureData) close() error {
+	err := c.buffer.Flush()
+	if e := c.file.Close(); nil == err {
+		err = e
+	}
+	c.file = nil
+	c.buffer = nil
+	return err
+}
+
+// FILE => FILE.1 => FILE.2 ... dropping the oldest
+func (c *captureData) rotate() error {
+	err := c.close()
+	if nil != err {
+		return err
+	}
+
+	name := c.configuration.File
+	count := c.configuration.Count
+	if count < 1 {
+		count = 1
+	}
+	os.Remove(fmt.Sprintf("%s.%d", name, count))
+	for i := count - 1; i >= 1; i -= 1 {
+		os.Rename(fmt.Sprintf("%s.%d", name, i), fmt.Sprintf("%s.%d", name, i+1))
+	}
+	err = os.Rename(name, name+".1")
+	if nil != err {
+		return err
+	}
+	return c.open()
+}
@@ -5,14 +5,244 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/bitmark-inc/bitmarkd/mode"
 	"github.com/bitmark-inc/bitmarkd/zmqutil"
 	"github.com/bitmark-inc/exitwithstatus"
 	"github.com/bitmark-inc/logger"
+
+	"github.com/bitmark-inc/updaterd/control"
+	"github.com/bitmark-inc/updaterd/peer"
+	"github.com/bitmark-inc/updaterd/storage"
 )
 
+// control command handler
+// commands that are sent to the running process via its control socket
+// returns false if the command is not a control command
+func processControlCommand(log *logger.L, arguments []string, options *Configuration) bool {
+
+	command := arguments[0]
+	arguments = arguments[1:]
+
+	switch command {
+	case "status", "pause", "resume", "expire":
+		if 0 != len(arguments) {
+			exitwithstatus.Message("error: %s takes no arguments", command)
+		}
+	case "force-state", "rewind":
+		if 1 != len(arguments) {
+			exitwithstatus.Message("error: %s requires one argument", command)
+		}
+	case "dead-letter":
+		if 0 == len(arguments) || len(arguments) > 2 {
+			exitwithstatus.Message("error: %s requires: list, retry [ID|all] or purge ID|all", command)
+		}
+	default:
+		return false
+	}
+
+	if "" == options.Control.Socket {
+		exitwithstatus.Message("error: control socket is not configured")
+	}
+
+	log.Infof("control: %q  arguments: %q", command, arguments)
+	result, err := control.Call(options.Control.Socket, command, arguments)
+	if nil != err {
+		log.Errorf("control: %q  error: %s", command, err)
+		exitwithstatus.Message("error: %s failed: %s", command, err)
+	}
+
+	if 0 == len(result) {
+		fmt.Printf("ok\n")
+		return true
+	}
+
+	var b bytes.Buffer
+	err = json.Indent(&b, result, "", "  ")
+	if nil != err {
+		exitwithstatus.Message("error: invalid reply: %s", err)
+	}
+	fmt.Printf("%s\n", b.String())
+
+	return true
+}
+
+// query command handler
+// commands that only read the database, so they can run beside the
+// daemon
+// returns false if the command is not a query command
+func processQueryCommand(log *logger.L, arguments []string, options *Configuration) bool {
+
+	command := arguments[0]
+	arguments = arguments[1:]
+
+	switch command {
+	case "provenance":
+		if 0 == len(arguments) || len(arguments) > 2 {
+			exitwithstatus.Message("error: %s requires: ID [json|csv|dot]", command)
+		}
+	default:
+		return false
+	}
+
+	mode.Initialise(options.Chain)
+	defer mode.Finalise()
+
+	err := storage.Initialise(options.Database)
+	if nil != err {
+		log.Criticalf("storage initialise error: %s", err)
+		exitwithstatus.Message("storage initialise error: %s", err)
+	}
+	defer storage.Finalise()
+
+	log.Infof("query: %q  arguments: %q", command, arguments)
+
+	switch command {
+	case "provenance":
+		format := provenanceJSON
+		if 2 == len(arguments) {
+			format = arguments[1]
+		}
+		err = provenance(log, arguments[0], format)
+	}
+	if nil != err {
+		log.Errorf("query: %q  error: %s", command, err)
+		exitwithstatus.Message("error: %s failed: %s", command, err)
+	}
+
+	return true
+}
+
+// data command handler
+// commands that work directly on the database, the daemon must not be
+// running (the PID file lock is already held)
+// returns false if the command is not a data command
+func processDataCommand(log *logger.L, arguments []string, options *Configuration) bool {
+
+	command := arguments[0]
+	arguments = arguments[1:]
+
+	switch command {
+	case "import-blocks":
+		if 0 == len(arguments) || len(arguments) > 2 || (2 == len(arguments) && "replace" != arguments[1]) {
+			exitwithstatus.Message("error: %s requires: FILE [replace]", command)
+		}
+	case "export-blocks":
+		if len(arguments) < 2 || len(arguments) > 3 {
+			exitwithstatus.Message("error: %s requires: FILE START [END]", command)
+		}
+	case "reindex":
+		if 1 != len(arguments) || !strings.HasPrefix(arguments[0], "--from=") {
+			exitwithstatus.Message("error: %s requires: --from N", command)
+		}
+	case "replay":
+		if 0 == len(arguments) {
+			exitwithstatus.Message("error: %s requires: FILE...", command)
+		}
+	case "verify-sync", "verify-chain":
+		if len(arguments) > 2 {
+			exitwithstatus.Message("error: %s requires: [START [END]]", command)
+		}
+	case "reconcile-shares", "verify-editions":
+		if len(arguments) > 1 || (1 == len(arguments) && "repair" != arguments[0]) {
+			exitwithstatus.Message("error: %s requires: [repair]", command)
+		}
+	default:
+		return false
+	}
+
+	mode.Initialise(options.Chain)
+	defer mode.Finalise()
+
+	err := storage.Initialise(options.Database)
+	if nil != err {
+		log.Criticalf("storage initialise error: %s", err)
+		exitwithstatus.Message("storage initialise error: %s", err)
+	}
+	defer storage.Finalise()
+
+	log.Infof("data: %q  arguments: %q", command, arguments)
+
+	switch command {
+	case "import-blocks":
+		err = importBlocks(log, arguments[0], 2 == len(arguments))
+
+	case "export-blocks":
+		var start, end uint64 // end 0 => local height
+		start, err = strconv.ParseUint(arguments[1], 10, 64)
+		if nil == err && 3 == len(arguments) {
+			end, err = strconv.ParseUint(arguments[2], 10, 64)
+		}
+		if nil != err {
+			exitwithstatus.Message("error: %s: invalid block number: %s", command, err)
+		}
+
+		err = zmqutil.StartAuthentication()
+		if nil != err {
+			exitwithstatus.Message("error: zmq.AuthStart() error: %s", err)
+		}
+		err = exportBlocks(log, &options.Peering, arguments[0], start, end)
+
+	case "reindex":
+		var from uint64
+		from, err = strconv.ParseUint(strings.TrimPrefix(arguments[0], "--from="), 10, 64)
+		if nil != err {
+			exitwithstatus.Message("error: %s: invalid block number: %s", command, err)
+		}
+		err = reindexBlocks(log, from)
+
+	case "replay":
+		var result *peer.ReplayResult
+		result, err = peer.Replay(arguments)
+		if nil == err {
+			fmt.Printf("subscriber messages: %d\n", result.Messages)
+			fmt.Printf("connector cycles:    %d\n", result.Cycles)
+			fmt.Printf("requests answered:   %d\n", result.Requests)
+			fmt.Printf("requests unmatched:  %d\n", result.Unmatched)
+		}
+
+	case "verify-sync", "verify-chain":
+		var start, end uint64 // 0 => the defaults of each command
+		if len(arguments) > 0 {
+			start, err = strconv.ParseUint(arguments[0], 10, 64)
+		}
+		if nil == err && 2 == len(arguments) {
+			end, err = strconv.ParseUint(arguments[1], 10, 64)
+		}
+		if nil != err {
+			exitwithstatus.Message("error: %s: invalid block number: %s", command, err)
+		}
+
+		err = zmqutil.StartAuthentication()
+		if nil != err {
+			exitwithstatus.Message("error: zmq.AuthStart() error: %s", err)
+		}
+		if "verify-sync" == command {
+			err = verifySync(log, &options.Peering, start, end)
+		} else {
+			err = verifyChain(log, &options.Peering, start, end)
+		}
+
+	case "reconcile-shares":
+		err = reconcileShares(log, 1 == len(arguments))
+
+	case "verify-editions":
+		err = verifyEditions(log, 1 == len(arguments))
+	}
+	if nil != err {
+		log.Errorf("data: %q  error: %s", command, err)
+		exitwithstatus.Message("error: %s failed: %s", command, err)
+	}
+
+	return true
+}
+
 // setup command handler
 // commands that run to create key and certificate files
 // these commands cannot access any internal database or states
@@ -60,6 +290,39 @@ func processSetupCommand(log *logger.L, arguments []string, options *Configurati
 		fmt.Printf("                                     and the public key in: %q\n", options.Peering.PublicKey)
 		fmt.Printf("\n")
 
+		fmt.Printf("commands for the running process using: %q\n\n", options.Control.Socket)
+		fmt.Printf("  status                           - show heights, connector state and nodes\n")
+		fmt.Printf("  pause                            - stop the connector after its current cycle\n")
+		fmt.Printf("  resume                           - restart a paused connector\n")
+		fmt.Printf("  force-state STATE                - move the connector to: HighestBlock or ForkDetect\n")
+		fmt.Printf("  rewind N                         - delete blocks from N upwards and resynchronise\n")
+		fmt.Printf("  expire                           - remove expired records now\n")
+		fmt.Printf("  dead-letter list                 - show payloads that failed to store\n")
+		fmt.Printf("  dead-letter retry [ID|all]       - store the payloads again\n")
+		fmt.Printf("  dead-letter purge ID|all         - delete the payloads\n")
+		fmt.Printf("\n")
+
+		fmt.Printf("commands that read the database\n\n")
+		fmt.Printf("  provenance ID [json|csv|dot]     - chain of custody of a bitmark, or every bitmark of an asset\n")
+		fmt.Printf("                                     pending and expired records are marked\n")
+		fmt.Printf("\n")
+
+		fmt.Printf("commands for the database, the process must be stopped\n\n")
+		fmt.Printf("  import-blocks FILE [replace]     - store blocks from a block file, skipping those present\n")
+		fmt.Printf("                                     replace => local blocks that fork from the file are deleted\n")
+		fmt.Printf("  export-blocks FILE START [END]   - write local blocks START to END (default: height) to an archive\n")
+		fmt.Printf("                                     block data is kept data or fetched from a node, reruns resume\n")
+		fmt.Printf("  reindex --from N                 - rebuild the tables from block N using the kept packed blocks\n")
+		fmt.Printf("  replay FILE...                   - feed captured node traffic through the subscriber and connector\n")
+		fmt.Printf("                                     into the configured database, use a test database\n")
+		fmt.Printf("  verify-sync [START [END]]        - fetch and decode blocks from a node without writing (also: --dry-run)\n")
+		fmt.Printf("                                     default: local height + 1 to node height, reports failures\n")
+		fmt.Printf("  verify-chain [START [END]]       - compare local block digests with all eligible nodes and check\n")
+		fmt.Printf("                                     one head per bitmark, JSON report on stdout\n")
+		fmt.Printf("  reconcile-shares [repair]        - compare share balances with their history, repair => correct them\n")
+		fmt.Printf("  verify-editions [repair]         - check edition numbering of every owner's asset, repair => renumber\n")
+		fmt.Printf("\n")
+
 		exitwithstatus.Exit(1)
 	}
 }
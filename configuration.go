@@ -16,6 +16,10 @@ import (
 	"github.com/bitmark-inc/bitmarkd/util"
 	"github.com/bitmark-inc/logger"
 
+	"github.com/bitmark-inc/updaterd/capture"
+	"github.com/bitmark-inc/updaterd/control"
+	"github.com/bitmark-inc/updaterd/deadletter"
+	"github.com/bitmark-inc/updaterd/monitor"
 	"github.com/bitmark-inc/updaterd/peer"
 	"github.com/bitmark-inc/updaterd/storage"
 )
@@ -31,6 +35,27 @@ const (
 	defaultLogFile      = "updaterd.log"
 	defaultLogCount     = 10          //  number of log files retained
 	defaultLogSize      = 1024 * 1024 // rotate when <logfile> exceeds this size
+
+	defaultReadyLag = 2 // blocks behind the nodes before /readyz fails
+
+	defaultControlSocket = "updaterd.sock"
+
+	defaultDeadLetterDirectory = "deadletter"
+
+	defaultCaptureSize  = 64 * 1024 * 1024 // rotate when the capture file exceeds this size
+	defaultCaptureCount = 10               // number of capture files retained
+
+	defaultQueueSize           = 1000 // messages in memory for each subscriber queue
+	defaultQueueWorkers        = 1
+	defaultQueueOverflow       = "block"
+	defaultQueueSpillDirectory = "spill"
+
+	defaultResolveInterval = 300 // seconds between DNS lookups of node host names
+
+	defaultDiscoveryMaximumNodes    = 8
+	defaultDiscoveryBroadcastOffset = -1   // bitmarkd default ports: broadcast 2135, listen 2136
+	defaultDiscoveryExpiry          = 3300 // seconds, same as bitmarkd announce expiry
+	defaultDiscoveryPriority        = 0    // after every static node
 )
 
 // to hold log levels
@@ -46,12 +71,16 @@ var (
 
 // peering configuration
 type Configuration struct {
-	DataDirectory string                `gluamapper:"data_directory" json:"data_directory"`
-	PidFile       string                `gluamapper:"pidfile" json:"pidfile"`
-	Chain         string                `gluamapper:"chain" json:"chain"`
-	Peering       peer.Configuration    `gluamapper:"peering" json:"peering"`
-	Database      storage.Configuration `gluamapper:"database" json:"database"`
-	Logging       logger.Configuration  `gluamapper:"logging" json:"logging"`
+	DataDirectory string                   `gluamapper:"data_directory" json:"data_directory"`
+	PidFile       string                   `gluamapper:"pidfile" json:"pidfile"`
+	Chain         string                   `gluamapper:"chain" json:"chain"`
+	Peering       peer.Configuration       `gluamapper:"peering" json:"peering"`
+	Database      storage.Configuration    `gluamapper:"database" json:"database"`
+	Monitor       monitor.Configuration    `gluamapper:"monitor" json:"monitor"`
+	Control       control.Configuration    `gluamapper:"control" json:"control"`
+	DeadLetter    deadletter.Configuration `gluamapper:"dead_letter" json:"dead_letter"`
+	Capture       capture.Configuration    `gluamapper:"capture" json:"capture"`
+	Logging       logger.Configuration     `gluamapper:"logging" json:"logging"`
 }
 
 // will read decode and verify the configuration
@@ -72,7 +101,34 @@ func getConfiguration(configurationFileName string, variables map[string]string)
 		Chain:         chain.Bitmark,
 
 		Database: storage.Configuration{},
-		Peering:  peer.Configuration{},
+		Peering: peer.Configuration{
+			ResolveInterval: defaultResolveInterval,
+			Discovery: peer.DiscoveryConfiguration{
+				MaximumNodes:    defaultDiscoveryMaximumNodes,
+				BroadcastOffset: defaultDiscoveryBroadcastOffset,
+				Expiry:          defaultDiscoveryExpiry,
+				Priority:        defaultDiscoveryPriority,
+			},
+			Queue: peer.QueueConfiguration{
+				Size:           defaultQueueSize,
+				Workers:        defaultQueueWorkers,
+				Overflow:       defaultQueueOverflow,
+				SpillDirectory: defaultQueueSpillDirectory,
+			},
+		},
+		Monitor: monitor.Configuration{
+			ReadyLag: defaultReadyLag,
+		},
+		Control: control.Configuration{
+			Socket: defaultControlSocket,
+		},
+		DeadLetter: deadletter.Configuration{
+			Directory: defaultDeadLetterDirectory,
+		},
+		Capture: capture.Configuration{
+			Size:  defaultCaptureSize,
+			Count: defaultCaptureCount,
+		},
 
 		Logging: logger.Configuration{
 			Directory: defaultLogDirectory,
@@ -114,6 +170,8 @@ func getConfiguration(configurationFileName string, variables map[string]string)
 	// if not, assign them to the data directory
 	mustBeAbsolute := []*string{
 		&options.Logging.Directory,
+		&options.DeadLetter.Directory,
+		&options.Peering.Queue.SpillDirectory,
 	}
 	for _, f := range mustBeAbsolute {
 		*f = util.EnsureAbsolute(options.DataDirectory, *f)
@@ -122,6 +180,8 @@ func getConfiguration(configurationFileName string, variables map[string]string)
 	// optional absolute paths i.e. blank or an absolute path
 	optionalAbsolute := []*string{
 		&options.PidFile,
+		&options.Control.Socket,
+		&options.Capture.File,
 	}
 	for _, f := range optionalAbsolute {
 		if "" != *f {
@@ -0,0 +1,40 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+)
+
+// Call - send a command to the running program and return its result
+func Call(path string, command string, arguments []string) (json.RawMessage, error) {
+
+	conn, err := net.Dial("unix", path)
+	if nil != err {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := Request{
+		Command:   command,
+		Arguments: arguments,
+	}
+	err = json.NewEncoder(conn).Encode(request)
+	if nil != err {
+		return nil, err
+	}
+
+	var reply Reply
+	err = json.NewDecoder(conn).Decode(&reply)
+	if nil != err {
+		return nil, err
+	}
+	if "" != reply.Error {
+		return nil, errors.New(reply.Error)
+	}
+	return reply.Result, nil
+}
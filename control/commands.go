@@ -0,0 +1,170 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package control
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/bitmark-inc/updaterd/deadletter"
+	"github.com/bitmark-inc/updaterd/peer"
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// time allowed for a client to send its request
+const requestTimeout = 10 * time.Second
+
+// Request - a single command sent over the socket
+type Request struct {
+	Command   string   `json:"command"`
+	Arguments []string `json:"arguments"`
+}
+
+// Reply - the result of a command, Error is blank on success
+type Reply struct {
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// the reply to the status command
+type statusReply struct {
+	LocalHeight uint64 `json:"local_height"`
+	peer.Status
+}
+
+// read one request, run it and write the reply
+func (srv *server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+
+	var request Request
+	err := json.NewDecoder(conn).Decode(&request)
+	if nil != err {
+		srv.log.Errorf("request decode error: %s", err)
+		return
+	}
+
+	// commands may run for a long time
+	conn.SetReadDeadline(time.Time{})
+
+	srv.Lock()
+	result, err := srv.process(request)
+	srv.Unlock()
+
+	reply := Reply{}
+	if nil != err {
+		srv.log.Errorf("command: %q  error: %s", request.Command, err)
+		reply.Error = err.Error()
+	} else if nil != result {
+		reply.Result, err = json.Marshal(result)
+		if nil != err {
+			reply.Error = err.Error()
+		}
+	}
+
+	err = json.NewEncoder(conn).Encode(reply)
+	if nil != err {
+		srv.log.Errorf("reply encode error: %s", err)
+	}
+}
+
+// run a command
+func (srv *server) process(request Request) (interface{}, error) {
+
+	log := srv.log
+	log.Infof("command: %q  arguments: %q", request.Command, request.Arguments)
+
+	arguments := request.Arguments
+
+	switch request.Command {
+	case "status":
+		h, err := storage.GetBlockHeight()
+		if nil != err {
+			return nil, err
+		}
+		return statusReply{
+			LocalHeight: h,
+			Status:      peer.GetStatus(),
+		}, nil
+
+	case "pause":
+		return nil, peer.Pause()
+
+	case "resume":
+		return nil, peer.Resume()
+
+	case "force-state":
+		if 1 != len(arguments) {
+			return nil, ErrInvalidArgument
+		}
+		return nil, peer.ForceState(arguments[0])
+
+	case "rewind":
+		if 1 != len(arguments) {
+			return nil, ErrInvalidArgument
+		}
+		n, err := strconv.ParseUint(arguments[0], 10, 64)
+		if nil != err {
+			return nil, ErrInvalidArgument
+		}
+		return nil, peer.Rewind(n)
+
+	case "expire":
+		return nil, storage.RunExpiry()
+
+	case "dead-letter":
+		return deadLetter(arguments)
+
+	default:
+		return nil, ErrUnknownCommand
+	}
+}
+
+// list, retry or purge the dead letter entries
+// "all" selects every entry for retry or purge
+func deadLetter(arguments []string) (interface{}, error) {
+	if 0 == len(arguments) {
+		return nil, ErrInvalidArgument
+	}
+
+	id := ""
+	switch len(arguments) {
+	case 1:
+	case 2:
+		if "all" != arguments[1] {
+			id = arguments[1]
+		}
+	default:
+		return nil, ErrInvalidArgument
+	}
+
+	switch arguments[0] {
+	case "list":
+		return deadletter.List()
+
+	case "retry":
+		stored, failed, err := deadletter.Retry(id)
+		if nil != err {
+			return nil, err
+		}
+		return map[string]int{"stored": stored, "failed": failed}, nil
+
+	case "purge":
+		if 1 == len(arguments) {
+			return nil, ErrInvalidArgument // must name an entry or "all"
+		}
+		n, err := deadletter.Purge(id)
+		if nil != err {
+			return nil, err
+		}
+		return map[string]int{"purged": n}, nil
+
+	default:
+		return nil, ErrInvalidArgument
+	}
+}
@@ -0,0 +1,6 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// local Unix domain socket for administering the running program
+package control
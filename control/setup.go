@@ -0,0 +1,182 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package control
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/bitmark-inc/bitmarkd/background"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// errors for the control socket
+var (
+	ErrAlreadyRunning  = errors.New("control socket is already in use")
+	ErrUnknownCommand  = errors.New("unknown command")
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// a block of configuration data
+// this is read from a lua configuration file
+type Configuration struct {
+	Socket string `gluamapper:"socket" json:"socket"` // path of the Unix socket, blank to disable
+}
+
+// globals for background proccess
+type controlData struct {
+	sync.RWMutex // to allow locking
+
+	// logger
+	log *logger.L
+
+	srv server // the socket server
+
+	// for background
+	background *background.T
+
+	// set once during initialise
+	initialised bool
+}
+
+// global data
+var globalData controlData
+
+// initialise the control socket if a path is configured
+func Initialise(configuration *Configuration) error {
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	// no need to start if already started
+	if globalData.initialised {
+		return fault.ErrAlreadyInitialised
+	}
+
+	globalData.log = logger.New("control")
+	globalData.log.Info("starting…")
+
+	if "" == configuration.Socket {
+		globalData.log.Info("disabled: no socket")
+		return nil
+	}
+
+	if err := globalData.srv.initialise(configuration.Socket); nil != err {
+		return err
+	}
+
+	// all data initialised
+	globalData.initialised = true
+
+	// start background processes
+	globalData.log.Info("start background…")
+
+	var processes = background.Processes{
+		&globalData.srv,
+	}
+
+	globalData.background = background.Start(processes, globalData.log)
+
+	return nil
+}
+
+// finialise - stop all background tasks
+func Finalise() error {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	if !globalData.initialised {
+		return fault.ErrNotInitialised
+	}
+
+	globalData.log.Info("shutting down…")
+	globalData.log.Flush()
+
+	// stop background
+	globalData.background.Stop()
+
+	// finally...
+	globalData.initialised = false
+
+	return nil
+}
+
+// data for the socket server
+type server struct {
+	sync.Mutex // serialise requests
+
+	log      *logger.L
+	path     string
+	listener net.Listener
+}
+
+// bind the socket so that errors are reported at startup
+func (srv *server) initialise(path string) error {
+
+	log := logger.New("admin")
+	srv.log = log
+
+	log.Info("initialising…")
+
+	// a socket file left by a previous run is removed, but
+	// only if nothing is listening on it
+	if _, err := os.Stat(path); nil == err {
+		c, err := net.Dial("unix", path)
+		if nil == err {
+			c.Close()
+			log.Errorf("socket: %q  error: %s", path, ErrAlreadyRunning)
+			return ErrAlreadyRunning
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if nil != err {
+		log.Errorf("listen: %q  error: %s", path, err)
+		return err
+	}
+
+	// only the owner may administer the program
+	err = os.Chmod(path, 0600)
+	if nil != err {
+		listener.Close()
+		log.Errorf("chmod: %q  error: %s", path, err)
+		return err
+	}
+
+	srv.path = path
+	srv.listener = listener
+
+	log.Infof("listening on: %q", path)
+
+	return nil
+}
+
+// accept connections until shutdown
+func (srv *server) Run(args interface{}, shutdown <-chan struct{}) {
+
+	srv.log.Info("starting…")
+
+	go func() {
+		for {
+			conn, err := srv.listener.Accept()
+			if nil != err {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					continue
+				}
+				return
+			}
+			go srv.handle(conn)
+		}
+	}()
+
+	<-shutdown
+	srv.log.Info("shutting down…")
+	srv.listener.Close()
+	os.Remove(srv.path)
+}
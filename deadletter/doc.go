@@ -0,0 +1,7 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// store for subscriber payloads that could not be written to the
+// database, kept as files so that they survive a database outage
+package deadletter
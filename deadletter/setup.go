@@ -0,0 +1,153 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package deadletter
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/background"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// various timeouts
+const (
+	retryInterval = 5 * time.Minute // pause between replays
+)
+
+// a block of configuration data
+// this is read from a lua configuration file
+type Configuration struct {
+	Directory string `gluamapper:"directory" json:"directory"` // where failed payloads are kept
+}
+
+// globals for background proccess
+type deadLetterData struct {
+	sync.Mutex // to serialise access to the files
+
+	retrying sync.Mutex // one replay at a time, held without the file lock
+
+	// logger
+	log *logger.L
+
+	directory string
+
+	rtr retrier // replays the entries
+
+	// for background
+	background *background.T
+
+	// set once during initialise
+	initialised bool
+}
+
+// global data
+var globalData deadLetterData
+
+// initialise the store and start the retry background
+func Initialise(configuration *Configuration) error {
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	// no need to start if already started
+	if globalData.initialised {
+		return fault.ErrAlreadyInitialised
+	}
+
+	globalData.log = logger.New("deadletter")
+	globalData.log.Info("starting…")
+
+	if err := os.MkdirAll(configuration.Directory, 0700); nil != err {
+		globalData.log.Errorf("directory: %q  error: %s", configuration.Directory, err)
+		return err
+	}
+	globalData.directory = configuration.Directory
+
+	if err := globalData.rtr.initialise(); nil != err {
+		return err
+	}
+
+	// all data initialised
+	globalData.initialised = true
+
+	// start background processes
+	globalData.log.Info("start background…")
+
+	var processes = background.Processes{
+		&globalData.rtr,
+	}
+
+	globalData.background = background.Start(processes, globalData.log)
+
+	return nil
+}
+
+// finialise - stop all background tasks
+func Finalise() error {
+	globalData.Lock()
+
+	if !globalData.initialised {
+		globalData.Unlock()
+		return fault.ErrNotInitialised
+	}
+
+	globalData.log.Info("shutting down…")
+	globalData.log.Flush()
+
+	// finally...
+	globalData.initialised = false
+	globalData.Unlock()
+
+	// stop background, outside the lock as a replay may be in progress
+	globalData.background.Stop()
+
+	return nil
+}
+
+// data for the retry background
+type retrier struct {
+	log *logger.L
+}
+
+// initialise the retrier
+func (rtr *retrier) initialise() error {
+
+	log := logger.New("dl-retry")
+	rtr.log = log
+
+	log.Info("initialising…")
+
+	return nil
+}
+
+// background to replay entries
+func (rtr *retrier) Run(args interface{}, shutdown <-chan struct{}) {
+
+	log := rtr.log
+
+	log.Info("starting…")
+
+loop:
+	for {
+		// wait for shutdown
+		log.Info("waiting…")
+
+		select {
+		case <-shutdown:
+			break loop
+
+		case <-time.After(retryInterval):
+			stored, failed, err := retry("", false)
+			if nil != err {
+				log.Errorf("retry error: %s", err)
+			} else if stored+failed > 0 {
+				log.Infof("retry: stored: %d  failed: %d", stored, failed)
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// suffix of entry files
+const entrySuffix = ".json"
+
+// the background stops retrying an entry after this many attempts
+const maxAttempts = 12
+
+// errors for the store
+var (
+	ErrEntryNotFound = errors.New("dead letter entry not found")
+)
+
+// Entry - a payload that could not be stored
+type Entry struct {
+	Id            string    `json:"id"`
+	Topic         string    `json:"topic"`
+	Payload       []byte    `json:"payload"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	Parked        bool      `json:"parked"` // only retried on request
+	CreatedAt     time.Time `json:"created_at"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+}
+
+// Add - keep a payload that failed to store
+func Add(topic string, payload []byte, storeErr error) error {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	if !globalData.initialised {
+		return fault.ErrNotInitialised
+	}
+
+	now := time.Now().UTC()
+	e := &Entry{
+		Id:            fmt.Sprintf("%020d-%s", now.UnixNano(), topic),
+		Topic:         topic,
+		Payload:       payload,
+		Error:         storeErr.Error(),
+		Attempts:      1,
+		CreatedAt:     now,
+		LastAttemptAt: now,
+	}
+
+	err := write(e)
+	if nil != err {
+		globalData.log.Errorf("add: topic: %s  error: %s", topic, err)
+		return err
+	}
+	globalData.log.Warnf("added: %s  error: %s", e.Id, e.Error)
+
+	return nil
+}
+
+// List - all entries, oldest first
+func List() ([]*Entry, error) {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	if !globalData.initialised {
+		return nil, fault.ErrNotInitialised
+	}
+	return readAll()
+}
+
+// Retry - replay one entry, or all if id is blank, including parked entries
+// returns the numbers stored and still failing
+func Retry(id string) (int, int, error) {
+	return retry(id, true)
+}
+
+// replay the selected entries without holding the lock, so the
+// subscriber can add entries meanwhile; an entry that reaches
+// maxAttempts is parked and skipped unless parked is set
+func retry(id string, parked bool) (int, int, error) {
+	globalData.retrying.Lock()
+	defer globalData.retrying.Unlock()
+
+	globalData.Lock()
+	if !globalData.initialised {
+		globalData.Unlock()
+		return 0, 0, fault.ErrNotInitialised
+	}
+	entries, err := selectEntries(id)
+	globalData.Unlock()
+
+	if nil != err {
+		return 0, 0, err
+	}
+
+	stored := 0
+	failed := 0
+	for _, e := range entries {
+		if e.Parked && !parked {
+			continue
+		}
+
+		replayErr := replay(e)
+
+		done, err := update(e, replayErr)
+		if nil != err {
+			return stored, failed, err
+		}
+		if done {
+			stored += 1
+		} else if nil != replayErr {
+			failed += 1
+		}
+	}
+	return stored, failed, nil
+}
+
+// remove a stored entry or record the failed attempt
+// returns true if the entry was removed
+func update(e *Entry, replayErr error) (bool, error) {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	// purged during the replay
+	name := filename(e.Id)
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if nil == replayErr {
+		err := os.Remove(name)
+		if nil != err {
+			return false, err
+		}
+		globalData.log.Infof("stored: %s", e.Id)
+		return true, nil
+	}
+
+	e.Error = replayErr.Error()
+	e.Attempts += 1
+	e.LastAttemptAt = time.Now().UTC()
+	if !e.Parked && e.Attempts >= maxAttempts {
+		e.Parked = true
+		globalData.log.Errorf("retry: %s  attempts: %d  parked  error: %s", e.Id, e.Attempts, e.Error)
+	} else {
+		globalData.log.Warnf("retry: %s  attempts: %d  error: %s", e.Id, e.Attempts, e.Error)
+	}
+	return false, write(e)
+}
+
+// Purge - delete one entry, or all if id is blank
+// returns the number deleted
+func Purge(id string) (int, error) {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	if !globalData.initialised {
+		return 0, fault.ErrNotInitialised
+	}
+
+	entries, err := selectEntries(id)
+	if nil != err {
+		return 0, err
+	}
+
+	n := 0
+	for _, e := range entries {
+		err := os.Remove(filename(e.Id))
+		if nil != err {
+			return n, err
+		}
+		globalData.log.Infof("purged: %s", e.Id)
+		n += 1
+	}
+	return n, nil
+}
+
+// store the payload as the subscriber would have
+func replay(e *Entry) error {
+	switch e.Topic {
+	case "assets", "issues", "transfer":
+		return storage.StoreTransactions(e.Payload)
+	default:
+		return fmt.Errorf("unsupported topic: %q", e.Topic)
+	}
+}
+
+// the requested entry or all entries
+// must be called with the lock held
+func selectEntries(id string) ([]*Entry, error) {
+	if "" == id {
+		return readAll()
+	}
+	if strings.ContainsRune(id, filepath.Separator) {
+		return nil, ErrEntryNotFound
+	}
+	e, err := read(filename(id))
+	if os.IsNotExist(err) {
+		return nil, ErrEntryNotFound
+	} else if nil != err {
+		return nil, err
+	}
+	return []*Entry{e}, nil
+}
+
+// must be called with the lock held
+func readAll() ([]*Entry, error) {
+	names, err := filepath.Glob(filepath.Join(globalData.directory, "*"+entrySuffix))
+	if nil != err {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	entries := make([]*Entry, 0, len(names))
+	for _, name := range names {
+		e, err := read(name)
+		if nil != err {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func read(name string) (*Entry, error) {
+	data, err := ioutil.ReadFile(name)
+	if nil != err {
+		return nil, err
+	}
+	e := &Entry{}
+	err = json.Unmarshal(data, e)
+	if nil != err {
+		return nil, err
+	}
+	return e, nil
+}
+
+// write via a temporary file so a crash never leaves a partial entry
+func write(e *Entry) error {
+	data, err := json.Marshal(e)
+	if nil != err {
+		return err
+	}
+	name := filename(e.Id)
+	temp := name + ".tmp"
+	err = ioutil.WriteFile(temp, data, 0600)
+	if nil != err {
+		return err
+	}
+	return os.Rename(temp, name)
+}
+
+func filename(id string) string {
+	return filepath.Join(globalData.directory, id+entrySuffix)
+}
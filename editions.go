@@ -0,0 +1,53 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/bitmark-inc/logger"
+
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// check that the editions of every owner's asset run from zero in
+// block order with no gaps or duplicates, and that issues not in a
+// block have none; optionally renumber the pairs that do not
+func verifyEditions(log *logger.L, repair bool) error {
+
+	problems, err := storage.CheckEditions()
+	if nil != err {
+		return err
+	}
+
+	for _, p := range problems {
+		edition := "none"
+		if nil != p.Edition {
+			edition = fmt.Sprintf("%d", *p.Edition)
+		}
+		expected := "none"
+		if nil != p.Expected {
+			expected = fmt.Sprintf("%d", *p.Expected)
+		}
+		fmt.Printf("owner: %s  asset: %s  issue: %s  block: %d  edition: %s  expected: %s\n", p.Owner, p.AssetId, p.TxId, p.BlockNumber, edition, expected)
+		log.Warnf("owner: %s  asset: %s  issue: %s  block: %d  edition: %s  expected: %s", p.Owner, p.AssetId, p.TxId, p.BlockNumber, edition, expected)
+	}
+	fmt.Printf("editions differing: %d\n", len(problems))
+
+	if 0 == len(problems) {
+		return nil
+	}
+	if !repair {
+		return fmt.Errorf("%d editions differ", len(problems))
+	}
+
+	changed, err := storage.RepairEditions()
+	if nil != err {
+		return err
+	}
+	fmt.Printf("editions renumbered: %d\n", changed)
+	log.Infof("editions renumbered: %d", changed)
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/logger"
+
+	"github.com/bitmark-inc/updaterd/blockfile"
+	"github.com/bitmark-inc/updaterd/peer"
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// blocks requested from the node together
+const exportWindow = 100
+
+// write the local blocks from start to end to an archive
+//
+// the digests come from the database and the packed blocks from the
+// kept blocks (raw_blocks) or else from a node, each block must match
+// its local digest; if the file already holds part of the same
+// archive the export continues after its last complete block
+func exportBlocks(log *logger.L, configuration *peer.Configuration, filename string, start uint64, end uint64) error {
+
+	height, err := storage.GetBlockHeight()
+	if nil != err {
+		return err
+	}
+	if 0 == end {
+		end = height
+	}
+	if start < genesis.BlockNumber || start > end || end > height {
+		return fmt.Errorf("invalid range: %d to %d  local height: %d", start, end, height)
+	}
+
+	header := &blockfile.ArchiveHeader{
+		Chain:   mode.ChainName(),
+		Start:   start,
+		End:     end,
+		Digests: make([]blockdigest.Digest, 0, end-start+1),
+	}
+	for n := start; n <= end; n += 1 {
+		d, err := storage.DigestForBlock(n)
+		if nil != err {
+			return fmt.Errorf("digest for block: %d  error: %s", n, err)
+		}
+		header.Digests = append(header.Digests, *d)
+	}
+
+	archive, err := blockfile.CreateArchive(filename, header)
+	if nil != err {
+		return err
+	}
+	defer archive.Close()
+
+	log.Infof("export: %q  blocks: %d to %d  from: %d", filename, start, end, archive.Next())
+	if archive.Complete() {
+		fmt.Printf("archive is already complete\n")
+		return nil
+	}
+	if archive.Next() > start {
+		fmt.Printf("resuming at block: %d\n", archive.Next())
+	}
+
+	// connected only if a block is not kept in the database
+	node := (*peer.Node)(nil)
+	defer func() {
+		if nil != node {
+			node.Close()
+		}
+	}()
+
+	progress := newProgress(log, "written", archive.Next()-1)
+	for archive.Next() <= end {
+		n := archive.Next()
+
+		packed, err := storage.GetRawBlock(n, header.Digests[n-start])
+		if nil != err {
+			return err
+		}
+		if nil != packed {
+			err = archive.Write(packed)
+			if nil != err {
+				return fmt.Errorf("kept block: %d  error: %s", n, err)
+			}
+			progress.count += 1
+			progress.height = n
+			progress.report(false)
+			continue
+		}
+
+		if nil == node {
+			node, err = peer.Connect(configuration)
+			if nil != err {
+				return fmt.Errorf("connect to node: %s", err)
+			}
+			if node.Height() < end {
+				return fmt.Errorf("node: %s  height: %d  is below: %d", node.Client(), node.Height(), end)
+			}
+		}
+
+		count := exportWindow
+		if end-n+1 < uint64(count) {
+			count = int(end - n + 1)
+		}
+
+		// write what arrived before any failure, so a rerun resumes after it
+		blocks, fetchErr := node.Client().Blocks(context.Background(), n, count)
+		for _, packed := range blocks {
+			err := archive.Write(packed)
+			if blockfile.ErrDigestMismatch == err {
+				return fmt.Errorf("block: %d  from node: %s  differs from the local chain", archive.Next(), node.Client())
+			} else if nil != err {
+				return err
+			}
+			progress.count += 1
+			progress.height = archive.Next() - 1
+			progress.report(false)
+		}
+		if nil != fetchErr {
+			return fmt.Errorf("fetch block: %d  error: %s", archive.Next(), fetchErr)
+		}
+	}
+
+	err = archive.Close()
+	if nil != err {
+		return err
+	}
+	progress.report(true)
+	fmt.Printf("archive complete: %q\n", filename)
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/logger"
+
+	"github.com/bitmark-inc/updaterd/blockfile"
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// a stream or an archive
+type blockReader interface {
+	Next() ([]byte, error)
+	Offset() int64
+}
+
+// store the blocks of an archive made by export-blocks or of a stream
+// of length prefixed packed blocks
+//
+// blocks already stored with the same digest are skipped, so an
+// interrupted import can simply be run again; a block that differs
+// from the local chain is a fork, which stops the import unless
+// replace is set, in which case the local blocks from that point are
+// deleted and the file is used
+func importBlocks(log *logger.L, filename string, replace bool) error {
+
+	f, err := os.Open(filename)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+
+	height, err := storage.GetBlockHeight()
+	if nil != err {
+		return err
+	}
+	log.Infof("import: %q  local height: %d  replace: %v", filename, height, replace)
+
+	reader, err := openBlockFile(f)
+	if nil != err {
+		return err
+	}
+	progress := newProgress(log, "stored", height)
+
+	for {
+		offset := reader.Offset()
+		packed, err := reader.Next()
+		if io.EOF == err {
+			break
+		} else if nil != err {
+			return fmt.Errorf("read at offset: %d  error: %s", offset, err)
+		}
+
+		header, digest, err := storage.CheckBlock(packed)
+		if nil != err {
+			return fmt.Errorf("invalid block at offset: %d  error: %s", offset, err)
+		}
+		n := header.Number
+
+		if n > height+1 {
+			return fmt.Errorf("missing blocks: %d to %d", height+1, n-1)
+		}
+
+		// already stored, or a fork
+		if n <= height {
+			local, err := storage.DigestForBlock(n)
+			if nil != err {
+				return err
+			}
+			if *local == digest {
+				progress.skipped += 1
+				progress.report(false)
+				continue
+			}
+			if !replace {
+				return fmt.Errorf("fork at block: %d  local: %s  file: %s", n, local, digest)
+			}
+
+			log.Warnf("fork at block: %d  local: %s  file: %s  replacing local blocks", n, local, digest)
+			err = storage.DeleteDownToBlock(n, n-1, storage.ReorgImport)
+			if nil != err {
+				return err
+			}
+			height = n - 1
+		}
+
+		// the file must continue the local chain
+		previous, err := storage.DigestForBlock(height)
+		if nil != err {
+			return err
+		}
+		if *previous != header.PreviousBlock {
+			return fmt.Errorf("block: %d  does not follow local block: %d  the file starts after a fork", n, height)
+		}
+
+		err = storage.StoreBlock(packed)
+		if nil != err {
+			return fmt.Errorf("store block: %d  error: %s", n, err)
+		}
+		height = n
+		progress.count += 1
+		progress.height = n
+		progress.report(false)
+	}
+
+	progress.report(true)
+	return nil
+}
+
+// an archive if the file starts with the archive magic, otherwise a
+// plain stream
+func openBlockFile(f *os.File) (blockReader, error) {
+	magic := make([]byte, 8)
+	n, err := io.ReadFull(f, magic)
+	if nil != err && io.ErrUnexpectedEOF != err && io.EOF != err {
+		return nil, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if nil != err {
+		return nil, err
+	}
+
+	if !blockfile.IsArchive(magic[:n]) {
+		return blockfile.NewReader(f), nil
+	}
+
+	archive, err := blockfile.OpenArchive(f)
+	if nil != err {
+		return nil, err
+	}
+	header := archive.Header()
+	if header.Chain != mode.ChainName() {
+		return nil, fmt.Errorf("archive chain: %q  expected: %q", header.Chain, mode.ChainName())
+	}
+	fmt.Printf("archive: chain: %s  blocks: %d to %d\n", header.Chain, header.Start, header.End)
+	return archive, nil
+}
@@ -18,6 +18,10 @@ import (
 	"github.com/bitmark-inc/getoptions"
 	"github.com/bitmark-inc/logger"
 
+	"github.com/bitmark-inc/updaterd/capture"
+	"github.com/bitmark-inc/updaterd/control"
+	"github.com/bitmark-inc/updaterd/deadletter"
+	"github.com/bitmark-inc/updaterd/monitor"
 	"github.com/bitmark-inc/updaterd/peer"
 	"github.com/bitmark-inc/updaterd/storage"
 )
@@ -37,6 +41,8 @@ func main() {
 		{Long: "version", HasArg: getoptions.NO_ARGUMENT, Short: 'V'},
 		{Long: "config-file", HasArg: getoptions.REQUIRED_ARGUMENT, Short: 'c'},
 		{Long: "set", HasArg: getoptions.REQUIRED_ARGUMENT, Short: 's'},
+		{Long: "from", HasArg: getoptions.REQUIRED_ARGUMENT}, // for reindex
+		{Long: "dry-run", HasArg: getoptions.NO_ARGUMENT},
 	}
 
 	program, options, arguments, err := getoptions.GetOS(flags)
@@ -48,8 +54,26 @@ func main() {
 		exitwithstatus.Message("%s: version: %s", program, version)
 	}
 
+	// synchronise without writing, same as the verify-sync command
+	if len(options["dry-run"]) > 0 {
+		if len(arguments) > 0 {
+			exitwithstatus.Message("%s: --dry-run cannot be used with a command", program)
+		}
+		arguments = []string{"verify-sync"}
+	}
+
+	// option of a command, pass it back to the command
+	if len(options["from"]) > 0 {
+		if 0 == len(arguments) || "reindex" != arguments[0] {
+			exitwithstatus.Message("%s: --from can only be used with reindex", program)
+		}
+		for _, from := range options["from"] {
+			arguments = append(arguments, "--from="+from)
+		}
+	}
+
 	if len(options["help"]) > 0 {
-		exitwithstatus.Message("usage: %s [--help] [--verbose] [--quiet] --config-file=FILE [[command|help] arguments...]", program)
+		exitwithstatus.Message("usage: %s [--help] [--verbose] [--quiet] [--dry-run] --config-file=FILE [[command|help] arguments...]", program)
 	}
 
 	if 1 != len(options["config-file"]) {
@@ -91,6 +115,16 @@ func main() {
 	// start of real main
 	// ------------------
 
+	// commands sent to an already running process
+	if len(arguments) > 0 && processControlCommand(log, arguments, masterConfiguration) {
+		return
+	}
+
+	// commands that only read the database
+	if len(arguments) > 0 && processQueryCommand(log, arguments, masterConfiguration) {
+		return
+	}
+
 	// optional PID file
 	// use if not running under a supervisor program like daemon(8)
 	if "" != masterConfiguration.PidFile {
@@ -108,6 +142,9 @@ func main() {
 
 	// command processing - need lock so do not affect an already running process
 	// these commands process data needed for initial setup
+	if len(arguments) > 0 && processDataCommand(log, arguments, masterConfiguration) {
+		return
+	}
 	if len(arguments) > 0 {
 		processSetupCommand(log, arguments, masterConfiguration)
 		return
@@ -145,6 +182,22 @@ func main() {
 	}
 	defer storage.Finalise()
 
+	// keep subscriber payloads that fail to store
+	err = deadletter.Initialise(&masterConfiguration.DeadLetter)
+	if nil != err {
+		log.Criticalf("dead letter initialise error: %s", err)
+		exitwithstatus.Message("dead letter initialise error: %s", err)
+	}
+	defer deadletter.Finalise()
+
+	// optional record of all node traffic for replay
+	err = capture.Initialise(&masterConfiguration.Capture)
+	if nil != err {
+		log.Criticalf("capture initialise error: %s", err)
+		exitwithstatus.Message("capture initialise error: %s", err)
+	}
+	defer capture.Finalise()
+
 	// initialise encryption
 	err = zmqutil.StartAuthentication()
 	if nil != err {
@@ -160,6 +213,22 @@ func main() {
 	}
 	defer peer.Finalise()
 
+	// optional HTTP endpoint for metrics
+	err = monitor.Initialise(&masterConfiguration.Monitor)
+	if nil != err {
+		log.Criticalf("monitor initialise error: %s", err)
+		exitwithstatus.Message("monitor initialise error: %s", err)
+	}
+	defer monitor.Finalise()
+
+	// local socket for administration
+	err = control.Initialise(&masterConfiguration.Control)
+	if nil != err {
+		log.Criticalf("control initialise error: %s", err)
+		exitwithstatus.Message("control initialise error: %s", err)
+	}
+	defer control.Finalise()
+
 	// wait for CTRL-C before shutting down to allow manual testing
 	if 0 == len(options["quiet"]) {
 		fmt.Printf("\n\nWaiting for CTRL-C (SIGINT) or 'kill <pid>' (SIGTERM)…")
@@ -169,6 +238,7 @@ func main() {
 	ch := make(chan os.Signal)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-ch
+
 	log.Infof("received signal: %v", sig)
 	if 0 == len(options["quiet"]) {
 		fmt.Printf("\nreceived signal: %v\n", sig)
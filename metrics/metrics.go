@@ -0,0 +1,414 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the kinds of metric in the exposition format
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// default latency buckets in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// anything that can write itself in the exposition format
+type collector interface {
+	write(w io.Writer) error
+}
+
+// all registered metrics in order of registration
+var registry struct {
+	sync.Mutex
+	names      map[string]struct{}
+	collectors []collector
+}
+
+// add a collector to the registry, duplicate names are a programming error
+func register(name string, c collector) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if nil == registry.names {
+		registry.names = make(map[string]struct{})
+	}
+	if _, ok := registry.names[name]; ok {
+		panic("metrics: duplicate registration: " + name)
+	}
+	registry.names[name] = struct{}{}
+	registry.collectors = append(registry.collectors, c)
+}
+
+// Write - output all registered metrics in the Prometheus text format
+func Write(w io.Writer) error {
+	registry.Lock()
+	collectors := make([]collector, len(registry.collectors))
+	copy(collectors, registry.collectors)
+	registry.Unlock()
+
+	for _, c := range collectors {
+		if err := c.write(w); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counter - monotonically increasing value
+type Counter struct {
+	value uint64
+}
+
+// Inc - add one to the counter
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add - add n to the counter
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value - current value of the counter
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge - value that can go up and down
+type Gauge struct {
+	bits uint64
+}
+
+// Set - replace the value of the gauge
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Add - add a (possibly negative) amount to the gauge
+func (g *Gauge) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		n := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&g.bits, old, n) {
+			return
+		}
+	}
+}
+
+// Value - current value of the gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Histogram - distribution of observed values in cumulative buckets
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+	return &Histogram{
+		buckets: b,
+		counts:  make([]uint64, len(b)),
+	}
+}
+
+// Observe - record a single value
+func (h *Histogram) Observe(v float64) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i] += 1
+		}
+	}
+	h.count += 1
+	h.sum += v
+}
+
+// ObserveDuration - record the time elapsed since start in seconds
+func (h *Histogram) ObserveDuration(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// a metric family with zero or more labels
+type family struct {
+	sync.Mutex
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+	order      []string               // label keys in creation order
+	values     map[string]interface{} // *Counter, *Gauge or *Histogram
+	labels     map[string][]string
+}
+
+func newFamily(name string, help string, kind string, labelNames []string, buckets []float64) *family {
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		values:     make(map[string]interface{}),
+		labels:     make(map[string][]string),
+	}
+	register(name, f)
+	return f
+}
+
+// fetch or create the value for a specific set of label values
+func (f *family) with(labelValues []string) interface{} {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s: expected %d label values, got: %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.Lock()
+	defer f.Unlock()
+
+	if v, ok := f.values[key]; ok {
+		return v
+	}
+
+	var v interface{}
+	switch f.kind {
+	case kindCounter:
+		v = &Counter{}
+	case kindGauge:
+		v = &Gauge{}
+	case kindHistogram:
+		v = newHistogram(f.buckets)
+	}
+	lv := make([]string, len(labelValues))
+	copy(lv, labelValues)
+
+	f.values[key] = v
+	f.labels[key] = lv
+	f.order = append(f.order, key)
+	return v
+}
+
+// output a family
+func (f *family) write(w io.Writer) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind); nil != err {
+		return err
+	}
+
+	for _, key := range f.order {
+		labels := f.labels[key]
+		switch v := f.values[key].(type) {
+		case *Counter:
+			if err := writeSample(w, f.name, f.labelNames, labels, "", "", float64(v.Value())); nil != err {
+				return err
+			}
+		case *Gauge:
+			if err := writeSample(w, f.name, f.labelNames, labels, "", "", v.Value()); nil != err {
+				return err
+			}
+		case *Histogram:
+			v.Lock()
+			for i, upper := range v.buckets {
+				err := writeSample(w, f.name+"_bucket", f.labelNames, labels, "le", formatFloat(upper), float64(v.counts[i]))
+				if nil != err {
+					v.Unlock()
+					return err
+				}
+			}
+			err := writeSample(w, f.name+"_bucket", f.labelNames, labels, "le", "+Inf", float64(v.count))
+			if nil == err {
+				err = writeSample(w, f.name+"_sum", f.labelNames, labels, "", "", v.sum)
+			}
+			if nil == err {
+				err = writeSample(w, f.name+"_count", f.labelNames, labels, "", "", float64(v.count))
+			}
+			v.Unlock()
+			if nil != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CounterVec - counters partitioned by label values
+type CounterVec struct {
+	f *family
+}
+
+// NewCounter - register an unlabelled counter
+func NewCounter(name string, help string) *Counter {
+	return newFamily(name, help, kindCounter, nil, nil).with(nil).(*Counter)
+}
+
+// NewCounterVec - register a labelled counter
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{f: newFamily(name, help, kindCounter, labelNames, nil)}
+}
+
+// With - counter for the given label values
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.f.with(labelValues).(*Counter)
+}
+
+// GaugeVec - gauges partitioned by label values
+type GaugeVec struct {
+	f *family
+}
+
+// NewGauge - register an unlabelled gauge
+func NewGauge(name string, help string) *Gauge {
+	return newFamily(name, help, kindGauge, nil, nil).with(nil).(*Gauge)
+}
+
+// NewGaugeVec - register a labelled gauge
+func NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{f: newFamily(name, help, kindGauge, labelNames, nil)}
+}
+
+// With - gauge for the given label values
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.f.with(labelValues).(*Gauge)
+}
+
+// HistogramVec - histograms partitioned by label values
+type HistogramVec struct {
+	f *family
+}
+
+// NewHistogram - register an unlabelled histogram
+func NewHistogram(name string, help string, buckets []float64) *Histogram {
+	return newFamily(name, help, kindHistogram, nil, buckets).with(nil).(*Histogram)
+}
+
+// NewHistogramVec - register a labelled histogram
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{f: newFamily(name, help, kindHistogram, labelNames, buckets)}
+}
+
+// With - histogram for the given label values
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.f.with(labelValues).(*Histogram)
+}
+
+// Sample - a single labelled value returned by a gauge function
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// a gauge whose values are computed when scraped
+type gaugeFunc struct {
+	name       string
+	help       string
+	labelNames []string
+	fn         func() []Sample
+}
+
+// NewGaugeFunc - register a labelled gauge computed on each scrape
+func NewGaugeFunc(name string, help string, fn func() []Sample, labelNames ...string) {
+	register(name, &gaugeFunc{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		fn:         fn,
+	})
+}
+
+func (g *gaugeFunc) write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", g.name, escapeHelp(g.help), g.name, kindGauge); nil != err {
+		return err
+	}
+	for _, s := range g.fn() {
+		if len(s.Labels) != len(g.labelNames) {
+			continue
+		}
+		if err := writeSample(w, g.name, g.labelNames, s.Labels, "", "", s.Value); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// write one line: name{labels} value
+func writeSample(w io.Writer, name string, labelNames []string, labelValues []string, extraName string, extraValue string, value float64) error {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labelNames) > 0 || "" != extraName {
+		b.WriteByte('{')
+		for i, n := range labelNames {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(n)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labelValues[i]))
+			b.WriteByte('"')
+		}
+		if "" != extraName {
+			if len(labelNames) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(extraName)
+			b.WriteString(`="`)
+			b.WriteString(extraValue)
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// the exposition text of one collector
+func output(t *testing.T, c collector) string {
+	var buffer bytes.Buffer
+	err := c.write(&buffer)
+	if nil != err {
+		t.Fatalf("write error: %s", err)
+	}
+	return buffer.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "a counter")
+	c.Inc()
+	c.Add(41)
+
+	expected := "# HELP test_counter_total a counter\n" +
+		"# TYPE test_counter_total counter\n" +
+		"test_counter_total 42\n"
+	actual := output(t, registered(t, "test_counter_total"))
+	if expected != actual {
+		t.Errorf("actual:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestCounterVecLabels(t *testing.T) {
+	c := NewCounterVec("test_labelled_total", "help with \\ and\nnewline", "kind", "status")
+	c.With("asset", "confirmed").Add(2)
+	c.With("quote\"back\\slash\nline", "pending").Inc()
+	c.With("asset", "confirmed").Inc()
+
+	expected := "# HELP test_labelled_total help with \\\\ and\\nnewline\n" +
+		"# TYPE test_labelled_total counter\n" +
+		"test_labelled_total{kind=\"asset\",status=\"confirmed\"} 3\n" +
+		"test_labelled_total{kind=\"quote\\\"back\\\\slash\\nline\",status=\"pending\"} 1\n"
+	actual := output(t, c.f)
+	if expected != actual {
+		t.Errorf("actual:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestCounterVecWrongLabels(t *testing.T) {
+	c := NewCounterVec("test_wrong_labels_total", "wrong labels", "kind")
+	defer func() {
+		if nil == recover() {
+			t.Errorf("no panic for a missing label value")
+		}
+	}()
+	c.With()
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGaugeVec("test_gauge", "a gauge", "node")
+	g.With("one").Set(1.5)
+	g.With("one").Add(-3)
+	g.With("two").Set(math.Inf(+1))
+	g.With("three").Set(math.NaN())
+
+	expected := "# HELP test_gauge a gauge\n" +
+		"# TYPE test_gauge gauge\n" +
+		"test_gauge{node=\"one\"} -1.5\n" +
+		"test_gauge{node=\"two\"} +Inf\n" +
+		"test_gauge{node=\"three\"} NaN\n"
+	actual := output(t, g.f)
+	if expected != actual {
+		t.Errorf("actual:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "a histogram", []float64{1, 0.5}, "kind")
+	h.With("store").Observe(0.25)
+	h.With("store").Observe(0.75)
+	h.With("store").Observe(2)
+
+	// buckets are sorted and cumulative
+	expected := "# HELP test_seconds a histogram\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{kind=\"store\",le=\"0.5\"} 1\n" +
+		"test_seconds_bucket{kind=\"store\",le=\"1\"} 2\n" +
+		"test_seconds_bucket{kind=\"store\",le=\"+Inf\"} 3\n" +
+		"test_seconds_sum{kind=\"store\"} 3\n" +
+		"test_seconds_count{kind=\"store\"} 3\n"
+	actual := output(t, h.f)
+	if expected != actual {
+		t.Errorf("actual:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestUnlabelledHistogram(t *testing.T) {
+	h := NewHistogram("test_unlabelled_seconds", "no labels", []float64{0.1})
+	h.Observe(0.1)
+
+	expected := "# HELP test_unlabelled_seconds no labels\n" +
+		"# TYPE test_unlabelled_seconds histogram\n" +
+		"test_unlabelled_seconds_bucket{le=\"0.1\"} 1\n" +
+		"test_unlabelled_seconds_bucket{le=\"+Inf\"} 1\n" +
+		"test_unlabelled_seconds_sum 0.1\n" +
+		"test_unlabelled_seconds_count 1\n"
+	actual := output(t, registered(t, "test_unlabelled_seconds"))
+	if expected != actual {
+		t.Errorf("actual:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	NewGaugeFunc("test_computed", "computed on scrape", func() []Sample {
+		return []Sample{
+			{Labels: []string{"a"}, Value: 1},
+			{Labels: []string{"b", "extra"}, Value: 2}, // wrong label count, skipped
+			{Labels: []string{"c"}, Value: 3},
+		}
+	}, "name")
+
+	expected := "# HELP test_computed computed on scrape\n" +
+		"# TYPE test_computed gauge\n" +
+		"test_computed{name=\"a\"} 1\n" +
+		"test_computed{name=\"c\"} 3\n"
+	actual := output(t, registered(t, "test_computed"))
+	if expected != actual {
+		t.Errorf("actual:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	NewGauge("test_duplicate", "first")
+	defer func() {
+		if nil == recover() {
+			t.Errorf("no panic for a duplicate name")
+		}
+	}()
+	NewCounter("test_duplicate", "second")
+}
+
+func TestWriteOrder(t *testing.T) {
+	NewGauge("test_order_first", "first")
+	NewGauge("test_order_second", "second")
+
+	var buffer bytes.Buffer
+	err := Write(&buffer)
+	if nil != err {
+		t.Fatalf("write error: %s", err)
+	}
+	s := buffer.String()
+	first := strings.Index(s, "# HELP test_order_first ")
+	second := strings.Index(s, "# HELP test_order_second ")
+	if first < 0 || second < first {
+		t.Errorf("first at: %d  second at: %d", first, second)
+	}
+}
+
+// the collector registered with a name
+func registered(t *testing.T, name string) collector {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, c := range registry.collectors {
+		switch v := c.(type) {
+		case *family:
+			if name == v.name {
+				return c
+			}
+		case *gaugeFunc:
+			if name == v.name {
+				return c
+			}
+		}
+	}
+	t.Fatalf("not registered: %s", name)
+	return nil
+}
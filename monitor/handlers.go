@@ -0,0 +1,119 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bitmark-inc/updaterd/metrics"
+	"github.com/bitmark-inc/updaterd/peer"
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// all registered metrics in the Prometheus text format
+func (srv *server) metrics(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	err := metrics.Write(w)
+	if nil != err {
+		srv.log.Errorf("metrics write error: %s", err)
+	}
+}
+
+// response body for the health and readiness checks
+type checkReply struct {
+	Status       string            `json:"status"`
+	Reason       string            `json:"reason,omitempty"`
+	LocalHeight  uint64            `json:"local_height"`
+	RemoteHeight uint64            `json:"remote_height"`
+	State        string            `json:"state"`
+	Mode         string            `json:"mode"`
+	Nodes        []peer.NodeStatus `json:"nodes"`
+
+	synchronised bool
+}
+
+// liveness: database reachable and at least one node connected
+func (srv *server) healthz(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	reply, localErr := newCheckReply()
+
+	switch {
+	case nil != localErr:
+		reply.Reason = "database: " + localErr.Error()
+	case 0 == peer.GetStatus().RespondingNodes():
+		reply.Reason = "no nodes connected"
+	}
+
+	srv.writeCheck(w, reply)
+}
+
+// readiness: sampling and not too far behind the nodes
+func (srv *server) readyz(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	reply, localErr := newCheckReply()
+
+	switch {
+	case nil != localErr:
+		reply.Reason = "database: " + localErr.Error()
+	case !reply.synchronised:
+		reply.Reason = "not synchronised: " + reply.State
+	case reply.RemoteHeight > reply.LocalHeight && reply.RemoteHeight-reply.LocalHeight > srv.readyLag:
+		reply.Reason = fmt.Sprintf("lagging: %d blocks behind", reply.RemoteHeight-reply.LocalHeight)
+	}
+
+	srv.writeCheck(w, reply)
+}
+
+// collect the common status fields
+func newCheckReply() (*checkReply, error) {
+	s := peer.GetStatus()
+	reply := &checkReply{
+		RemoteHeight: s.RemoteHeight,
+		State:        s.State,
+		Mode:         s.Mode,
+		Nodes:        s.Nodes,
+		synchronised: s.IsSynchronised(),
+	}
+
+	err := storage.Ping()
+	if nil != err {
+		return reply, err
+	}
+	reply.LocalHeight, err = storage.GetBlockHeight()
+	return reply, err
+}
+
+// a blank reason is success
+func (srv *server) writeCheck(w http.ResponseWriter, reply *checkReply) {
+	code := http.StatusOK
+	reply.Status = "ok"
+	if "" != reply.Reason {
+		code = http.StatusServiceUnavailable
+		reply.Status = "fail"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(reply)
+	if nil != err {
+		srv.log.Errorf("check write error: %s", err)
+	}
+}
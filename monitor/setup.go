@@ -0,0 +1,166 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/background"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// timeouts for the HTTP server
+const (
+	readTimeout  = 10 * time.Second
+	writeTimeout = 30 * time.Second
+)
+
+// a block of configuration data
+// this is read from a lua configuration file
+type Configuration struct {
+	Listen   string `gluamapper:"listen" json:"listen"`       // host:port, blank to disable
+	ReadyLag uint64 `gluamapper:"ready_lag" json:"ready_lag"` // maximum blocks behind the nodes to be ready
+}
+
+// globals for background proccess
+type monitorData struct {
+	sync.RWMutex // to allow locking
+
+	// logger
+	log *logger.L
+
+	srv server // the HTTP server
+
+	// for background
+	background *background.T
+
+	// set once during initialise
+	initialised bool
+}
+
+// global data
+var globalData monitorData
+
+// initialise the monitor HTTP server if a listen address is configured
+func Initialise(configuration *Configuration) error {
+
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	// no need to start if already started
+	if globalData.initialised {
+		return fault.ErrAlreadyInitialised
+	}
+
+	globalData.log = logger.New("monitor")
+	globalData.log.Info("starting…")
+
+	if "" == configuration.Listen {
+		globalData.log.Info("disabled: no listen address")
+		return nil
+	}
+
+	if err := globalData.srv.initialise(configuration.Listen, configuration.ReadyLag); nil != err {
+		return err
+	}
+
+	// all data initialised
+	globalData.initialised = true
+
+	// start background processes
+	globalData.log.Info("start background…")
+
+	var processes = background.Processes{
+		&globalData.srv,
+	}
+
+	globalData.background = background.Start(processes, globalData.log)
+
+	return nil
+}
+
+// finialise - stop all background tasks
+func Finalise() error {
+	globalData.Lock()
+	defer globalData.Unlock()
+
+	if !globalData.initialised {
+		return fault.ErrNotInitialised
+	}
+
+	globalData.log.Info("shutting down…")
+	globalData.log.Flush()
+
+	// stop background
+	globalData.background.Stop()
+
+	// finally...
+	globalData.initialised = false
+
+	return nil
+}
+
+// data for the HTTP server
+type server struct {
+	log      *logger.L
+	listener net.Listener
+	http     *http.Server
+	readyLag uint64
+}
+
+// bind the listening socket so that errors are reported at startup
+func (srv *server) initialise(listen string, readyLag uint64) error {
+
+	log := logger.New("http")
+	srv.log = log
+	srv.readyLag = readyLag
+
+	log.Info("initialising…")
+
+	listener, err := net.Listen("tcp", listen)
+	if nil != err {
+		log.Errorf("listen: %q  error: %s", listen, err)
+		return err
+	}
+	srv.listener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", srv.metrics)
+	mux.HandleFunc("/healthz", srv.healthz)
+	mux.HandleFunc("/readyz", srv.readyz)
+
+	srv.http = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+
+	log.Infof("listening on: %q", listener.Addr())
+
+	return nil
+}
+
+// serve requests until shutdown
+func (srv *server) Run(args interface{}, shutdown <-chan struct{}) {
+
+	log := srv.log
+
+	log.Info("starting…")
+
+	go func() {
+		err := srv.http.Serve(srv.listener)
+		if nil != err && http.ErrServerClosed != err {
+			log.Errorf("serve error: %s", err)
+		}
+	}()
+
+	<-shutdown
+	log.Info("shutting down…")
+	srv.http.Close()
+}
@@ -6,29 +6,30 @@ package peer
 
 import (
 	"bytes"
-	"encoding/binary"
+	"context"
 	"encoding/hex"
-	"encoding/json"
 	"time"
 
 	"github.com/bitmark-inc/bitmarkd/blockdigest"
 	"github.com/bitmark-inc/bitmarkd/fault"
 	"github.com/bitmark-inc/bitmarkd/genesis"
 	"github.com/bitmark-inc/bitmarkd/mode"
-	"github.com/bitmark-inc/bitmarkd/util"
 	"github.com/bitmark-inc/logger"
 	zmq "github.com/pebbe/zmq4"
 
+	"github.com/bitmark-inc/updaterd/capture"
+	"github.com/bitmark-inc/updaterd/peer/rpc"
 	"github.com/bitmark-inc/updaterd/storage"
 	"github.com/bitmark-inc/updaterd/zmqutil"
 )
 
 // various timeouts
 const (
-	cycleInterval       = 10 * time.Second // pause to limit bandwidth
-	connectorTimeout    = 30 * time.Second // time out for connections
-	samplelingLimit     = 10               // number of cycles to be 1 block out of sync before resync
-	fetchBlocksPerCycle = 500              // number of blocks to fetch in one set
+	cycleInterval        = 10 * time.Second // pause to limit bandwidth
+	connectorTimeout     = 30 * time.Second // time out for connections
+	samplelingLimit      = 10               // number of cycles to be 1 block out of sync before resync
+	fetchBlocksPerCycle  = 500              // number of blocks to fetch in one set
+	resolveAfterFailures = 3                // failed requests before looking up a node host name again
 )
 
 // a state type for the thread
@@ -44,28 +45,48 @@ const (
 	cStateSampling     connectorState = iota // signal resync complete and sample nodes to see if out of sync occurs
 )
 
-// type to hold server info (see bitmarkd/peer/listener.go for full record)
-type serverInfo struct {
-	Version string `json:"version"`
-	Chain   string `json:"chain"`
-	Normal  bool   `json:"normal"`
-	Height  uint64 `json:"height"`
-}
-
 // data for the connector
 type connector struct {
 	log     *logger.L
 	clients []*zmqutil.Client
+	nodes   []*rpc.Client // requests over the clients
 	state   connectorState
 
-	theClient          *zmqutil.Client // client to fetch blocak data from
-	startBlockNumber   uint64          // block number wher local chain forks
-	highestBlockNumber uint64          // block number on best node
-	samples            int             // counter to detect missed block broadcast
+	attributes  []nodeAttributes // for each node, to choose where to fetch from
+	quorum      int
+	eligibility *eligibility
+	excluded    []string // for each node, why it is not eligible or blank
+
+	// keys for connecting nodes added after initialise
+	privateKey []byte
+	publicKey  []byte
+
+	// optional DEALER clients for pipelined block fetches
+	dealers   []*zmqutil.Client
+	pipelines []*zmqutil.Pipeline
+	pipeline  int // outstanding requests, 0 => no DEALER clients
+	window    int // blocks requested together
+
+	resolveInterval time.Duration // between host name lookups, 0 => only after failures
+	resolveAt       time.Time
+
+	discovery  *discovery      // nil => only static nodes
+	discovered map[string]bool // public keys of nodes from the discovery pool
+
+	theClient          *rpc.Client // node to fetch blocak data from
+	startBlockNumber   uint64      // block number wher local chain forks
+	highestBlockNumber uint64      // block number on best node
+	samples            int         // counter to detect missed block broadcast
+	lastError          string      // most recent failure for the sync status
+	recorded           *storage.SyncStatus
+
+	requests chan controlRequest // from the admin socket
+	paused   bool                // skip cycles until resumed
+	resync   chan struct{}       // from the storage workers, taken at the next cycle
 }
 
 // initialise the connector
-func (conn *connector) initialise(privateKey []byte, publicKey []byte, connections []Connection) error {
+func (conn *connector) initialise(privateKey []byte, publicKey []byte, connections []Connection, pipeline int, quorum int, eligibility *eligibility, resolveInterval time.Duration, pool *discovery) error {
 
 	log := logger.New("connector")
 	conn.log = log
@@ -78,19 +99,33 @@ func (conn *connector) initialise(privateKey []byte, publicKey []byte, connectio
 		log.Error("zero connection connections are available")
 		return fault.ErrNoConnectionsAvailable
 	}
-	conn.clients = make([]*zmqutil.Client, connectionCount)
+	conn.privateKey = privateKey
+	conn.publicKey = publicKey
+	conn.clients = make([]*zmqutil.Client, 0, connectionCount)
+	conn.nodes = make([]*rpc.Client, 0, connectionCount)
+	conn.attributes = make([]nodeAttributes, 0, connectionCount)
+	conn.excluded = make([]string, 0, connectionCount)
+	conn.quorum = quorum
+	conn.eligibility = eligibility
+	conn.requests = make(chan controlRequest)
+	conn.resync = make(chan struct{}, 1)
+	conn.resolveInterval = resolveInterval
+	conn.resolveAt = time.Now().Add(resolveInterval)
+	conn.discovery = pool
+	conn.discovered = make(map[string]bool)
+	conn.pipeline = pipeline
+	conn.window = 1
+	if pipeline > 0 {
+		conn.window = pipeline
+		conn.dealers = make([]*zmqutil.Client, 0, connectionCount)
+		conn.pipelines = make([]*zmqutil.Pipeline, 0, connectionCount)
+	}
 
 	// error code for goto fail
 	errX := error(nil)
 
 	// initially connect all static sockets
 	for i, c := range connections {
-		address, err := util.NewConnection(c.Connect)
-		if nil != err {
-			log.Errorf("client[%d]=address: %q  error: %s", i, c.Connect, err)
-			errX = err
-			goto fail
-		}
 		serverPublicKey, err := hex.DecodeString(c.PublicKey)
 		if nil != err {
 			log.Errorf("client[%d]=public: %q  error: %s", i, c.PublicKey, err)
@@ -105,35 +140,124 @@ func (conn *connector) initialise(privateKey []byte, publicKey []byte, connectio
 			goto fail
 		}
 
-		client, err := zmqutil.NewClient(zmq.REQ, privateKey, publicKey, connectorTimeout)
+		err = conn.addNode(c.Connect, serverPublicKey, c.attributes())
 		if nil != err {
-			log.Errorf("client[%d]=%q  error: %s", i, address, err)
+			log.Errorf("client[%d]=%q  error: %s", i, c.Connect, err)
 			errX = err
 			goto fail
 		}
-
-		conn.clients[i] = client
-
-		err = client.Connect(address, serverPublicKey, mode.ChainName())
-		if nil != err {
-			log.Errorf("connect[%d]=%q  error: %s", i, address, err)
-			errX = err
-			goto fail
-		}
-		log.Infof("public key: %x  at: %q", serverPublicKey, c.Connect)
 	}
 
 	// start state machine
 	conn.state = cStateConnecting
+	setConnectorStateMetric(conn.state)
+	setConnectorStatus(conn.state, 0)
 
 	return nil
 
 	// error handling
 fail:
-	zmqutil.CloseClients(conn.clients)
+	conn.close()
 	return errX
 }
 
+// connect to a node and add it to the end of the node list
+func (conn *connector) addNode(hostPort string, serverPublicKey []byte, attributes nodeAttributes) error {
+	log := conn.log
+
+	client, err := zmqutil.NewClient(zmq.REQ, conn.privateKey, conn.publicKey, connectorTimeout)
+	if nil != err {
+		return err
+	}
+
+	// a name that does not resolve yet is retried by resolve()
+	err = client.ConnectHost(hostPort, serverPublicKey, mode.ChainName())
+	if zmqutil.IsLookupError(err) {
+		log.Warnf("connect: %q  lookup error: %s", hostPort, err)
+	} else if nil != err {
+		client.Close()
+		return err
+	}
+	node := rpc.New(client, connectorTimeout, rpc.DefaultRetry)
+	if capture.Enabled() {
+		node.WithRecorder(capture.Connector)
+	}
+
+	dealer := (*zmqutil.Client)(nil)
+	p := (*zmqutil.Pipeline)(nil)
+	if conn.pipeline > 0 {
+		dealer, err = zmqutil.NewClient(zmq.DEALER, conn.privateKey, conn.publicKey, connectorTimeout)
+		if nil != err {
+			client.Close()
+			return err
+		}
+
+		err = dealer.ConnectHost(hostPort, serverPublicKey, mode.ChainName())
+		if zmqutil.IsLookupError(err) {
+			log.Warnf("dealer connect: %q  lookup error: %s", hostPort, err)
+		} else if nil != err {
+			dealer.Close()
+			client.Close()
+			return err
+		}
+
+		p, err = zmqutil.NewPipeline(dealer)
+		if nil != err {
+			dealer.Close()
+			client.Close()
+			return err
+		}
+		node.WithPipeline(p)
+	}
+
+	// the status reader walks the node list
+	status.Lock()
+	conn.clients = append(conn.clients, client)
+	conn.nodes = append(conn.nodes, node)
+	conn.attributes = append(conn.attributes, attributes)
+	conn.excluded = append(conn.excluded, "")
+	if conn.pipeline > 0 {
+		conn.dealers = append(conn.dealers, dealer)
+		conn.pipelines = append(conn.pipelines, p)
+	}
+	status.record(node).address = client.String()
+	status.Unlock()
+
+	log.Infof("public key: %x  at: %q  priority: %d  weight: %d  group: %q", serverPublicKey, hostPort, attributes.priority, attributes.weight, attributes.group)
+	if conn.pipeline > 0 {
+		log.Infof("pipeline: %d requests  at: %q", conn.pipeline, hostPort)
+	}
+	return nil
+}
+
+// disconnect a node and remove it from the node list
+func (conn *connector) removeNode(i int) {
+	node := conn.nodes[i]
+	conn.log.Infof("remove node: %s  public key: %s", node, node.PublicKey())
+
+	if conn.theClient == node {
+		conn.theClient = nil
+		if cStateSampling != conn.state {
+			conn.state = cStateHighestBlock // choose another node
+		}
+	}
+
+	status.Lock()
+	if conn.pipeline > 0 {
+		conn.pipelines[i].Close()
+		conn.dealers[i].Close()
+		conn.pipelines = append(conn.pipelines[:i], conn.pipelines[i+1:]...)
+		conn.dealers = append(conn.dealers[:i], conn.dealers[i+1:]...)
+	}
+	conn.clients[i].Close()
+	conn.clients = append(conn.clients[:i], conn.clients[i+1:]...)
+	conn.nodes = append(conn.nodes[:i], conn.nodes[i+1:]...)
+	conn.attributes = append(conn.attributes[:i], conn.attributes[i+1:]...)
+	conn.excluded = append(conn.excluded[:i], conn.excluded[i+1:]...)
+	delete(status.nodes, node)
+	status.Unlock()
+}
+
 // various RPC calls to upstream connections
 func (conn *connector) Run(args interface{}, shutdown <-chan struct{}) {
 
@@ -150,10 +274,26 @@ loop:
 		case <-shutdown:
 			break loop
 
+		case req := <-conn.requests:
+			req.reply <- conn.control(req)
+
 		case <-time.After(cycleInterval):
+			if conn.paused {
+				log.Info("paused: skip cycle")
+				continue loop
+			}
 			conn.process()
 		}
 	}
+	conn.close()
+}
+
+// stop the pipelines and close all sockets
+func (conn *connector) close() {
+	for _, p := range conn.pipelines {
+		p.Close()
+	}
+	zmqutil.CloseClients(conn.dealers)
 	zmqutil.CloseClients(conn.clients)
 }
 
@@ -161,25 +301,38 @@ loop:
 func (conn *connector) process() {
 	log := conn.log
 
+	conn.resolve()
+	conn.discover()
+
+	select {
+	case <-conn.resync:
+		log.Warn("resynchronise: block did not follow the local chain")
+		conn.state = cStateHighestBlock
+	default:
+	}
+
 	log.Infof("current state: %s", conn.state)
 
 	switch conn.state {
 	case cStateConnecting:
 		mode.Set(mode.Resynchronise)
-		err := checkNodes(log, conn.clients)
-		if nil != err {
+		if 0 == conn.checkNodes() {
+			err := fault.ErrNoConnectionsAvailable
 			log.Criticalf("connection to node failed: error: %s", err)
 			logger.Panicf("connection to node failed: error: %s", err)
 		}
 		conn.state += 1
 
 	case cStateHighestBlock:
-		conn.highestBlockNumber, conn.theClient = highestBlock(log, conn.clients)
+		conn.checkNodes()
+		conn.highestBlockNumber, conn.theClient = conn.highestBlock()
+		conn.scoreDiscovered()
 		if conn.highestBlockNumber > 0 && nil != conn.theClient {
 			conn.state += 1
 		} else {
 			if conn.theClient == nil {
 				log.Critical("no alived connections in pool, stay in state HighestBlock")
+				conn.lastError = "no nodes responding"
 			}
 		}
 		log.Infof("highest block number: %d", conn.highestBlockNumber)
@@ -207,12 +360,14 @@ func (conn *connector) process() {
 				digest, err := storage.DigestForBlock(h)
 				if nil != err {
 					log.Errorf("block number: %d  local digest error: %s", h, err)
+					conn.lastError = err.Error()
 					conn.state = cStateHighestBlock // retry
 					break check_digests
 				}
 				remoteDigest, err := blockDigest(conn.theClient, h)
 				if nil != err {
 					log.Errorf("block number: %d  fetch digest error: %s", h, err)
+					conn.lastError = err.Error()
 					conn.state = cStateHighestBlock // retry
 					break check_digests
 				} else if remoteDigest == *digest {
@@ -220,9 +375,10 @@ func (conn *connector) process() {
 					log.Infof("fork from block number: %d  digest: %v", conn.startBlockNumber, remoteDigest)
 
 					// remove old blocks
-					err := storage.DeleteDownToBlock(conn.startBlockNumber)
+					err := storage.DeleteDownToBlock(conn.startBlockNumber, conn.highestBlockNumber, storage.ReorgFork)
 					if nil != err {
 						log.Errorf("delete down to block number: %d  error: %s", conn.startBlockNumber, err)
+						conn.lastError = err.Error()
 						conn.state = cStateHighestBlock // retry
 					}
 					break check_digests
@@ -237,46 +393,61 @@ func (conn *connector) process() {
 		log.Infof("highest block number: %d", conn.highestBlockNumber)
 
 	fetch_some_blocks:
-		for n := 0; n < fetchBlocksPerCycle; n += 1 {
+		for n := 0; n < fetchBlocksPerCycle; n += conn.window {
 
 			if conn.startBlockNumber > conn.highestBlockNumber {
 				conn.state = cStateHighestBlock // just in case block height has changed
 				break fetch_some_blocks
 			}
 
-			log.Infof("fetch block number: %d", conn.startBlockNumber)
-			packedBlock, err := blockData(conn.theClient, conn.startBlockNumber)
-			if nil != err {
-				log.Errorf("fetch block number: %d  error: %s", conn.startBlockNumber, err)
-				conn.state = cStateHighestBlock // retry
-				break fetch_some_blocks
+			count := conn.window
+			if remaining := conn.highestBlockNumber - conn.startBlockNumber + 1; uint64(count) > remaining {
+				count = int(remaining)
+			}
+
+			log.Infof("fetch block number: %d  count: %d", conn.startBlockNumber, count)
+			packedBlocks, err := blocksData(conn.theClient, conn.startBlockNumber, count)
+
+			// store whatever arrived in order, even if a later block failed
+			for _, packedBlock := range packedBlocks {
+				log.Debugf("store block number: %d", conn.startBlockNumber)
+				err := storage.StoreBlock(packedBlock)
+				if nil != err {
+					log.Errorf("store block number: %d  error: %s", conn.startBlockNumber, err)
+					conn.lastError = err.Error()
+					conn.state = cStateHighestBlock // retry
+					break fetch_some_blocks
+				}
+
+				// next block
+				conn.startBlockNumber += 1
 			}
-			log.Debugf("store block number: %d", conn.startBlockNumber)
-			err = storage.StoreBlock(packedBlock)
+
 			if nil != err {
-				log.Errorf("store block number: %d  error: %s", conn.startBlockNumber, err)
+				log.Errorf("fetch block number: %d  error: %s", conn.startBlockNumber, err)
+				conn.lastError = err.Error()
 				conn.state = cStateHighestBlock // retry
 				break fetch_some_blocks
 			}
-
-			// next block
-			conn.startBlockNumber += 1
-
 		}
 
 	case cStateRebuild:
 		// return to normal operations
 		conn.state += 1  // next state
 		conn.samples = 0 // zero out the counter
+		conn.lastError = ""
 		mode.Set(mode.Normal)
 
 	case cStateSampling:
 		// check peers
-		conn.highestBlockNumber, conn.theClient = highestBlock(log, conn.clients)
+		conn.checkNodes()
+		conn.highestBlockNumber, conn.theClient = conn.highestBlock()
+		conn.scoreDiscovered()
 		if conn.theClient == nil {
 			conn.state = cStateHighestBlock
+			conn.lastError = "no nodes responding"
 			log.Critical("no alived connections in pool, move state back to HighestBlock")
-			return
+			break
 		}
 		height, err := storage.GetBlockHeight()
 		if nil != err {
@@ -299,172 +470,234 @@ func (conn *connector) process() {
 
 	}
 	log.Debugf("next state: %s", conn.state)
+	setConnectorStateMetric(conn.state)
+	setConnectorStatus(conn.state, conn.highestBlockNumber)
+	conn.recordSyncStatus()
 }
 
-// check all nodes are on the same chain as this program
-func checkNodes(log *logger.L, clients []*zmqutil.Client) error {
+// look up node host names again, on schedule or after repeated
+// failures, the clients reconnect if the address changed
+func (conn *connector) resolve() {
+	log := conn.log
 
-	clientCount := 0
+	now := time.Now()
+	scheduled := 0 != conn.resolveInterval && now.After(conn.resolveAt)
+	if scheduled {
+		conn.resolveAt = now.Add(conn.resolveInterval)
+	}
 
-scan_clients:
-	for _, client := range clients {
-		if !client.IsConnected() {
-			// ***** FIX THIS: should there be code to disable client?
-			continue scan_clients
+	for i, client := range conn.clients {
+		if "" == client.Host() {
+			continue
 		}
-
-		err := client.Send("I")
-		if nil != err {
-			log.Errorf("checkNodes: send error: %s, node: %s", err, client.String())
-			client.Reconnect()
-			continue scan_clients
+		failing := consecutiveFailures(conn.nodes[i]) >= resolveAfterFailures
+		if !scheduled && !failing && client.IsConnected() {
+			continue
 		}
-		data, err := client.Receive(0)
+
+		changed, err := client.Resolve()
+		setNodeAddress(conn.nodes[i])
 		if nil != err {
-			log.Errorf("checkNodes: receive error: %s, node: %s", err, client.String())
-			client.Reconnect()
-			continue scan_clients
+			log.Warnf("resolve: %q  error: %s", client.Host(), err)
+			continue
 		}
-
-		switch string(data[0]) {
-		case "E":
-			log.Errorf("checkNodes: rpc error response: %q", data[1])
-			continue scan_clients
-		case "I":
-			var info serverInfo
-			err = json.Unmarshal(data[1], &info)
+		if nil != conn.dealers {
+			_, err := conn.dealers[i].Resolve()
 			if nil != err {
-				log.Errorf("checkNodes: fail to parse server info: %s.", string(data[1]))
-				continue scan_clients
-			}
-
-			if info.Chain != mode.ChainName() {
-				log.Errorf("checkNodes: expected chain: %q but received: %q", mode.ChainName(), info.Chain)
-				continue scan_clients
+				log.Warnf("resolve dealer: %q  error: %s", client.Host(), err)
 			}
-			clientCount += 1
-		default:
-			log.Errorf("checkNodes: invalid peer response: %s", string(data[1]))
-			continue scan_clients
+		}
+		if changed {
+			log.Infof("resolve: %q  new address: %s", client.Host(), client)
+			resetFailures(conn.nodes[i])
 		}
 	}
-	if 0 == clientCount {
-		return fault.ErrNoConnectionsAvailable
-	}
-	return nil
 }
 
-// determine client with highest block
-func highestBlock(log *logger.L, clients []*zmqutil.Client) (uint64, *zmqutil.Client) {
+// bring the discovered nodes in line with the discovery pool
+func (conn *connector) discover() {
+	if nil == conn.discovery {
+		return
+	}
 
-	h := uint64(0)
-	c := (*zmqutil.Client)(nil)
+	pool := conn.discovery.members()
+	wanted := make(map[string]announcement, len(pool))
+	for _, a := range pool {
+		wanted[hex.EncodeToString(a.publicKey)] = a
+	}
 
-scan_clients:
-	for _, client := range clients {
-		if !client.IsConnected() {
-			continue scan_clients
+	// remove nodes that left the pool or moved
+	for i := len(conn.nodes) - 1; i >= 0; i -= 1 {
+		key := conn.nodes[i].PublicKey()
+		if !conn.discovered[key] {
+			continue
 		}
-
-		err := client.Send("N")
-		if nil != err {
-			log.Errorf("highestBlock: send error: %s", err)
-			client.Reconnect()
-			continue scan_clients
+		a, ok := wanted[key]
+		if ok && a.connect == conn.clients[i].Host() {
+			continue
 		}
+		conn.removeNode(i)
+		delete(conn.discovered, key)
+	}
 
-		data, err := client.Receive(0)
-		if nil != err {
-			log.Errorf("highestBlock: receive error: %s", err)
-			client.Reconnect()
-			continue scan_clients
+	for _, a := range pool {
+		key := hex.EncodeToString(a.publicKey)
+		if conn.discovered[key] {
+			continue
 		}
-		if 2 != len(data) {
-			log.Errorf("highestBlock: received: %d  expected: 2", len(data))
-			continue scan_clients
+		err := conn.addNode(a.connect, a.publicKey, a.attributes(conn.discovery.priority))
+		if nil != err {
+			conn.log.Errorf("discovered node: %s  at: %q  error: %s", key, a.connect, err)
+			conn.discovery.score(key, false)
+			continue
 		}
-		switch string(data[0]) {
-		case "E":
-			log.Errorf("highestBlock: rpc error response: %q", data[1])
-			continue scan_clients
-		case "N":
-			if 8 != len(data[1]) {
-				continue scan_clients
-			}
-			n := binary.BigEndian.Uint64(data[1])
+		conn.discovered[key] = true
+	}
+}
 
-			if n > h {
-				h = n
-				c = client
-			}
-		default:
+// score the discovered nodes after checking heights
+func (conn *connector) scoreDiscovered() {
+	for i, node := range conn.nodes {
+		key := node.PublicKey()
+		if !conn.discovered[key] {
+			continue
 		}
+		ok := "" == conn.excluded[i] && 0 == consecutiveFailures(node)
+		conn.discovery.score(key, ok)
 	}
-	return h, c
 }
 
-// fetch block digest
-func blockDigest(client *zmqutil.Client, blockNumber uint64) (blockdigest.Digest, error) {
-	parameter := make([]byte, 8)
-	binary.BigEndian.PutUint64(parameter, blockNumber)
-	err := client.Send("H", parameter)
+// write the sync status table if anything changed, including the
+// local height so that a long fetch keeps the table current
+func (conn *connector) recordSyncStatus() {
+
+	height, err := storage.GetBlockHeight()
 	if nil != err {
-		client.Reconnect()
-		return blockdigest.Digest{}, err
+		conn.log.Errorf("update sync status: block height error: %s", err)
+		return
 	}
 
-	data, err := client.Receive(0)
-	if nil != err {
-		client.Reconnect()
-		return blockdigest.Digest{}, err
+	node := ""
+	if nil != conn.theClient {
+		node = conn.theClient.PublicKey()
+	}
+	s := storage.SyncStatus{
+		State:        conn.state.String(),
+		Mode:         mode.String(),
+		Synchronised: cStateSampling == conn.state,
+		LocalHeight:  height,
+		RemoteHeight: conn.highestBlockNumber,
+		Node:         node,
+		LastError:    conn.lastError,
 	}
 
-	if 2 != len(data) {
-		return blockdigest.Digest{}, fault.ErrInvalidPeerResponse
+	if nil != conn.recorded && s == *conn.recorded {
+		return
 	}
 
-	switch string(data[0]) {
-	case "E":
-		return blockdigest.Digest{}, fault.ErrorFromRunes(data[1])
-	case "H":
-		d := blockdigest.Digest{}
-		if blockdigest.Length == len(data[1]) {
-			err := blockdigest.DigestFromBytes(&d, data[1])
-			return d, err
+	err = storage.UpdateSyncStatus(s)
+	if nil != err {
+		conn.log.Errorf("update sync status error: %s", err)
+		return
+	}
+	conn.recorded = &s
+}
+
+// check all nodes are on the same chain as this program and record
+// which may be fetched from, logging any change
+// returns the number of nodes that answered on the same chain
+func (conn *connector) checkNodes() int {
+	log := conn.log
+
+	nodeCount := 0
+
+	for i, node := range conn.nodes {
+		reason := ""
+		if !node.IsConnected() {
+			reason = "not connected"
+		} else if info, err := node.Info(context.Background()); nil != err {
+			log.Errorf("checkNodes: error: %s, node: %s", err, node)
+			rpcFailure(node, "I", err)
+			reason = "info error: " + err.Error()
+		} else {
+			nodeResponded(node, info.Height)
+			if info.Chain == mode.ChainName() {
+				nodeCount += 1
+			}
+			reason = conn.eligibility.exclude(info)
 		}
-	default:
+
+		if reason != conn.excluded[i] {
+			if "" == reason {
+				log.Infof("checkNodes: node: %s  eligible", node)
+			} else {
+				log.Warnf("checkNodes: node: %s  excluded: %s", node, reason)
+			}
+		}
+		conn.excluded[i] = reason
+		setNodeExcluded(node, reason)
 	}
-	return blockdigest.Digest{}, fault.ErrInvalidPeerResponse
+	return nodeCount
 }
 
-// fetch block data
-func blockData(client *zmqutil.Client, blockNumber uint64) ([]byte, error) {
-	parameter := make([]byte, 8)
-	binary.BigEndian.PutUint64(parameter, blockNumber)
-	err := client.Send("B", parameter)
-	if nil != err {
-		client.Reconnect()
-		return nil, err
+// determine the quorum height and the node to fetch from
+func (conn *connector) highestBlock() (uint64, *rpc.Client) {
+	log := conn.log
+
+	candidates := make([]candidate, 0, len(conn.nodes))
+	best := uint64(0)
+
+scan_nodes:
+	for i, node := range conn.nodes {
+		if !node.IsConnected() || "" != conn.excluded[i] {
+			continue scan_nodes
+		}
+
+		n, err := node.Height(context.Background())
+		if nil != err {
+			log.Errorf("highestBlock: error: %s, node: %s", err, node)
+			rpcFailure(node, "N", err)
+			continue scan_nodes
+		}
+		nodeResponded(node, n)
+
+		candidates = append(candidates, candidate{
+			node:           node,
+			height:         n,
+			nodeAttributes: conn.attributes[i],
+		})
+		if n > best {
+			best = n
+		}
 	}
 
-	data, err := client.Receive(0)
-	if nil != err {
-		client.Reconnect()
-		return nil, err
+	h, c := selectNode(candidates, conn.quorum)
+	if nil == c && 0 != len(candidates) {
+		log.Warnf("highestBlock: quorum: %d not reached by %d responding nodes", conn.quorum, len(candidates))
+	} else if h < best {
+		log.Infof("highestBlock: quorum height: %d  highest reported: %d", h, best)
 	}
 
-	if 2 != len(data) {
-		return nil, fault.ErrInvalidPeerResponse
+	remoteHeightGauge.Set(float64(h))
+	return h, c
+}
+
+// fetch block digest
+func blockDigest(node *rpc.Client, blockNumber uint64) (blockdigest.Digest, error) {
+	d, err := node.Digest(context.Background(), blockNumber)
+	if nil != err {
+		rpcFailure(node, "H", err)
 	}
+	return d, err
+}
 
-	switch string(data[0]) {
-	case "E":
-		return nil, fault.ErrorFromRunes(data[1])
-	case "B":
-		return data[1], nil
-	default:
+// fetch consecutive blocks
+func blocksData(node *rpc.Client, start uint64, count int) ([][]byte, error) {
+	data, err := node.Blocks(context.Background(), start, count)
+	if nil != err {
+		rpcFailure(node, "B", err)
 	}
-	return nil, fault.ErrInvalidPeerResponse
+	return data, err
 }
 
 func (state connectorState) String() string {
@@ -0,0 +1,140 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/bitmarkd/mode"
+
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// maximum wait for the connector to finish its current cycle
+const controlTimeout = 5 * time.Minute
+
+// errors for the control requests
+var (
+	ErrConnectorBusy = errors.New("connector busy")
+	ErrInvalidState  = errors.New("invalid state")
+)
+
+// the kinds of control request
+type controlAction int
+
+const (
+	cPause  controlAction = iota // stop processing cycles
+	cResume controlAction = iota // restart processing cycles
+	cForce  controlAction = iota // move to a specific state
+	cRewind controlAction = iota // delete blocks and resynchronise
+)
+
+// a control request, handled by the connector between cycles
+type controlRequest struct {
+	action      controlAction
+	state       connectorState
+	blockNumber uint64
+	reply       chan error
+}
+
+// Pause - stop the connector after its current cycle
+func Pause() error {
+	return sendControl(controlRequest{action: cPause})
+}
+
+// Resume - restart a paused connector
+func Resume() error {
+	return sendControl(controlRequest{action: cResume})
+}
+
+// ForceState - move the connector to "HighestBlock" or "ForkDetect"
+func ForceState(name string) error {
+	req := controlRequest{action: cForce}
+	switch name {
+	case cStateHighestBlock.String():
+		req.state = cStateHighestBlock
+	case cStateForkDetect.String():
+		req.state = cStateForkDetect
+	default:
+		return ErrInvalidState
+	}
+	return sendControl(req)
+}
+
+// Rewind - delete all blocks from blockNumber upwards and resynchronise
+func Rewind(blockNumber uint64) error {
+	return sendControl(controlRequest{action: cRewind, blockNumber: blockNumber})
+}
+
+// pass a request to the connector and wait for the result
+func sendControl(req controlRequest) error {
+	globalData.RLock()
+	initialised := globalData.initialised
+	globalData.RUnlock()
+
+	if !initialised {
+		return fault.ErrNotInitialised
+	}
+
+	req.reply = make(chan error, 1)
+
+	select {
+	case globalData.conn.requests <- req:
+	case <-time.After(controlTimeout):
+		return ErrConnectorBusy
+	}
+	return <-req.reply
+}
+
+// ask the connector to look for a fork at its next cycle, never waits
+// so that a storage worker is not held by a long fetch
+func (conn *connector) resynchronise() {
+	select {
+	case conn.resync <- struct{}{}:
+	default: // already requested
+	}
+}
+
+// handle a control request, called from the connector background
+func (conn *connector) control(req controlRequest) error {
+	log := conn.log
+
+	switch req.action {
+	case cPause:
+		log.Warn("paused")
+		conn.paused = true
+
+	case cResume:
+		log.Warn("resumed")
+		conn.paused = false
+
+	case cForce:
+		log.Warnf("force state: %s", req.state)
+		conn.state = req.state
+
+	case cRewind:
+		log.Warnf("rewind to block number: %d", req.blockNumber)
+		if req.blockNumber <= genesis.BlockNumber {
+			return fault.ErrBlockNotFound
+		}
+		err := storage.DeleteDownToBlock(req.blockNumber, req.blockNumber-1, storage.ReorgRewind)
+		if nil != err {
+			log.Errorf("rewind to block number: %d  error: %s", req.blockNumber, err)
+			return err
+		}
+		mode.Set(mode.Resynchronise)
+		conn.state = cStateHighestBlock
+	}
+
+	setConnectorStateMetric(conn.state)
+	setConnectorStatus(conn.state, conn.highestBlockNumber)
+	setPaused(conn.paused)
+	conn.recordSyncStatus()
+
+	return nil
+}
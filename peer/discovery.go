@@ -0,0 +1,264 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+// scores of discovered nodes
+const (
+	scoreSuccess  = 1  // node answered and is eligible
+	scoreFailure  = -2 // node failed or is not eligible
+	scoreMaximum  = 10 // so a long good record does not hide a failing node
+	scoreEvict    = -6 // at or below this the node leaves the pool
+	publicKeySize = 32 // bitmarkd peering key
+	evictionDelay = 30 * time.Minute
+
+	// discovered nodes have no group, they do not count towards the
+	// quorum and are only fetched from at a height the static nodes
+	// agree on, an announced node is not trusted to confirm a height
+	discoveredGroup = ""
+)
+
+// DiscoveryConfiguration - nodes learned from bitmarkd "peer" announcements
+//
+// an announcement gives the public key and the peering listener of a
+// node, its broadcast port is taken to be at a fixed offset from it
+type DiscoveryConfiguration struct {
+	Enabled         bool `gluamapper:"enabled" json:"enabled"`
+	MaximumNodes    int  `gluamapper:"maximum_nodes" json:"maximum_nodes"`       // pool size, in addition to the static nodes
+	BroadcastOffset int  `gluamapper:"broadcast_offset" json:"broadcast_offset"` // broadcast port - listener port
+	Expiry          int  `gluamapper:"expiry" json:"expiry"`                     // seconds before an announcement is too old
+	Priority        int  `gluamapper:"priority" json:"priority"`                 // of discovered nodes, see Connection, 0 => after every static node
+}
+
+// an announced node
+type announcement struct {
+	publicKey []byte
+	connect   string // "host:port" of the peering listener
+	subscribe string // "host:port" of the broadcaster
+	timestamp time.Time
+
+	score      int
+	member     bool      // currently in the pool
+	retryAfter time.Time // evicted nodes are not taken again before this
+}
+
+// the pool shared by the connector and the subscriber
+//
+// both sync their clients to members(), the connector also scores
+// the members after each height check
+type discovery struct {
+	sync.Mutex
+
+	configuration DiscoveryConfiguration
+	priority      int                 // of every discovered node
+	static        map[string]struct{} // anchors, never in the pool
+	announced     map[string]*announcement
+}
+
+// create the pool, static nodes are not replaced by announcements
+//
+// unless configured, discovered nodes have a priority below every
+// static node, i.e. they are only fetched from if no static node has
+// the blocks
+func newDiscovery(configuration *DiscoveryConfiguration, static []Connection) *discovery {
+	d := &discovery{
+		configuration: *configuration,
+		priority:      configuration.Priority,
+		static:        make(map[string]struct{}),
+		announced:     make(map[string]*announcement),
+	}
+	lowest := 0 // least preferred static priority
+	for i, c := range static {
+		d.static[strings.ToLower(c.PublicKey)] = struct{}{}
+		if 0 == i || c.Priority > lowest {
+			lowest = c.Priority
+		}
+	}
+	if 0 == d.priority {
+		d.priority = lowest + 1
+	}
+	return d
+}
+
+// record a "peer" announcement: public key, packed listeners and
+// 8 byte timestamp
+// returns true if a new node or a changed address
+func (d *discovery) announce(publicKey []byte, listeners []byte, timestamp []byte) bool {
+	if nil == d || !d.configuration.Enabled {
+		return false
+	}
+	if publicKeySize != len(publicKey) || 8 != len(timestamp) {
+		return false
+	}
+	at := time.Unix(int64(binary.BigEndian.Uint64(timestamp)), 0)
+	if d.expired(at, time.Now()) {
+		return false
+	}
+
+	key := hex.EncodeToString(publicKey)
+	if _, ok := d.static[key]; ok {
+		return false
+	}
+
+	// prefer IPv4 as the reachability of IPv6 is unknown
+	v4, v6 := util.PackedConnection(listeners).Unpack46()
+	listener := v4
+	if nil == listener {
+		listener = v6
+	}
+	if nil == listener {
+		return false
+	}
+	connect, _ := listener.CanonicalIPandPort("")
+	subscribe, ok := offsetPort(connect, d.configuration.BroadcastOffset)
+	if !ok {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	a, ok := d.announced[key]
+	if ok && !at.After(a.timestamp) {
+		return false
+	}
+	if !ok {
+		a = &announcement{
+			publicKey: append([]byte(nil), publicKey...),
+		}
+		d.announced[key] = a
+	}
+	changed := !ok || a.connect != connect
+	a.connect = connect
+	a.subscribe = subscribe
+	a.timestamp = at
+	return changed
+}
+
+// adjust the score of a pool member
+func (d *discovery) score(publicKey string, ok bool) {
+	if nil == d {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	a, found := d.announced[publicKey]
+	if !found || !a.member {
+		return
+	}
+	if ok {
+		a.score += scoreSuccess
+		if a.score > scoreMaximum {
+			a.score = scoreMaximum
+		}
+	} else {
+		a.score += scoreFailure
+	}
+}
+
+// the current pool
+//
+// members that expired or scored too low are removed, then the free
+// places are filled with the best scored and most recently announced
+// nodes; the result is sorted by public key so that both users see
+// the same order
+func (d *discovery) members() []announcement {
+	if nil == d || !d.configuration.Enabled {
+		return nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	now := time.Now()
+	count := 0
+	waiting := make([]*announcement, 0, len(d.announced))
+	for key, a := range d.announced {
+		if a.member && (a.score <= scoreEvict || d.expired(a.timestamp, now)) {
+			a.member = false
+			a.score = 0
+			a.retryAfter = now.Add(evictionDelay)
+		}
+		if d.expired(a.timestamp, now) {
+			delete(d.announced, key)
+			continue
+		}
+		if a.member {
+			count += 1
+		} else if now.After(a.retryAfter) {
+			waiting = append(waiting, a)
+		}
+	}
+
+	sort.Slice(waiting, func(i, j int) bool {
+		if waiting[i].score != waiting[j].score {
+			return waiting[i].score > waiting[j].score
+		}
+		return waiting[i].timestamp.After(waiting[j].timestamp)
+	})
+	for _, a := range waiting {
+		if count >= d.configuration.MaximumNodes {
+			break
+		}
+		a.member = true
+		count += 1
+	}
+
+	pool := make([]announcement, 0, count)
+	for _, a := range d.announced {
+		if a.member {
+			pool = append(pool, *a)
+		}
+	}
+	sort.Slice(pool, func(i, j int) bool {
+		return hex.EncodeToString(pool[i].publicKey) < hex.EncodeToString(pool[j].publicKey)
+	})
+	return pool
+}
+
+// true if the announcement is too old to use
+func (d *discovery) expired(at time.Time, now time.Time) bool {
+	return 0 != d.configuration.Expiry && now.Sub(at) > time.Duration(d.configuration.Expiry)*time.Second
+}
+
+// the attributes of a discovered node, outside any quorum group
+func (a *announcement) attributes(priority int) nodeAttributes {
+	return nodeAttributes{
+		priority: priority,
+		weight:   1,
+		group:    discoveredGroup,
+	}
+}
+
+// "host:port" with the port moved by offset
+func offsetPort(hostPort string, offset int) (string, bool) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if nil != err {
+		return "", false
+	}
+	n, err := strconv.Atoi(port)
+	if nil != err {
+		return "", false
+	}
+	n += offset
+	if n < 1 || n > 65535 {
+		return "", false
+	}
+	return net.JoinHostPort(host, strconv.Itoa(n)), true
+}
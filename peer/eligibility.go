@@ -0,0 +1,172 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitmark-inc/bitmarkd/mode"
+
+	"github.com/bitmark-inc/updaterd/peer/rpc"
+)
+
+// errors for the eligibility configuration
+var (
+	ErrInvalidVersion      = errors.New("invalid version")
+	ErrInvalidVersionRange = errors.New("minimum version is above maximum version")
+)
+
+// which nodes blocks may be fetched from
+type eligibility struct {
+	minimum *version // nil => no lower limit
+	maximum *version // nil => no upper limit
+}
+
+// parse the configured version range, blank means no limit
+func newEligibility(minimum string, maximum string) (*eligibility, error) {
+	e := &eligibility{}
+	if "" != minimum {
+		v, err := parseVersion(minimum)
+		if nil != err {
+			return nil, err
+		}
+		e.minimum = v
+	}
+	if "" != maximum {
+		v, err := parseVersion(maximum)
+		if nil != err {
+			return nil, err
+		}
+		e.maximum = v
+	}
+	if nil != e.minimum && nil != e.maximum && e.minimum.compare(e.maximum) > 0 {
+		return nil, ErrInvalidVersionRange
+	}
+	return e, nil
+}
+
+// the reason a node must not be used, blank if it is eligible
+func (e *eligibility) exclude(info *rpc.Info) string {
+	if info.Chain != mode.ChainName() {
+		return fmt.Sprintf("chain: %q  expected: %q", info.Chain, mode.ChainName())
+	}
+	if !info.Normal {
+		return "node is not in normal mode"
+	}
+	if nil == e.minimum && nil == e.maximum {
+		return ""
+	}
+
+	v, err := parseVersion(info.Version)
+	if nil != err {
+		return fmt.Sprintf("version: %q  cannot be compared", info.Version)
+	}
+	if nil != e.minimum && v.compare(e.minimum) < 0 {
+		return fmt.Sprintf("version: %q  below minimum: %q", info.Version, e.minimum)
+	}
+	if nil != e.maximum && v.compare(e.maximum) > 0 {
+		return fmt.Sprintf("version: %q  above maximum: %q", info.Version, e.maximum)
+	}
+	return ""
+}
+
+// a bitmarkd version, e.g. "v0.11.0-rc.2"
+type version struct {
+	text       string
+	numbers    []uint64
+	prerelease []string
+}
+
+// accepts an optional "v", any number of dotted numbers and an
+// optional "-" prerelease suffix
+func parseVersion(s string) (*version, error) {
+	text := strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	release := text
+	v := &version{text: s}
+	if i := strings.IndexByte(text, '-'); i >= 0 {
+		release = text[:i]
+		v.prerelease = strings.Split(text[i+1:], ".")
+	}
+
+	for _, part := range strings.Split(release, ".") {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if nil != err {
+			return nil, ErrInvalidVersion
+		}
+		v.numbers = append(v.numbers, n)
+	}
+	return v, nil
+}
+
+// -1, 0 or 1 as v is below, equal to or above w
+// missing numbers are zero and a prerelease is below its release
+func (v *version) compare(w *version) int {
+	for i := 0; i < len(v.numbers) || i < len(w.numbers); i += 1 {
+		a := uint64(0)
+		if i < len(v.numbers) {
+			a = v.numbers[i]
+		}
+		b := uint64(0)
+		if i < len(w.numbers) {
+			b = w.numbers[i]
+		}
+		if a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case 0 == len(v.prerelease) && 0 == len(w.prerelease):
+		return 0
+	case 0 == len(v.prerelease):
+		return 1
+	case 0 == len(w.prerelease):
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(w.prerelease); i += 1 {
+		if c := comparePrerelease(v.prerelease[i], w.prerelease[i]); 0 != c {
+			return c
+		}
+	}
+	switch {
+	case len(v.prerelease) < len(w.prerelease):
+		return -1
+	case len(v.prerelease) > len(w.prerelease):
+		return 1
+	}
+	return 0
+}
+
+// numeric identifiers compare as numbers and sort before text
+func comparePrerelease(a string, b string) int {
+	m, errA := strconv.ParseUint(a, 10, 64)
+	n, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case nil == errA && nil == errB:
+		if m == n {
+			return 0
+		} else if m < n {
+			return -1
+		}
+		return 1
+	case nil == errA:
+		return -1
+	case nil == errB:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func (v *version) String() string {
+	return v.text
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"testing"
+
+	"github.com/bitmark-inc/bitmarkd/mode"
+
+	"github.com/bitmark-inc/updaterd/peer/rpc"
+)
+
+func TestParseVersion(t *testing.T) {
+	valid := []string{"0.11.0", "v0.11.0", " v1 ", "1.2.3.4", "v0.11.0-rc.2", "1.0-alpha"}
+	for _, s := range valid {
+		v, err := parseVersion(s)
+		if nil != err {
+			t.Errorf("parse: %q  error: %s", s, err)
+		} else if s != v.String() {
+			t.Errorf("parse: %q  string: %q", s, v.String())
+		}
+	}
+
+	invalid := []string{"", "v", "1..2", "1.x", "vv1.0", "1.-1", "-rc.1"}
+	for _, s := range invalid {
+		_, err := parseVersion(s)
+		if ErrInvalidVersion != err {
+			t.Errorf("parse: %q  error: %v  expected: %s", s, err, ErrInvalidVersion)
+		}
+	}
+}
+
+func TestCompareVersion(t *testing.T) {
+	// in increasing order, each line equal to itself only
+	ordered := []string{
+		"0.9.9",
+		"0.10.0-alpha",
+		"0.10.0-alpha.1",
+		"0.10.0-alpha.beta",
+		"0.10.0-beta",
+		"0.10.0-beta.2",
+		"0.10.0-beta.11",
+		"0.10.0-rc.1",
+		"0.10.0",
+		"0.10.1",
+		"0.11.0-rc.2",
+		"v0.11.0",
+		"1",
+	}
+
+	for i, a := range ordered {
+		v, err := parseVersion(a)
+		if nil != err {
+			t.Fatalf("parse: %q  error: %s", a, err)
+		}
+		for j, b := range ordered {
+			w, err := parseVersion(b)
+			if nil != err {
+				t.Fatalf("parse: %q  error: %s", b, err)
+			}
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			if c := v.compare(w); expected != c {
+				t.Errorf("compare: %q with: %q  result: %d  expected: %d", a, b, c, expected)
+			}
+		}
+	}
+
+	// missing numbers are zero
+	equal := [][2]string{
+		{"1", "1.0.0"},
+		{"v0.11", "0.11.0"},
+		{"0.11-rc.1", "0.11.0-rc.1"},
+	}
+	for _, pair := range equal {
+		v, _ := parseVersion(pair[0])
+		w, _ := parseVersion(pair[1])
+		if 0 != v.compare(w) || 0 != w.compare(v) {
+			t.Errorf("compare: %q with: %q  not equal", pair[0], pair[1])
+		}
+	}
+}
+
+func TestNewEligibility(t *testing.T) {
+	items := []struct {
+		minimum string
+		maximum string
+		err     error
+	}{
+		{"", "", nil},
+		{"0.10.0", "", nil},
+		{"", "0.11.0", nil},
+		{"0.11.0-rc.1", "0.11.0", nil},
+		{"0.11.0", "0.11.0", nil},
+		{"0.11.0", "0.11.0-rc.1", ErrInvalidVersionRange},
+		{"0.12", "0.11.9", ErrInvalidVersionRange},
+		{"latest", "", ErrInvalidVersion},
+		{"", "0.x", ErrInvalidVersion},
+	}
+	for _, item := range items {
+		_, err := newEligibility(item.minimum, item.maximum)
+		if item.err != err {
+			t.Errorf("minimum: %q  maximum: %q  error: %v  expected: %v", item.minimum, item.maximum, err, item.err)
+		}
+	}
+}
+
+func TestEligibilityExclude(t *testing.T) {
+	e, err := newEligibility("0.10.5", "0.11.0")
+	if nil != err {
+		t.Fatalf("eligibility error: %s", err)
+	}
+
+	items := []struct {
+		version  string
+		eligible bool
+	}{
+		{"0.10.4", false},
+		{"0.10.5-rc.1", false},
+		{"v0.10.5", true},
+		{"0.10.9", true},
+		{"0.11.0-rc.2", true},
+		{"0.11.0", true},
+		{"0.11.0.1", false},
+		{"0.11.1", false},
+		{"unknown", false},
+	}
+	for _, item := range items {
+		info := &rpc.Info{
+			Version: item.version,
+			Chain:   mode.ChainName(),
+			Normal:  true,
+		}
+		reason := e.exclude(info)
+		if item.eligible != ("" == reason) {
+			t.Errorf("version: %q  eligible: %t  reason: %q", item.version, item.eligible, reason)
+		}
+	}
+
+	// without a range any version is eligible
+	e, err = newEligibility("", "")
+	if nil != err {
+		t.Fatalf("eligibility error: %s", err)
+	}
+	info := &rpc.Info{Version: "unknown", Chain: mode.ChainName(), Normal: true}
+	if reason := e.exclude(info); "" != reason {
+		t.Errorf("no range: excluded: %q", reason)
+	}
+
+	// the chain and mode are checked before the version
+	info = &rpc.Info{Version: "0.10.9", Chain: mode.ChainName() + "-other", Normal: true}
+	if "" == e.exclude(info) {
+		t.Errorf("node on another chain is eligible")
+	}
+	info = &rpc.Info{Version: "0.10.9", Chain: mode.ChainName(), Normal: false}
+	if "" == e.exclude(info) {
+		t.Errorf("node not in normal mode is eligible")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"github.com/bitmark-inc/updaterd/metrics"
+	"github.com/bitmark-inc/updaterd/peer/rpc"
+)
+
+// peer metrics
+var (
+	remoteHeightGauge = metrics.NewGauge(
+		"updaterd_remote_block_height",
+		"highest block number reported by any node",
+	)
+	connectorStateGauge = metrics.NewGaugeVec(
+		"updaterd_connector_state",
+		"current state of the connector, the active state has value 1",
+		"state",
+	)
+	rpcErrorCounter = metrics.NewCounterVec(
+		"updaterd_rpc_errors_total",
+		"number of failed RPC requests to a node",
+		"node", "request",
+	)
+	rpcTimeoutCounter = metrics.NewCounterVec(
+		"updaterd_rpc_timeouts_total",
+		"number of RPC requests to a node that timed out",
+		"node", "request",
+	)
+	subscriberMessageCounter = metrics.NewCounterVec(
+		"updaterd_subscriber_messages_total",
+		"number of messages received from node broadcasts by topic",
+		"topic",
+	)
+	subscriberConnectionCounter = metrics.NewCounterVec(
+		"updaterd_subscriber_connection_events_total",
+		"number of connection events of the subscriber sockets by node and event",
+		"node", "event",
+	)
+	queueDepthGauge = metrics.NewGaugeVec(
+		"updaterd_subscriber_queue_depth",
+		"messages waiting to be stored, in memory and spilled to disk",
+		"queue",
+	)
+	queueDroppedCounter = metrics.NewCounterVec(
+		"updaterd_subscriber_queue_dropped_total",
+		"number of messages discarded because a queue was full",
+		"queue",
+	)
+	queueSpilledCounter = metrics.NewCounterVec(
+		"updaterd_subscriber_queue_spilled_total",
+		"number of messages written to disk because a queue was full",
+		"queue",
+	)
+)
+
+func init() {
+	metrics.NewGaugeFunc(
+		"updaterd_heartbeat_age_seconds",
+		"time since the last heartbeat was received from a node",
+		globalData.sbsc.heartbeatAges,
+		"node",
+	)
+}
+
+// record the current connector state
+func setConnectorStateMetric(state connectorState) {
+	for s := cStateConnecting; s <= cStateSampling; s += 1 {
+		v := 0.0
+		if s == state {
+			v = 1.0
+		}
+		connectorStateGauge.With(s.String()).Set(v)
+	}
+}
+
+// count a failed request, separating out timeouts
+func countRPCError(node *rpc.Client, request string, err error) {
+	if rpc.IsTimeout(err) {
+		rpcTimeoutCounter.With(node.String(), request).Inc()
+		return
+	}
+	rpcErrorCounter.With(node.String(), request).Inc()
+}
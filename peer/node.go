@@ -0,0 +1,91 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/zmqutil"
+
+	"github.com/bitmark-inc/updaterd/peer/rpc"
+)
+
+// Node - a node chosen from the configuration for a command that
+// runs without the background processes
+//
+// the node is selected in the same way as the connector chooses the
+// node to fetch from: eligible nodes only, respecting quorum,
+// priority and weight
+type Node struct {
+	conn   connector
+	client *rpc.Client
+	height uint64
+}
+
+// Connect - connect to the configured nodes and select one
+// zmqutil.StartAuthentication must have been called
+func Connect(configuration *Configuration) (*Node, error) {
+
+	privateKey, err := zmqutil.ReadPrivateKey(configuration.PrivateKey)
+	if nil != err {
+		return nil, err
+	}
+	publicKey, err := zmqutil.ReadPublicKey(configuration.PublicKey)
+	if nil != err {
+		return nil, err
+	}
+
+	err = validateNodes(configuration.Node, configuration.Quorum)
+	if nil != err {
+		return nil, err
+	}
+	eligibility, err := newEligibility(configuration.MinVersion, configuration.MaxVersion)
+	if nil != err {
+		return nil, err
+	}
+
+	n := &Node{}
+	rpcNodes := connectionsForRole(configuration.Node, roleRPC)
+	err = n.conn.initialise(privateKey, publicKey, rpcNodes, configuration.Pipeline, configuration.Quorum, eligibility, 0, nil)
+	if nil != err {
+		return nil, err
+	}
+
+	n.conn.checkNodes()
+	n.height, n.client = n.conn.highestBlock()
+	if nil == n.client {
+		n.conn.close()
+		return nil, fault.ErrNoConnectionsAvailable
+	}
+	n.conn.log.Infof("selected node: %s  height: %d", n.client, n.height)
+
+	return n, nil
+}
+
+// Client - requests to the selected node
+func (n *Node) Client() *rpc.Client {
+	return n.client
+}
+
+// Clients - all connected nodes that are eligible, the selected node
+// is first
+func (n *Node) Clients() []*rpc.Client {
+	clients := []*rpc.Client{n.client}
+	for i, node := range n.conn.nodes {
+		if node != n.client && node.IsConnected() && "" == n.conn.excluded[i] {
+			clients = append(clients, node)
+		}
+	}
+	return clients
+}
+
+// Height - the height agreed by the quorum when the node was selected
+func (n *Node) Height() uint64 {
+	return n.height
+}
+
+// Close - disconnect all nodes
+func (n *Node) Close() {
+	n.conn.close()
+}
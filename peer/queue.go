@@ -0,0 +1,273 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bitmark-inc/logger"
+)
+
+// overflow policies
+const (
+	overflowBlock      = "block"       // wait for space, stalls the poll loop
+	overflowDropOldest = "drop-oldest" // discard the oldest queued message
+	overflowSpill      = "spill"       // write messages to disk until there is space
+)
+
+// errors for the queue configuration
+var (
+	ErrInvalidOverflowPolicy = errors.New("invalid overflow policy")
+	ErrInvalidQueueSize      = errors.New("invalid queue size")
+)
+
+// QueueConfiguration - queue between the subscriber and the storage workers
+type QueueConfiguration struct {
+	Size           int    `gluamapper:"size" json:"size"`                       // messages held in memory for each queue
+	Workers        int    `gluamapper:"workers" json:"workers"`                 // workers for transactions, > 1 may store out of order
+	Overflow       string `gluamapper:"overflow" json:"overflow"`               // block, drop-oldest or spill
+	SpillDirectory string `gluamapper:"spill_directory" json:"spill_directory"` // for the spill policy
+}
+
+// a received message waiting to be stored
+type queueItem struct {
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+}
+
+// a bounded FIFO queue
+//
+// with the spill policy, once any item is on disk all new items also
+// go to disk so that order is kept, the disk items are loaded back as
+// the memory queue empties; items left on disk at shutdown are
+// loaded by the next run
+type workQueue struct {
+	sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+
+	log      *logger.L
+	name     string
+	items    []queueItem
+	capacity int
+	policy   string
+	closed   bool
+
+	// spill files are numbered from spillHead (next to read) to spillTail (next to write)
+	spillDirectory string
+	spillHead      uint64
+	spillTail      uint64
+}
+
+// create a queue, finding any items spilled by a previous run
+func newWorkQueue(name string, configuration *QueueConfiguration, log *logger.L) (*workQueue, error) {
+
+	if configuration.Size <= 0 {
+		return nil, ErrInvalidQueueSize
+	}
+
+	q := &workQueue{
+		log:      log,
+		name:     name,
+		items:    make([]queueItem, 0, configuration.Size),
+		capacity: configuration.Size,
+		policy:   configuration.Overflow,
+	}
+	q.notEmpty = sync.NewCond(q)
+	q.notFull = sync.NewCond(q)
+
+	switch q.policy {
+	case overflowBlock, overflowDropOldest:
+	case overflowSpill:
+		q.spillDirectory = filepath.Join(configuration.SpillDirectory, name)
+		err := q.openSpill()
+		if nil != err {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidOverflowPolicy
+	}
+
+	q.updateDepth()
+
+	return q, nil
+}
+
+// add an item, applying the overflow policy if full
+func (q *workQueue) put(item queueItem) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		q.log.Warnf("queue: %s closed, discard: %s", q.name, item.Topic)
+		return
+	}
+
+	if q.spilled() > 0 {
+		q.spill(item)
+		return
+	}
+
+	for len(q.items) >= q.capacity {
+		switch q.policy {
+		case overflowBlock:
+			q.notFull.Wait()
+			if q.closed {
+				return
+			}
+
+		case overflowDropOldest:
+			q.log.Warnf("queue: %s full, drop oldest: %s", q.name, q.items[0].Topic)
+			q.items = q.items[1:]
+			queueDroppedCounter.With(q.name).Inc()
+
+		case overflowSpill:
+			q.spill(item)
+			return
+		}
+	}
+
+	q.items = append(q.items, item)
+	q.updateDepth()
+	q.notEmpty.Signal()
+}
+
+// remove the oldest item, waits until one is available
+// returns false when the queue is closed and empty
+func (q *workQueue) get() (queueItem, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	for 0 == len(q.items) && !q.closed {
+		q.unspill()
+		if 0 != len(q.items) {
+			break
+		}
+		q.notEmpty.Wait()
+	}
+	if 0 == len(q.items) {
+		return queueItem{}, false
+	}
+
+	item := q.items[0]
+	q.items = q.items[1:]
+
+	q.unspill()
+	q.updateDepth()
+	q.notFull.Signal()
+
+	return item, true
+}
+
+// stop accepting items and wake all waiters
+// memory items are still returned by get, spilled items stay on disk
+func (q *workQueue) close() {
+	q.Lock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	q.Unlock()
+}
+
+// number of items in memory and on disk
+// must be called with the lock held
+func (q *workQueue) depth() int {
+	return len(q.items) + q.spilled()
+}
+
+// must be called with the lock held
+func (q *workQueue) updateDepth() {
+	queueDepthGauge.With(q.name).Set(float64(q.depth()))
+}
+
+// must be called with the lock held
+func (q *workQueue) spilled() int {
+	return int(q.spillTail - q.spillHead)
+}
+
+// find the existing spill files
+func (q *workQueue) openSpill() error {
+	err := os.MkdirAll(q.spillDirectory, 0700)
+	if nil != err {
+		return err
+	}
+
+	names, err := filepath.Glob(filepath.Join(q.spillDirectory, "*"))
+	if nil != err {
+		return err
+	}
+
+	numbers := make([]uint64, 0, len(names))
+	for _, name := range names {
+		n, err := strconv.ParseUint(filepath.Base(name), 10, 64)
+		if nil != err {
+			continue // not a spill file
+		}
+		numbers = append(numbers, n)
+	}
+	if 0 == len(numbers) {
+		return nil
+	}
+
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	q.spillHead = numbers[0]
+	q.spillTail = numbers[len(numbers)-1] + 1
+	q.log.Infof("queue: %s  spilled items from previous run: %d", q.name, q.spilled())
+
+	return nil
+}
+
+// write an item to disk
+// must be called with the lock held
+func (q *workQueue) spill(item queueItem) {
+	data, err := json.Marshal(item)
+	if nil == err {
+		err = ioutil.WriteFile(q.spillFile(q.spillTail), data, 0600)
+	}
+	if nil != err {
+		q.log.Errorf("queue: %s  spill: %s  error: %s", q.name, item.Topic, err)
+		queueDroppedCounter.With(q.name).Inc()
+		return
+	}
+	q.spillTail += 1
+	queueSpilledCounter.With(q.name).Inc()
+	q.updateDepth()
+	q.notEmpty.Signal()
+}
+
+// move items from disk while there is space in memory
+// must be called with the lock held
+func (q *workQueue) unspill() {
+	for q.spilled() > 0 && len(q.items) < q.capacity {
+		name := q.spillFile(q.spillHead)
+		q.spillHead += 1
+
+		data, err := ioutil.ReadFile(name)
+		if nil == err {
+			var item queueItem
+			err = json.Unmarshal(data, &item)
+			if nil == err {
+				q.items = append(q.items, item)
+			}
+		}
+		if nil != err {
+			q.log.Errorf("queue: %s  unspill: %q  error: %s", q.name, name, err)
+			queueDroppedCounter.With(q.name).Inc()
+		}
+		os.Remove(name)
+	}
+}
+
+func (q *workQueue) spillFile(n uint64) string {
+	return filepath.Join(q.spillDirectory, fmt.Sprintf("%020d", n))
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitmark-inc/logger"
+)
+
+func newTestQueue(t *testing.T, size int, policy string, directory string) *workQueue {
+	configuration := &QueueConfiguration{
+		Size:           size,
+		Overflow:       policy,
+		SpillDirectory: directory,
+	}
+	q, err := newWorkQueue("test", configuration, logger.New("queue-test"))
+	if nil != err {
+		t.Fatalf("new queue error: %s", err)
+	}
+	return q
+}
+
+func putTopics(q *workQueue, topics ...string) {
+	for _, topic := range topics {
+		q.put(queueItem{Topic: topic, Payload: []byte(topic)})
+	}
+}
+
+// get items and compare their topics
+func expectTopics(t *testing.T, q *workQueue, topics ...string) {
+	for _, expected := range topics {
+		item, ok := q.get()
+		if !ok {
+			t.Fatalf("get: queue closed  expected: %q", expected)
+		}
+		if expected != item.Topic || expected != string(item.Payload) {
+			t.Fatalf("get: %q  payload: %q  expected: %q", item.Topic, item.Payload, expected)
+		}
+	}
+}
+
+func spillFiles(t *testing.T, directory string) int {
+	names, err := filepath.Glob(filepath.Join(directory, "test", "*"))
+	if nil != err {
+		t.Fatalf("glob error: %s", err)
+	}
+	return len(names)
+}
+
+func TestQueueConfiguration(t *testing.T) {
+	log := logger.New("queue-test")
+
+	_, err := newWorkQueue("test", &QueueConfiguration{Size: 0, Overflow: overflowBlock}, log)
+	if ErrInvalidQueueSize != err {
+		t.Errorf("size 0: %v  expected: %s", err, ErrInvalidQueueSize)
+	}
+	_, err = newWorkQueue("test", &QueueConfiguration{Size: 1, Overflow: "discard"}, log)
+	if ErrInvalidOverflowPolicy != err {
+		t.Errorf("policy: %v  expected: %s", err, ErrInvalidOverflowPolicy)
+	}
+}
+
+func TestQueueBlock(t *testing.T) {
+	q := newTestQueue(t, 2, overflowBlock, "")
+	putTopics(q, "a", "b")
+
+	done := make(chan struct{})
+	go func() {
+		putTopics(q, "c")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("put did not wait for space")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	expectTopics(t, q, "a")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("put did not continue after get")
+	}
+	expectTopics(t, q, "b", "c")
+}
+
+func TestQueueBlockClose(t *testing.T) {
+	q := newTestQueue(t, 1, overflowBlock, "")
+	putTopics(q, "a")
+
+	done := make(chan struct{})
+	go func() {
+		putTopics(q, "b")
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	q.close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("put still waiting after close")
+	}
+
+	// the memory item is still returned, the waiting one is not
+	expectTopics(t, q, "a")
+	if _, ok := q.get(); ok {
+		t.Fatalf("get returned an item from a closed empty queue")
+	}
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	q := newTestQueue(t, 2, overflowDropOldest, "")
+	putTopics(q, "a", "b", "c", "d")
+
+	q.Lock()
+	depth := q.depth()
+	q.Unlock()
+	if 2 != depth {
+		t.Fatalf("depth: %d  expected: 2", depth)
+	}
+	expectTopics(t, q, "c", "d")
+}
+
+func TestQueueSpill(t *testing.T) {
+	directory := t.TempDir()
+	q := newTestQueue(t, 2, overflowSpill, directory)
+	putTopics(q, "a", "b", "c", "d")
+
+	if n := spillFiles(t, directory); 2 != n {
+		t.Fatalf("spill files: %d  expected: 2", n)
+	}
+
+	// once items are on disk, new items follow them there
+	expectTopics(t, q, "a")
+	putTopics(q, "e", "f")
+	expectTopics(t, q, "b", "c", "d", "e", "f")
+
+	if n := spillFiles(t, directory); 0 != n {
+		t.Fatalf("spill files: %d  expected: 0", n)
+	}
+}
+
+func TestQueueSpillResume(t *testing.T) {
+	directory := t.TempDir()
+	q := newTestQueue(t, 1, overflowSpill, directory)
+	putTopics(q, "a", "b", "c")
+	q.close()
+
+	// only the memory item is lost, the next run loads the rest
+	q = newTestQueue(t, 1, overflowSpill, directory)
+	q.Lock()
+	depth := q.depth()
+	q.Unlock()
+	if 2 != depth {
+		t.Fatalf("depth: %d  expected: 2", depth)
+	}
+	expectTopics(t, q, "b", "c")
+}
+
+func TestQueueClosed(t *testing.T) {
+	q := newTestQueue(t, 2, overflowBlock, "")
+	putTopics(q, "a")
+	q.close()
+	putTopics(q, "b")
+
+	expectTopics(t, q, "a")
+	if _, ok := q.get(); ok {
+		t.Fatalf("get returned an item put after close")
+	}
+}
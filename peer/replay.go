@@ -0,0 +1,305 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/logger"
+	zmq "github.com/pebbe/zmq4"
+
+	"github.com/bitmark-inc/updaterd/capture"
+	"github.com/bitmark-inc/updaterd/peer/rpc"
+)
+
+// messages held in memory for each replay queue
+const replayQueueSize = 1000
+
+// errors for replay
+var (
+	ErrReplayNoMatch = errors.New("no captured reply for request")
+)
+
+// ReplayResult - what was fed back
+type ReplayResult struct {
+	Messages  int `json:"messages"`  // subscriber messages processed
+	Cycles    int `json:"cycles"`    // connector cycles run
+	Requests  int `json:"requests"`  // connector requests answered from the capture
+	Unmatched int `json:"unmatched"` // captured connector requests that were never made
+}
+
+// Replay - feed captured traffic back through the subscriber and the
+// connector, storing into the configured database
+//
+// records are taken in order: subscriber messages are processed as if
+// just received and a connector request that has not been answered
+// yet runs a connector cycle, whose requests are answered from the
+// captured requests of the same node
+//
+// must not be used together with Initialise
+func Replay(filenames []string) (*ReplayResult, error) {
+
+	records := []*capture.Record{}
+	for _, filename := range filenames {
+		r, err := readCapture(filename)
+		if nil != err {
+			return nil, err
+		}
+		records = append(records, r...)
+	}
+
+	log := logger.New("replay")
+	log.Infof("records: %d", len(records))
+
+	// one transport for each node the connector talked to
+	transports := []*replayTransport{}
+	calls := make(map[*capture.Record]*replayCall)
+	byNode := make(map[string]*replayTransport)
+	for _, r := range records {
+		if capture.SourceConnector != r.Source {
+			continue
+		}
+		t, ok := byNode[r.Node]
+		if !ok {
+			key, _ := hex.DecodeString(r.Node)
+			t = &replayTransport{
+				node: r.Node,
+				key:  key,
+			}
+			byNode[r.Node] = t
+			transports = append(transports, t)
+		}
+		c := &replayCall{record: r}
+		t.calls = append(t.calls, c)
+		calls[r] = c
+	}
+
+	sbsc := &globalData.sbsc
+	err := sbsc.initialiseReplay()
+	if nil != err {
+		return nil, err
+	}
+	conn := &globalData.conn
+	err = conn.initialiseReplay(transports)
+	if nil != err {
+		return nil, err
+	}
+
+	// blocks are only stored by the subscriber in normal mode, which
+	// the connector sets once it has caught up
+	if 0 == len(transports) {
+		mode.Set(mode.Normal)
+	} else {
+		mode.Set(mode.Resynchronise)
+	}
+
+	sbsc.workers.Add(2)
+	go sbsc.work(sbsc.blocks)
+	go sbsc.work(sbsc.transactions)
+
+	result := &ReplayResult{}
+	for _, r := range records {
+		switch r.Source {
+		case capture.SourceSubscriber:
+			if len(r.Frames) < 3 {
+				log.Warnf("subscriber record at: %s  frames: %d  skipped", r.Time, len(r.Frames))
+				continue
+			}
+			sbsc.receive(r.Frames, r.Node)
+			result.Messages += 1
+
+		case capture.SourceConnector:
+			if calls[r].used {
+				continue
+			}
+			conn.process()
+			result.Cycles += 1
+		}
+	}
+
+	sbsc.blocks.close()
+	sbsc.transactions.close()
+	sbsc.workers.Wait()
+
+	for _, t := range transports {
+		for _, c := range t.calls {
+			if c.used {
+				result.Requests += 1
+			} else {
+				result.Unmatched += 1
+			}
+		}
+	}
+	log.Infof("result: %+v", result)
+
+	return result, nil
+}
+
+// all records of a capture file
+func readCapture(filename string) ([]*capture.Record, error) {
+	f, err := os.Open(filename)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []*capture.Record{}
+	reader := capture.NewReader(f)
+	for {
+		r, err := reader.Next()
+		if io.EOF == err {
+			return records, nil
+		} else if nil != err {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+}
+
+// a subscriber without sockets
+func (sbsc *subscriber) initialiseReplay() error {
+	log := logger.New("subscriber")
+	sbsc.log = log
+
+	queue := &QueueConfiguration{
+		Size:     replayQueueSize,
+		Overflow: overflowBlock,
+	}
+	err := error(nil)
+	sbsc.blocks, err = newWorkQueue("blocks", queue, log)
+	if nil != err {
+		return err
+	}
+	sbsc.transactions, err = newWorkQueue("transactions", queue, log)
+	if nil != err {
+		return err
+	}
+	sbsc.workerCount = 1
+	sbsc.heartbeats = make(map[string]time.Time)
+	return nil
+}
+
+// a connector whose nodes answer from the capture
+// it starts by checking heights as the capture may have been started
+// at any time
+func (conn *connector) initialiseReplay(transports []*replayTransport) error {
+	conn.log = logger.New("connector")
+
+	eligibility, err := newEligibility("", "")
+	if nil != err {
+		return err
+	}
+	conn.eligibility = eligibility
+	conn.quorum = 1
+	conn.window = 1
+	conn.requests = make(chan controlRequest)
+	conn.resync = make(chan struct{}, 1)
+	conn.discovered = make(map[string]bool)
+
+	// retries were captured as separate requests, no need to wait
+	retry := rpc.DefaultRetry
+	retry.Backoff = 0
+
+	for _, t := range transports {
+		node := rpc.New(t, connectorTimeout, retry)
+		setNodeAddress(node)
+		conn.nodes = append(conn.nodes, node)
+		conn.attributes = append(conn.attributes, nodeAttributes{
+			weight: 1,
+			group:  "key:" + t.node,
+		})
+		conn.excluded = append(conn.excluded, "")
+	}
+
+	conn.state = cStateHighestBlock
+	return nil
+}
+
+// a captured request and reply
+type replayCall struct {
+	record *capture.Record
+	used   bool
+}
+
+// answers the requests to one node from its captured calls, in order
+// satisfies rpc.Transport
+type replayTransport struct {
+	node    string
+	key     []byte
+	calls   []*replayCall
+	cursor  int         // calls before this are used or skipped
+	pending *replayCall // answer to the last request sent
+}
+
+// find the next captured call with the same request
+func (t *replayTransport) Send(items ...interface{}) error {
+	request := rpc.Frames(items)
+	t.pending = nil
+	for i := t.cursor; i < len(t.calls); i += 1 {
+		c := t.calls[i]
+		if c.used || !sameFrames(c.record.Frames, request) {
+			continue
+		}
+		c.used = true
+		t.cursor = i + 1
+		t.pending = c
+		break
+	}
+	return nil
+}
+
+// the captured reply or failure
+func (t *replayTransport) Receive(flags zmq.Flag) ([][]byte, error) {
+	c := t.pending
+	t.pending = nil
+	if nil == c {
+		return nil, ErrReplayNoMatch
+	}
+	if "" != c.record.Error {
+		return nil, errors.New(c.record.Error)
+	}
+	return c.record.Reply, nil
+}
+
+// a captured reply is always ready
+func (t *replayTransport) Readable(timeout time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (t *replayTransport) Reconnect() error {
+	t.pending = nil
+	return nil
+}
+
+func (t *replayTransport) IsConnected() bool {
+	return true
+}
+
+func (t *replayTransport) String() string {
+	return "replay:" + t.node
+}
+
+func (t *replayTransport) GetServerPublicKey() []byte {
+	return t.key
+}
+
+// true if both have the same frames
+func sameFrames(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,334 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// longest single wait for a reply when the context has no deadline,
+// so that a cancellation is still noticed
+const cancelCheckInterval = time.Second
+
+// Transport - socket operations used by the client
+// satisfied by *zmqutil.Client with a REQ socket
+type Transport interface {
+	Send(items ...interface{}) error
+	Receive(flags zmq.Flag) ([][]byte, error)
+	Readable(timeout time.Duration) (bool, error)
+	Reconnect() error
+	IsConnected() bool
+	String() string
+	GetServerPublicKey() []byte
+}
+
+// Caller - many outstanding requests to the same node
+// satisfied by *zmqutil.Pipeline with a DEALER socket
+type Caller interface {
+	Call(ctx context.Context, items ...interface{}) ([][]byte, error)
+}
+
+// Recorder - receives every request to a node with the frames
+// received or the send or receive failure, e.g. capture.Connector
+type Recorder func(node string, address string, request [][]byte, reply [][]byte, err error)
+
+// Info - the reply to "I" (see bitmarkd/peer/listener.go for full record)
+type Info struct {
+	Version string `json:"version"`
+	Chain   string `json:"chain"`
+	Normal  bool   `json:"normal"`
+	Height  uint64 `json:"height"`
+}
+
+// Client - requests to a single node
+type Client struct {
+	transport Transport
+	timeout   time.Duration
+	retry     RetryPolicy
+	pipeline  Caller   // optional, for bulk block fetches
+	recorder  Recorder // optional, for capturing the traffic
+}
+
+// New - create a client, timeout applies to each attempt
+func New(transport Transport, timeout time.Duration, retry RetryPolicy) *Client {
+	return &Client{
+		transport: transport,
+		timeout:   timeout,
+		retry:     retry,
+	}
+}
+
+// WithPipeline - use caller to fetch blocks concurrently
+func (c *Client) WithPipeline(caller Caller) *Client {
+	c.pipeline = caller
+	return c
+}
+
+// WithRecorder - pass every request and its reply to recorder
+func (c *Client) WithRecorder(recorder Recorder) *Client {
+	c.recorder = recorder
+	return c
+}
+
+// Transport - the underlying socket
+func (c *Client) Transport() Transport {
+	return c.transport
+}
+
+// IsConnected - true if the transport has an address
+func (c *Client) IsConnected() bool {
+	return c.transport.IsConnected()
+}
+
+// String - address of the node
+func (c *Client) String() string {
+	return c.transport.String()
+}
+
+// PublicKey - hex public key of the node
+func (c *Client) PublicKey() string {
+	return hex.EncodeToString(c.transport.GetServerPublicKey())
+}
+
+// Info - chain, version and height of the node
+func (c *Client) Info(ctx context.Context) (*Info, error) {
+	data, err := c.call(ctx, "I")
+	if nil != err {
+		return nil, err
+	}
+
+	var info Info
+	err = json.Unmarshal(data, &info)
+	if nil != err {
+		return nil, &ProtocolError{Request: "I", Reason: err.Error()}
+	}
+	return &info, nil
+}
+
+// Height - highest block number of the node
+func (c *Client) Height(ctx context.Context) (uint64, error) {
+	data, err := c.call(ctx, "N")
+	if nil != err {
+		return 0, err
+	}
+	if 8 != len(data) {
+		return 0, &ProtocolError{Request: "N", Reason: fmt.Sprintf("height length: %d", len(data))}
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// Digest - digest of a block
+func (c *Client) Digest(ctx context.Context, blockNumber uint64) (blockdigest.Digest, error) {
+	d := blockdigest.Digest{}
+
+	data, err := c.call(ctx, "H", blockNumberParameter(blockNumber))
+	if nil != err {
+		return d, err
+	}
+	if blockdigest.Length != len(data) {
+		return d, &ProtocolError{Request: "H", Reason: fmt.Sprintf("digest length: %d", len(data))}
+	}
+	err = blockdigest.DigestFromBytes(&d, data)
+	if nil != err {
+		return d, &ProtocolError{Request: "H", Reason: err.Error()}
+	}
+	return d, nil
+}
+
+// Block - packed block data
+func (c *Client) Block(ctx context.Context, blockNumber uint64) ([]byte, error) {
+	return c.call(ctx, "B", blockNumberParameter(blockNumber))
+}
+
+// Blocks - packed data of count blocks from start
+//
+// with a pipeline all requests are outstanding at once, otherwise
+// they are sent one at a time; the result is in block order and
+// stops at the first failure, which is returned with the blocks
+// before it
+func (c *Client) Blocks(ctx context.Context, start uint64, count int) ([][]byte, error) {
+	if nil == c.pipeline {
+		blocks := make([][]byte, 0, count)
+		for i := 0; i < count; i += 1 {
+			data, err := c.Block(ctx, start+uint64(i))
+			if nil != err {
+				return blocks, err
+			}
+			blocks = append(blocks, data)
+		}
+		return blocks, nil
+	}
+
+	type reply struct {
+		data []byte
+		err  error
+	}
+	replies := make([]chan reply, count)
+	for i := 0; i < count; i += 1 {
+		replies[i] = make(chan reply, 1)
+		go func(n uint64, r chan<- reply) {
+			data, err := c.pipelined(ctx, "B", blockNumberParameter(n))
+			r <- reply{data: data, err: err}
+		}(start+uint64(i), replies[i])
+	}
+
+	blocks := make([][]byte, 0, count)
+	errX := error(nil)
+	for _, r := range replies {
+		result := <-r
+		if nil == errX && nil != result.err {
+			errX = result.err
+		}
+		if nil == errX {
+			blocks = append(blocks, result.data)
+		}
+	}
+	return blocks, errX
+}
+
+// a request over the pipeline, applying the retry policy
+// no reconnect is needed as late replies are matched by id
+func (c *Client) pipelined(ctx context.Context, request string, parameters ...interface{}) ([]byte, error) {
+	result := []byte(nil)
+	err := c.retry.do(ctx, func() error {
+		actx := ctx
+		if 0 != c.timeout {
+			var cancel context.CancelFunc
+			actx, cancel = context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+		}
+
+		items := append([]interface{}{request}, parameters...)
+		data, err := c.pipeline.Call(actx, items...)
+		c.record(items, data, err)
+		if nil != err {
+			return &TransportError{Request: request, Op: "call", Err: err}
+		}
+		result, err = decodeReply(request, data)
+		return err
+	})
+	return result, err
+}
+
+// send a request, applying the retry policy, and return the result frame
+func (c *Client) call(ctx context.Context, request string, parameters ...interface{}) ([]byte, error) {
+	result := []byte(nil)
+	err := c.retry.do(ctx, func() error {
+		r, err := c.attempt(ctx, request, parameters)
+		result = r
+		return err
+	})
+	return result, err
+}
+
+// a single request and reply
+func (c *Client) attempt(ctx context.Context, request string, parameters []interface{}) ([]byte, error) {
+	if 0 != c.timeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	items := append([]interface{}{request}, parameters...)
+	err := c.transport.Send(items...)
+	if nil != err {
+		c.record(items, nil, err)
+		c.transport.Reconnect()
+		return nil, &TransportError{Request: request, Op: "send", Err: err}
+	}
+
+	data, err := c.receive(ctx)
+	c.record(items, data, err)
+	if nil != err {
+		// reset the REQ state so that a late reply is not taken
+		// as the answer to the next request
+		c.transport.Reconnect()
+		return nil, &TransportError{Request: request, Op: "receive", Err: err}
+	}
+
+	return decodeReply(request, data)
+}
+
+// pass a request and its outcome to the recorder, if any
+func (c *Client) record(items []interface{}, reply [][]byte, err error) {
+	if nil == c.recorder {
+		return
+	}
+	c.recorder(c.PublicKey(), c.String(), Frames(items), reply, err)
+}
+
+// Frames - the frames sent for the items of a request
+func Frames(items []interface{}) [][]byte {
+	frames := make([][]byte, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case []byte:
+			frames = append(frames, v)
+		case string:
+			frames = append(frames, []byte(v))
+		default:
+			frames = append(frames, []byte(fmt.Sprint(v)))
+		}
+	}
+	return frames
+}
+
+// the result frame of a reply
+func decodeReply(request string, data [][]byte) ([]byte, error) {
+	if 2 != len(data) {
+		return nil, &ProtocolError{Request: request, Reason: fmt.Sprintf("received: %d frames  expected: 2", len(data))}
+	}
+
+	switch string(data[0]) {
+	case "E":
+		return nil, &RemoteError{Request: request, Message: string(data[1])}
+	case request:
+		return data[1], nil
+	default:
+		return nil, &ProtocolError{Request: request, Reason: fmt.Sprintf("unexpected reply: %q", data[0])}
+	}
+}
+
+// wait for a reply until the context ends
+func (c *Client) receive(ctx context.Context) ([][]byte, error) {
+	for {
+		timeout := cancelCheckInterval
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		if err := ctx.Err(); nil != err {
+			return nil, err
+		}
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+
+		ready, err := c.transport.Readable(timeout)
+		if zmq.AsErrno(err) == zmq.Errno(syscall.EINTR) {
+			continue
+		} else if nil != err {
+			return nil, err
+		}
+		if ready {
+			return c.transport.Receive(zmq.DONTWAIT)
+		}
+	}
+}
+
+// block numbers are sent as 8 bytes big endian
+func blockNumberParameter(blockNumber uint64) []byte {
+	parameter := make([]byte, 8)
+	binary.BigEndian.PutUint64(parameter, blockNumber)
+	return parameter
+}
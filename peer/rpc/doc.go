@@ -0,0 +1,9 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// typed client for the bitmarkd peer RPC requests
+//
+// bitmarkd answers each request with two frames: the request code and
+// its result, or "E" and an error message
+package rpc
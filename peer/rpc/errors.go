@@ -0,0 +1,63 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TransportError - the request could not be sent or no reply was received
+type TransportError struct {
+	Request string
+	Op      string // "send" or "receive"
+	Err     error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("rpc %s: %s: %s", e.Request, e.Op, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// ProtocolError - a reply was received but could not be understood
+type ProtocolError struct {
+	Request string
+	Reason  string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("rpc %s: invalid response: %s", e.Request, e.Reason)
+}
+
+// RemoteError - the node replied with an "E" error frame
+type RemoteError struct {
+	Request string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("rpc %s: remote error: %s", e.Request, e.Message)
+}
+
+// IsTimeout - true if no reply arrived before the deadline
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// IsTransport - true for send and receive failures, including timeouts
+func IsTransport(err error) bool {
+	var t *TransportError
+	return errors.As(err, &t)
+}
+
+// IsRemote - true if the node returned an error
+func IsRemote(err error) bool {
+	var r *RemoteError
+	return errors.As(err, &r)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy - how often to repeat a failed request
+type RetryPolicy struct {
+	Attempts  int              // total tries, values below 1 mean 1
+	Backoff   time.Duration    // wait before the second try, doubled for each later try
+	Retryable func(error) bool // nil means only transport errors
+}
+
+// predefined policies
+var (
+	NoRetry      = RetryPolicy{Attempts: 1}
+	DefaultRetry = RetryPolicy{Attempts: 3, Backoff: 500 * time.Millisecond, Retryable: IsTransient}
+)
+
+// IsTransient - transport errors other than timeouts
+// a node that did not answer within the timeout is unlikely to answer a retry
+func IsTransient(err error) bool {
+	return IsTransport(err) && !IsTimeout(err)
+}
+
+// call f until it succeeds, the policy gives up or the context ends
+func (p RetryPolicy) do(ctx context.Context, f func() error) error {
+	retryable := p.Retryable
+	if nil == retryable {
+		retryable = IsTransport
+	}
+
+	backoff := p.Backoff
+	err := error(nil)
+	for attempt := 1; ; attempt += 1 {
+		err = f()
+		if nil == err || attempt >= p.Attempts || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+
+	"github.com/bitmark-inc/updaterd/peer/rpc"
+)
+
+// node roles
+const (
+	roleBoth      = "both"      // default
+	roleRPC       = "rpc"       // connector only
+	roleSubscribe = "subscribe" // subscriber only
+)
+
+// errors for the node configuration
+var (
+	ErrInvalidNodeRole   = errors.New("invalid node role")
+	ErrInvalidNodeWeight = errors.New("invalid node weight")
+	ErrInvalidQuorum     = errors.New("quorum exceeds the number of node groups")
+)
+
+// selection attributes of an RPC node
+type nodeAttributes struct {
+	priority int
+	weight   int
+	group    string
+}
+
+// a node that answered the height request
+type candidate struct {
+	node   *rpc.Client
+	height uint64
+	nodeAttributes
+}
+
+// true if the connection is used for the role
+func (c *Connection) hasRole(role string) bool {
+	return "" == c.Role || roleBoth == c.Role || role == c.Role
+}
+
+// the connections used for the role
+func connectionsForRole(connections []Connection, role string) []Connection {
+	selected := make([]Connection, 0, len(connections))
+	for _, c := range connections {
+		if c.hasRole(role) {
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
+
+// the selection attributes of a connection
+// nodes without a group are a group of their own
+func (c *Connection) attributes() nodeAttributes {
+	group := c.Group
+	if "" == group {
+		group = "key:" + c.PublicKey
+	}
+	weight := c.Weight
+	if 0 == weight {
+		weight = 1
+	}
+	return nodeAttributes{
+		priority: c.Priority,
+		weight:   weight,
+		group:    group,
+	}
+}
+
+// check the node attributes and that the quorum can be reached
+func validateNodes(connections []Connection, quorum int) error {
+	groups := make(map[string]struct{})
+	for _, c := range connections {
+		switch c.Role {
+		case "", roleBoth, roleRPC, roleSubscribe:
+		default:
+			return ErrInvalidNodeRole
+		}
+		if c.Weight < 0 {
+			return ErrInvalidNodeWeight
+		}
+		if c.hasRole(roleRPC) {
+			groups[c.attributes().group] = struct{}{}
+		}
+	}
+	if quorum > len(groups) {
+		return ErrInvalidQuorum
+	}
+	return nil
+}
+
+// the height agreed by the quorum and the node to fetch from
+//
+// the height is the highest that nodes in at least quorum different
+// groups have reached, nodes without a group (discovered nodes) are
+// not counted; the node is taken from those at or above it
+// with the best (lowest) priority, weighted randomly within the
+// same priority
+func selectNode(candidates []candidate, quorum int) (uint64, *rpc.Client) {
+	if quorum < 1 {
+		quorum = 1
+	}
+
+	// highest height of each group
+	groupHeights := make(map[string]uint64)
+	for _, c := range candidates {
+		if "" == c.group {
+			continue
+		}
+		if c.height > groupHeights[c.group] {
+			groupHeights[c.group] = c.height
+		}
+	}
+	if len(groupHeights) < quorum {
+		return 0, nil
+	}
+	heights := make([]uint64, 0, len(groupHeights))
+	for _, h := range groupHeights {
+		heights = append(heights, h)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] > heights[j] })
+	height := heights[quorum-1]
+	if 0 == height {
+		return 0, nil
+	}
+
+	// best priority among the nodes at that height
+	eligible := make([]candidate, 0, len(candidates))
+	for _, c := range candidates {
+		if c.height < height {
+			continue
+		}
+		if 0 != len(eligible) && c.priority > eligible[0].priority {
+			continue
+		}
+		if 0 != len(eligible) && c.priority < eligible[0].priority {
+			eligible = eligible[:0]
+		}
+		eligible = append(eligible, c)
+	}
+
+	total := 0
+	for _, c := range eligible {
+		total += c.weight
+	}
+	r := rand.Intn(total)
+	for _, c := range eligible {
+		if r < c.weight {
+			return height, c.node
+		}
+		r -= c.weight
+	}
+	return height, eligible[len(eligible)-1].node
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"testing"
+
+	"github.com/bitmark-inc/updaterd/peer/rpc"
+)
+
+func newCandidate(height uint64, priority int, weight int, group string) candidate {
+	return candidate{
+		node:   &rpc.Client{},
+		height: height,
+		nodeAttributes: nodeAttributes{
+			priority: priority,
+			weight:   weight,
+			group:    group,
+		},
+	}
+}
+
+func TestSelectNodeQuorum(t *testing.T) {
+	a := newCandidate(100, 0, 1, "a")
+	b := newCandidate(90, 0, 1, "b")
+	c := newCandidate(80, 0, 1, "c")
+	a2 := newCandidate(120, 0, 1, "a")
+	d := newCandidate(110, 0, 1, "")
+
+	items := []struct {
+		candidates []candidate
+		quorum     int
+		height     uint64
+		nodes      []candidate // any of these may be chosen
+	}{
+		{nil, 1, 0, nil},
+		{[]candidate{a}, 0, 100, []candidate{a}},
+		{[]candidate{a}, 2, 0, nil},
+		{[]candidate{a, b, c}, 1, 100, []candidate{a}},
+		{[]candidate{a, b, c}, 2, 90, []candidate{a, b}},
+		{[]candidate{a, b, c}, 3, 80, []candidate{a, b, c}},
+		{[]candidate{a, b, c}, 4, 0, nil},
+		// one group counts once, at its highest node
+		{[]candidate{a, a2}, 2, 0, nil},
+		{[]candidate{a, a2, c}, 2, 80, []candidate{a, a2, c}},
+		{[]candidate{newCandidate(0, 0, 1, "a")}, 1, 0, nil},
+		// nodes without a group do not count, but may be chosen
+		{[]candidate{d}, 1, 0, nil},
+		{[]candidate{a, d}, 2, 0, nil},
+		{[]candidate{b, d}, 1, 90, []candidate{b, d}},
+	}
+
+	for i, item := range items {
+		for n := 0; n < 20; n += 1 {
+			height, node := selectNode(item.candidates, item.quorum)
+			if item.height != height {
+				t.Fatalf("%d: height: %d  expected: %d", i, height, item.height)
+			}
+			if !chosenFrom(node, item.nodes) {
+				t.Fatalf("%d: unexpected node: %p", i, node)
+			}
+		}
+	}
+}
+
+func TestSelectNodePriority(t *testing.T) {
+	preferred := newCandidate(100, 0, 1, "a")
+	other := newCandidate(100, 1, 100, "b")
+	behind := newCandidate(90, 0, 1, "c")
+
+	for n := 0; n < 100; n += 1 {
+		_, node := selectNode([]candidate{other, preferred, behind}, 1)
+		if node != preferred.node {
+			t.Fatalf("node: %p  expected the preferred: %p", node, preferred.node)
+		}
+	}
+
+	// a preferred node below the agreed height is not used
+	for n := 0; n < 100; n += 1 {
+		height, node := selectNode([]candidate{other, behind}, 1)
+		if 100 != height || node != other.node {
+			t.Fatalf("height: %d  node: %p  expected: 100 %p", height, node, other.node)
+		}
+	}
+}
+
+func TestSelectNodeWeight(t *testing.T) {
+	light := newCandidate(100, 0, 1, "a")
+	heavy := newCandidate(100, 0, 3, "b")
+
+	const rounds = 4000
+	count := 0
+	for n := 0; n < rounds; n += 1 {
+		_, node := selectNode([]candidate{light, heavy}, 1)
+		if node == heavy.node {
+			count += 1
+		} else if node != light.node {
+			t.Fatalf("unexpected node: %p", node)
+		}
+	}
+
+	// expected 3000, allow for chance
+	if count < 2700 || count > 3300 {
+		t.Fatalf("heavy node chosen: %d of %d  expected about: %d", count, rounds, 3*rounds/4)
+	}
+}
+
+func TestConnectionAttributes(t *testing.T) {
+	c := Connection{PublicKey: "pk", Priority: 2}
+	a := c.attributes()
+	if 2 != a.priority || 1 != a.weight || "key:pk" != a.group {
+		t.Errorf("attributes: %+v", a)
+	}
+
+	c = Connection{PublicKey: "pk", Weight: 5, Group: "site"}
+	a = c.attributes()
+	if 0 != a.priority || 5 != a.weight || "site" != a.group {
+		t.Errorf("attributes: %+v", a)
+	}
+}
+
+func TestValidateNodes(t *testing.T) {
+	connections := []Connection{
+		{PublicKey: "one", Group: "site"},
+		{PublicKey: "two", Group: "site", Role: roleRPC},
+		{PublicKey: "three", Role: roleBoth},
+		{PublicKey: "four", Role: roleSubscribe},
+	}
+
+	items := []struct {
+		connections []Connection
+		quorum      int
+		err         error
+	}{
+		{connections, 2, nil},
+		{connections, 3, ErrInvalidQuorum}, // the subscriber is not counted
+		{[]Connection{{PublicKey: "x", Role: "fetch"}}, 0, ErrInvalidNodeRole},
+		{[]Connection{{PublicKey: "x", Weight: -1}}, 0, ErrInvalidNodeWeight},
+	}
+	for i, item := range items {
+		err := validateNodes(item.connections, item.quorum)
+		if item.err != err {
+			t.Errorf("%d: error: %v  expected: %v", i, err, item.err)
+		}
+	}
+
+	rpcNodes := connectionsForRole(connections, roleRPC)
+	if 3 != len(rpcNodes) {
+		t.Errorf("rpc connections: %d  expected: 3", len(rpcNodes))
+	}
+	subscribers := connectionsForRole(connections, roleSubscribe)
+	if 3 != len(subscribers) {
+		t.Errorf("subscribe connections: %d  expected: 3", len(subscribers))
+	}
+}
+
+func chosenFrom(node *rpc.Client, candidates []candidate) bool {
+	if 0 == len(candidates) {
+		return nil == node
+	}
+	for _, c := range candidates {
+		if node == c.node {
+			return true
+		}
+	}
+	return false
+}
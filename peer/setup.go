@@ -10,22 +10,39 @@ import (
 	"github.com/bitmark-inc/bitmarkd/zmqutil"
 	"github.com/bitmark-inc/logger"
 	"sync"
+	"time"
 )
 
 // hardwired connections
 // this is read from a lua configuration file
+// subscribe and connect are "host:port", host may be an IP address or a DNS name
+// role is "both" (default), "rpc" or "subscribe", see selection.go for
+// how priority, weight and group choose the node to fetch from
 type Connection struct {
 	PublicKey string `gluamapper:"public_key" json:"public_key"`
 	Subscribe string `gluamapper:"subscribe" json:"subscribe"`
 	Connect   string `gluamapper:"connect" json:"connect"`
+	Role      string `gluamapper:"role" json:"role"`
+	Priority  int    `gluamapper:"priority" json:"priority"` // lower is preferred
+	Weight    int    `gluamapper:"weight" json:"weight"`     // share of fetches within a priority, 0 => 1
+	Group     string `gluamapper:"group" json:"group"`       // blank => a group of its own
 }
 
 // a block of configuration data
 // this is read from a lua configuration file
 type Configuration struct {
-	PrivateKey string       `gluamapper:"private_key" json:"private_key"`
-	PublicKey  string       `gluamapper:"public_key" json:"public_key"`
-	Node       []Connection `gluamapper:"node" json:"node"`
+	PrivateKey string             `gluamapper:"private_key" json:"private_key"`
+	PublicKey  string             `gluamapper:"public_key" json:"public_key"`
+	Node       []Connection       `gluamapper:"node" json:"node"`
+	Queue      QueueConfiguration `gluamapper:"queue" json:"queue"`
+	Pipeline   int                `gluamapper:"pipeline" json:"pipeline"`       // outstanding block requests per node, 0 => one at a time
+	Quorum     int                `gluamapper:"quorum" json:"quorum"`           // groups that must reach a height before it is fetched, 0 => 1
+	MinVersion string             `gluamapper:"min_version" json:"min_version"` // oldest bitmarkd to fetch from, blank => any
+	MaxVersion string             `gluamapper:"max_version" json:"max_version"` // newest bitmarkd to fetch from, blank => any
+
+	ResolveInterval int `gluamapper:"resolve_interval" json:"resolve_interval"` // seconds between lookups of node host names, 0 => only after failures
+
+	Discovery DiscoveryConfiguration `gluamapper:"discovery" json:"discovery"`
 }
 
 // globals for background proccess
@@ -76,10 +93,33 @@ func Initialise(configuration *Configuration) error {
 	globalData.log.Tracef("peer private key: %q", privateKey)
 	globalData.log.Tracef("peer public key:  %q", publicKey)
 
-	if err := globalData.conn.initialise(privateKey, publicKey, configuration.Node); nil != err {
+	err = validateNodes(configuration.Node, configuration.Quorum)
+	if nil != err {
+		globalData.log.Errorf("node configuration error: %s", err)
+		return err
+	}
+
+	eligibility, err := newEligibility(configuration.MinVersion, configuration.MaxVersion)
+	if nil != err {
+		globalData.log.Errorf("node version range: %q to %q  error: %s", configuration.MinVersion, configuration.MaxVersion, err)
+		return err
+	}
+
+	resolveInterval := time.Duration(configuration.ResolveInterval) * time.Second
+	rpcNodes := connectionsForRole(configuration.Node, roleRPC)
+	subscribeNodes := connectionsForRole(configuration.Node, roleSubscribe)
+
+	// nil => discovery disabled
+	pool := (*discovery)(nil)
+	if configuration.Discovery.Enabled {
+		pool = newDiscovery(&configuration.Discovery, configuration.Node)
+		globalData.log.Infof("discovery: up to %d nodes", configuration.Discovery.MaximumNodes)
+	}
+
+	if err := globalData.conn.initialise(privateKey, publicKey, rpcNodes, configuration.Pipeline, configuration.Quorum, eligibility, resolveInterval, pool); nil != err {
 		return err
 	}
-	if err := globalData.sbsc.initialise(privateKey, publicKey, configuration.Node); nil != err {
+	if err := globalData.sbsc.initialise(privateKey, publicKey, subscribeNodes, &configuration.Queue, resolveInterval, pool); nil != err {
 		return err
 	}
 
@@ -0,0 +1,42 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bitmark-inc/logger"
+)
+
+// the queue logs, so the logger needs a directory
+func TestMain(m *testing.M) {
+	directory, err := ioutil.TempDir("", "peer-test-")
+	if nil != err {
+		fmt.Fprintf(os.Stderr, "temporary directory error: %s\n", err)
+		os.Exit(1)
+	}
+
+	err = logger.Initialise(logger.Configuration{
+		Directory: directory,
+		File:      "test.log",
+		Size:      50000,
+		Count:     10,
+		Levels:    map[string]string{logger.DefaultTag: "critical"},
+	})
+	if nil != err {
+		os.RemoveAll(directory)
+		fmt.Fprintf(os.Stderr, "logger error: %s\n", err)
+		os.Exit(1)
+	}
+
+	rc := m.Run()
+
+	logger.Finalise()
+	os.RemoveAll(directory)
+	os.Exit(rc)
+}
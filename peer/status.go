@@ -0,0 +1,208 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/mode"
+
+	"github.com/bitmark-inc/updaterd/peer/rpc"
+)
+
+// NodeStatus - the view of a single node
+type NodeStatus struct {
+	PublicKey     string     `json:"public_key"`
+	Address       string     `json:"address"`
+	Responding    bool       `json:"responding"`
+	Height        uint64     `json:"height"`
+	LastResponse  *time.Time `json:"last_response,omitempty"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	Excluded      string     `json:"excluded,omitempty"` // why blocks are not fetched from the node
+}
+
+// Status - snapshot of the synchronisation state
+type Status struct {
+	State        string       `json:"state"`
+	Paused       bool         `json:"paused"`
+	Mode         string       `json:"mode"`
+	RemoteHeight uint64       `json:"remote_height"`
+	Nodes        []NodeStatus `json:"nodes"`
+}
+
+// per-node results of the most recent requests
+type nodeRecord struct {
+	address      string // copied when the connector (re)connects the node
+	responding   bool
+	height       uint64
+	lastResponse time.Time
+	lastError    string
+	failures     int    // consecutive failed requests
+	excluded     string // reason the node is not eligible
+}
+
+// status shared between the connector and any readers
+type statusData struct {
+	sync.RWMutex
+	state        connectorState
+	paused       bool
+	remoteHeight uint64
+	nodes        map[*rpc.Client]*nodeRecord
+}
+
+var status = statusData{
+	nodes: make(map[*rpc.Client]*nodeRecord),
+}
+
+// record a successful response from a node
+func nodeResponded(client *rpc.Client, height uint64) {
+	status.Lock()
+	defer status.Unlock()
+
+	r := status.record(client)
+	r.responding = true
+	r.height = height
+	r.lastResponse = time.Now()
+	r.lastError = ""
+	r.failures = 0
+}
+
+// record a failed request to a node
+func nodeFailed(client *rpc.Client, err error) {
+	status.Lock()
+	defer status.Unlock()
+
+	r := status.record(client)
+	r.responding = false
+	r.lastError = err.Error()
+	r.failures += 1
+}
+
+// record the current address of a node, called from the connector
+// after anything that may reconnect it
+func setNodeAddress(client *rpc.Client) {
+	address := client.String()
+
+	status.Lock()
+	defer status.Unlock()
+
+	status.record(client).address = address
+}
+
+// record why a node is not eligible, blank if it is
+func setNodeExcluded(client *rpc.Client, reason string) {
+	status.Lock()
+	defer status.Unlock()
+
+	status.record(client).excluded = reason
+}
+
+// number of requests that failed since the last response
+func consecutiveFailures(client *rpc.Client) int {
+	status.Lock()
+	defer status.Unlock()
+
+	return status.record(client).failures
+}
+
+// start counting failures again, e.g. after a new address
+func resetFailures(client *rpc.Client) {
+	status.Lock()
+	defer status.Unlock()
+
+	status.record(client).failures = 0
+}
+
+// record a failed request for both the metrics and the node status
+func rpcFailure(client *rpc.Client, request string, err error) {
+	countRPCError(client, request, err)
+	nodeFailed(client, err)
+}
+
+// record the connector state and best remote height
+func setConnectorStatus(state connectorState, remoteHeight uint64) {
+	status.Lock()
+	status.state = state
+	status.remoteHeight = remoteHeight
+	status.Unlock()
+}
+
+// record whether the connector is paused
+func setPaused(paused bool) {
+	status.Lock()
+	status.paused = paused
+	status.Unlock()
+}
+
+// must be called with the lock held
+func (s *statusData) record(client *rpc.Client) *nodeRecord {
+	r, ok := s.nodes[client]
+	if !ok {
+		r = &nodeRecord{}
+		s.nodes[client] = r
+	}
+	return r
+}
+
+// GetStatus - return a snapshot of the current state
+func GetStatus() Status {
+
+	heartbeats := globalData.sbsc.lastHeartbeats()
+
+	status.RLock()
+	defer status.RUnlock()
+
+	s := Status{
+		State:        status.state.String(),
+		Paused:       status.paused,
+		Mode:         mode.String(),
+		RemoteHeight: status.remoteHeight,
+		Nodes:        make([]NodeStatus, 0, len(globalData.conn.nodes)),
+	}
+
+	for _, node := range globalData.conn.nodes {
+		if nil == node {
+			continue
+		}
+		key := node.PublicKey()
+		n := NodeStatus{
+			PublicKey: key,
+		}
+		if r, ok := status.nodes[node]; ok {
+			n.Address = r.address
+			n.Responding = r.responding
+			n.Height = r.height
+			n.LastError = r.lastError
+			n.Excluded = r.excluded
+			if !r.lastResponse.IsZero() {
+				t := r.lastResponse
+				n.LastResponse = &t
+			}
+		}
+		if t, ok := heartbeats[key]; ok {
+			n.LastHeartbeat = &t
+		}
+		s.Nodes = append(s.Nodes, n)
+	}
+	return s
+}
+
+// IsSynchronised - true if the connector has finished catching up
+func (s Status) IsSynchronised() bool {
+	return cStateSampling.String() == s.State
+}
+
+// RespondingNodes - count of nodes that answered their last request
+func (s Status) RespondingNodes() int {
+	n := 0
+	for _, node := range s.Nodes {
+		if node.Responding {
+			n += 1
+		}
+	}
+	return n
+}
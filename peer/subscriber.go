@@ -7,14 +7,17 @@ package peer
 import (
 	"bytes"
 	"encoding/hex"
+	"sync"
 	"time"
 
 	"github.com/bitmark-inc/bitmarkd/fault"
 	"github.com/bitmark-inc/bitmarkd/mode"
-	"github.com/bitmark-inc/bitmarkd/util"
 	"github.com/bitmark-inc/logger"
 	zmq "github.com/pebbe/zmq4"
 
+	"github.com/bitmark-inc/updaterd/capture"
+	"github.com/bitmark-inc/updaterd/deadletter"
+	"github.com/bitmark-inc/updaterd/metrics"
 	"github.com/bitmark-inc/updaterd/storage"
 	"github.com/bitmark-inc/updaterd/zmqutil"
 )
@@ -22,6 +25,12 @@ import (
 const (
 	subscriberSignal = "inproc://bitmark-subscriber-signal"
 
+	// connection events waiting to be logged
+	subscriberEventQueue = 32
+
+	// connection failures of a node before looking up its host name again
+	subscriberResolveAfter = 3
+
 	// must be the same as bitmarkd: peer/broadcaster.go
 	heartbeatInterval = 60 * time.Second
 	heartbeatTimeout  = 2 * heartbeatInterval
@@ -32,10 +41,31 @@ type subscriber struct {
 	push    *zmq.Socket
 	pull    *zmq.Socket
 	clients []*zmqutil.Client
+	events  chan zmqutil.Event // from the socket monitors
+
+	resolveInterval time.Duration           // between host name lookups, 0 => only after failures
+	failures        map[*zmqutil.Client]int // connection failures since last connected
+
+	// nodes from the discovery pool, only changed by the polling goroutine
+	privateKey []byte
+	publicKey  []byte
+	poller     *zmqutil.Poller
+	discovery  *discovery // nil => only static nodes
+	discovered map[string]*zmqutil.Client
+
+	// time of last heartbeat, indexed by node public key
+	heartbeatLock sync.Mutex
+	heartbeats    map[string]time.Time
+
+	// received messages waiting for the storage workers
+	blocks       *workQueue // always a single worker to keep blocks in order
+	transactions *workQueue // several workers may store these out of order
+	workerCount  int
+	workers      sync.WaitGroup
 }
 
 // initialise the subscriber
-func (sbsc *subscriber) initialise(privateKey []byte, publicKey []byte, connections []Connection) error {
+func (sbsc *subscriber) initialise(privateKey []byte, publicKey []byte, connections []Connection, queue *QueueConfiguration, resolveInterval time.Duration, pool *discovery) error {
 
 	log := logger.New("subscriber")
 	sbsc.log = log
@@ -49,8 +79,24 @@ func (sbsc *subscriber) initialise(privateKey []byte, publicKey []byte, connecti
 		return fault.ErrNoConnectionsAvailable
 	}
 
-	// signalling channel
+	// storage queues
 	err := error(nil)
+	sbsc.blocks, err = newWorkQueue("blocks", queue, log)
+	if nil != err {
+		log.Errorf("blocks queue error: %s", err)
+		return err
+	}
+	sbsc.transactions, err = newWorkQueue("transactions", queue, log)
+	if nil != err {
+		log.Errorf("transactions queue error: %s", err)
+		return err
+	}
+	sbsc.workerCount = queue.Workers
+	if sbsc.workerCount < 1 {
+		sbsc.workerCount = 1
+	}
+
+	// signalling channel
 	sbsc.push, sbsc.pull, err = zmqutil.NewSignalPair(subscriberSignal)
 	if nil != err {
 		return err
@@ -58,18 +104,20 @@ func (sbsc *subscriber) initialise(privateKey []byte, publicKey []byte, connecti
 
 	// all sockets
 	sbsc.clients = make([]*zmqutil.Client, connectionCount)
+	sbsc.heartbeats = make(map[string]time.Time)
+	sbsc.events = make(chan zmqutil.Event, subscriberEventQueue)
+	sbsc.failures = make(map[*zmqutil.Client]int)
+	sbsc.resolveInterval = resolveInterval
+	sbsc.privateKey = privateKey
+	sbsc.publicKey = publicKey
+	sbsc.discovery = pool
+	sbsc.discovered = make(map[string]*zmqutil.Client)
 
 	// error for goto fail
 	errX := error(nil)
 
 	// connect all static sockets
 	for i, c := range connections {
-		address, err := util.NewConnection(c.Subscribe)
-		if nil != err {
-			log.Errorf("client[%d]=address: %q  error: %s", i, c.Subscribe, err)
-			errX = err
-			goto fail
-		}
 		serverPublicKey, err := hex.DecodeString(c.PublicKey)
 		if nil != err {
 			log.Errorf("client[%d]=public: %q  error: %s", i, c.PublicKey, err)
@@ -92,9 +140,13 @@ func (sbsc *subscriber) initialise(privateKey []byte, publicKey []byte, connecti
 		}
 
 		sbsc.clients[i] = client
+		client.Notify(sbsc.events)
 
-		err = client.Connect(address, serverPublicKey, mode.ChainName())
-		if nil != err {
+		// a name that does not resolve yet is retried by resolve()
+		err = client.ConnectHost(c.Subscribe, serverPublicKey, mode.ChainName())
+		if zmqutil.IsLookupError(err) {
+			log.Warnf("connect[%d]=%q  lookup error: %s", i, c.Subscribe, err)
+		} else if nil != err {
 			log.Errorf("connect[%d]=%q  error: %s", i, c.Subscribe, err)
 			errX = err
 			goto fail
@@ -117,17 +169,22 @@ func (sbsc *subscriber) Run(args interface{}, shutdown <-chan struct{}) {
 
 	log.Info("starting…")
 
+	// storage workers
+	sbsc.workers.Add(1 + sbsc.workerCount)
+	go sbsc.work(sbsc.blocks)
+	for i := 0; i < sbsc.workerCount; i += 1 {
+		go sbsc.work(sbsc.transactions)
+	}
+
 	go func() {
 
-		expiryRegister := make(map[*zmq.Socket]time.Time)
-		checkAt := time.Now().Add(heartbeatTimeout)
 		poller := zmqutil.NewPoller()
+		sbsc.poller = poller
 
+		// dead connections are detected by ZMTP heartbeats and
+		// reported by the monitor events below
 		for _, client := range sbsc.clients {
-			socket := client.BeginPolling(poller, zmq.POLLIN)
-			if nil != socket {
-				expiryRegister[socket] = checkAt
-			}
+			client.BeginPolling(poller, zmq.POLLIN)
 		}
 		poller.Add(sbsc.pull, zmq.POLLIN)
 
@@ -135,75 +192,23 @@ func (sbsc *subscriber) Run(args interface{}, shutdown <-chan struct{}) {
 		for {
 			log.Info("waiting…")
 
-			//polled, _ := poller.Poll(-1)
 			polled, _ := poller.Poll(heartbeatTimeout)
 			if 0 == len(polled) {
-				log.Infof("timeout exceed at %s", heartbeatTimeout.String())
-			}
-
-			now := time.Now()
-			expiresAt := now.Add(heartbeatTimeout)
-			if now.After(checkAt) {
-				log.Debugf("current time %s after check time %s",
-					now.String(),
-					checkAt.String(),
-				)
-				checkAt = expiresAt
-				for s, expires := range expiryRegister {
-					if now.After(expires) {
-						client := zmqutil.ClientFromSocket(s)
-						log.Infof("socket %s expired", s.String())
-						if nil == client { // this socket has been closed
-							log.Errorf("cannto find client from list, delete from expiry list",
-								s.String(),
-							)
-							log.Info("expiry list:")
-							for k, v := range expiryRegister {
-								log.Infof("socket %s expired at %s",
-									k.String(),
-									v.String(),
-								)
-							}
-							delete(expiryRegister, s)
-						} else if client.IsConnected() {
-							log.Infof("client %s reconnect to remote", client.BasicInfo())
-							s, err := client.ReconnectReturningSocket()
-							if nil != err {
-								log.Errorf("client %s reconnect with error: %s",
-									client.BasicInfo(),
-									err,
-								)
-							} else {
-								delete(expiryRegister, s)
-								// note this new entry may or may not be rescanned by range in this loop
-								// since it will have future time it will not be immediately deleted
-								log.Infof("client %s reconnects, extend expiry time",
-									client.BasicInfo(),
-								)
-								expiryRegister[s] = expiresAt
-							}
-						} else {
-							log.Debugf("client %s not connected, extend expiry time",
-								client.BasicInfo(),
-							)
-							expiryRegister[s] = expiresAt
-						}
-					} else if expires.Before(checkAt) {
-						log.Debugf("shorten check time from %s to %s",
-							checkAt.String(),
-							expires.String(),
-						)
-						checkAt = expires
-					}
-				}
+				log.Infof("no messages for: %s", heartbeatTimeout)
+				sbsc.resolve(false) // names that did not resolve have no monitor events
+				sbsc.discover()
 			}
 
 			for _, p := range polled {
 				switch s := p.Socket; s {
 				case sbsc.pull:
-					_, err := s.RecvMessageBytes(0)
+					data, err := s.RecvMessageBytes(0)
 					if nil != err {
 						log.Errorf("pull receive error: %s", err)
+					} else if 1 == len(data) && "resolve" == string(data[0]) {
+						// sockets are only replaced here, in the polling goroutine
+						sbsc.resolve(true)
+						continue loop
 					}
 					break loop
 
@@ -212,15 +217,14 @@ func (sbsc *subscriber) Run(args interface{}, shutdown <-chan struct{}) {
 					if nil != err {
 						log.Errorf("receive error: %s", err)
 					} else {
-						theChain := string(data[0])
-						if theChain != mode.ChainName() {
-							log.Errorf("invalid chain: actual: %q  expect: %s", theChain, mode.ChainName())
-							continue loop
+						node, address := "", ""
+						if client := zmqutil.ClientFromSocket(s); nil != client {
+							node = hex.EncodeToString(client.GetServerPublicKey())
+							address = client.String()
 						}
-						client := zmqutil.ClientFromSocket(s)
-						sbsc.process(data[1:], client)
+						capture.Subscriber(node, address, data)
+						sbsc.receive(data, node)
 					}
-					expiryRegister[s] = expiresAt
 				}
 			}
 		}
@@ -228,6 +232,14 @@ func (sbsc *subscriber) Run(args interface{}, shutdown <-chan struct{}) {
 		zmqutil.CloseClients(sbsc.clients)
 	}()
 
+	// nil channel => never scheduled
+	resolveTimer := (<-chan time.Time)(nil)
+	if 0 != sbsc.resolveInterval {
+		ticker := time.NewTicker(sbsc.resolveInterval)
+		defer ticker.Stop()
+		resolveTimer = ticker.C
+	}
+
 loop:
 	for {
 		log.Info("select…")
@@ -236,29 +248,213 @@ loop:
 		// wait for shutdown
 		case <-shutdown:
 			break loop
-			// wait for message
+
+		case event := <-sbsc.events:
+			if sbsc.connectionEvent(event) {
+				sbsc.push.SendMessage("resolve")
+			}
+
+		case <-resolveTimer:
+			sbsc.push.SendMessage("resolve")
 		}
 	}
 
 	sbsc.push.SendMessage("stop")
 	sbsc.push.Close()
+
+	// finish any queued items in memory
+	sbsc.blocks.close()
+	sbsc.transactions.close()
+	sbsc.workers.Wait()
+}
+
+// log a change in the connection to a node
+// returns true if the node keeps failing and its host name should be
+// looked up again
+func (sbsc *subscriber) connectionEvent(event zmqutil.Event) bool {
+	log := sbsc.log
+
+	node := hex.EncodeToString(event.Client.GetServerPublicKey())
+	subscriberConnectionCounter.With(node, event.Type.String()).Inc()
+
+	switch event.Type {
+	case zmqutil.EventConnected, zmqutil.EventReady:
+		log.Infof("node: %s  at: %s  %s", node, event.Address, event.Type)
+	case zmqutil.EventDisconnected:
+		log.Warnf("node: %s  at: %s  %s", node, event.Address, event.Type)
+	case zmqutil.EventAuthFailed:
+		log.Errorf("node: %s  at: %s  %s: check the node public key", node, event.Address, event.Type)
+	case zmqutil.EventRetry:
+		log.Debugf("node: %s  at: %s  %s", node, event.Address, event.Type)
+	default:
+		log.Errorf("node: %s  at: %s  %s", node, event.Address, event.Type)
+	}
+
+	switch event.Type {
+	case zmqutil.EventConnected, zmqutil.EventReady:
+		delete(sbsc.failures, event.Client)
+	case zmqutil.EventDisconnected, zmqutil.EventRetry, zmqutil.EventHandshakeFailed:
+		sbsc.failures[event.Client] += 1
+		if sbsc.failures[event.Client] >= subscriberResolveAfter && "" != event.Client.Host() {
+			delete(sbsc.failures, event.Client)
+			return true
+		}
+	}
+	return false
+}
+
+// look up node host names again and reconnect any that moved
+// all false => only clients without an address
+// must only be called from the polling goroutine
+func (sbsc *subscriber) resolve(all bool) {
+	log := sbsc.log
+
+	for _, client := range sbsc.clients {
+		if "" == client.Host() || (!all && client.IsConnected()) {
+			continue
+		}
+		changed, err := client.Resolve()
+		if nil != err {
+			log.Warnf("resolve: %q  error: %s", client.Host(), err)
+		} else if changed {
+			log.Infof("resolve: %q  new address: %s", client.Host(), client)
+		}
+	}
+}
+
+// store queued items until the queue is closed
+func (sbsc *subscriber) work(queue *workQueue) {
+	defer sbsc.workers.Done()
+
+	for {
+		item, ok := queue.get()
+		if !ok {
+			return
+		}
+		sbsc.store(item)
+	}
+}
+
+// check the chain of a received message and process it
+func (sbsc *subscriber) receive(data [][]byte, node string) {
+	theChain := string(data[0])
+	if theChain != mode.ChainName() {
+		sbsc.log.Errorf("invalid chain: actual: %q  expect: %s", theChain, mode.ChainName())
+		return
+	}
+	sbsc.process(data[1:], node)
 }
 
 // process the received subscription
-func (sbsc *subscriber) process(data [][]byte, client *zmqutil.Client) {
+func (sbsc *subscriber) process(data [][]byte, node string) {
 
 	log := sbsc.log
 	log.Info("incoming message")
 
+	subscriberMessageCounter.With(string(data[0])).Inc()
+
 	switch string(data[0]) {
 	case "block":
 		log.Infof("received block: %x", data[1])
+		sbsc.blocks.put(queueItem{Topic: "block", Payload: data[1]})
+
+	case "assets":
+		log.Infof("received assets: %x", data[1])
+		sbsc.transactions.put(queueItem{Topic: "assets", Payload: data[1]})
+
+	case "issues":
+		log.Infof("received issues: %x", data[1])
+		sbsc.transactions.put(queueItem{Topic: "issues", Payload: data[1]})
+
+	case "transfer":
+		log.Infof("received transfer: %x", data[1])
+		sbsc.transactions.put(queueItem{Topic: "transfer", Payload: data[1]})
+
+	case "peer":
+		if len(data) >= 4 && sbsc.discovery.announce(data[1], data[2], data[3]) {
+			log.Infof("discovered peer: %x  listeners: %x", data[1], data[2])
+			sbsc.discover()
+		}
+
+	case "heart":
+		log.Infof("received heart: %x from node: %s", data[1], node)
+		if "" != node {
+			sbsc.heartbeatLock.Lock()
+			sbsc.heartbeats[node] = time.Now()
+			sbsc.heartbeatLock.Unlock()
+		}
+
+	}
+}
+
+// bring the discovered clients in line with the discovery pool
+// must only be called from the polling goroutine
+func (sbsc *subscriber) discover() {
+	if nil == sbsc.discovery {
+		return
+	}
+	log := sbsc.log
+
+	pool := sbsc.discovery.members()
+	wanted := make(map[string]announcement, len(pool))
+	for _, a := range pool {
+		wanted[hex.EncodeToString(a.publicKey)] = a
+	}
+
+	// remove clients that left the pool or moved
+	for key, client := range sbsc.discovered {
+		a, ok := wanted[key]
+		if ok && a.subscribe == client.Host() {
+			continue
+		}
+		log.Infof("remove discovered node: %s  at: %s", key, client)
+		client.Close()
+		delete(sbsc.discovered, key)
+		for i, c := range sbsc.clients {
+			if c == client {
+				sbsc.clients = append(sbsc.clients[:i], sbsc.clients[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for key, a := range wanted {
+		if _, ok := sbsc.discovered[key]; ok {
+			continue
+		}
+		client, err := zmqutil.NewClient(zmq.SUB, sbsc.privateKey, sbsc.publicKey, 0)
+		if nil != err {
+			log.Errorf("discovered node: %s  error: %s", key, err)
+			continue
+		}
+		client.Notify(sbsc.events)
+		client.BeginPolling(sbsc.poller, zmq.POLLIN)
+
+		err = client.ConnectHost(a.subscribe, a.publicKey, mode.ChainName())
+		if nil != err {
+			log.Errorf("discovered node: %s  at: %q  error: %s", key, a.subscribe, err)
+			client.Close()
+			continue
+		}
+		log.Infof("discovered node: %s  at: %q", key, a.subscribe)
+		sbsc.discovered[key] = client
+		sbsc.clients = append(sbsc.clients, client)
+	}
+}
+
+// store a queued item, called from the storage workers
+func (sbsc *subscriber) store(item queueItem) {
+
+	log := sbsc.log
+
+	switch item.Topic {
+	case "block":
 		if mode.Is(mode.Normal) {
-			err := storage.StoreBlock(data[1])
+			err := storage.StoreBlock(item.Payload)
 			if nil != err {
 				if err == fault.ErrPreviousBlockDigestDoesNotMatch {
 					mode.Set(mode.Resynchronise)
-					globalData.conn.state = cStateHighestBlock
+					globalData.conn.resynchronise()
 				}
 				log.Errorf("failed to store block: error: %s", err)
 			}
@@ -267,29 +463,46 @@ func (sbsc *subscriber) process(data [][]byte, client *zmqutil.Client) {
 			log.Warnf("failed block: error: %s", err)
 		}
 
-	case "assets":
-		log.Infof("received assets: %x", data[1])
-		err := storage.StoreTransactions(data[1])
+	case "assets", "issues", "transfer":
+		err := storage.StoreTransactions(item.Payload)
 		if nil != err {
-			log.Errorf("failed assets: error: %s", err)
+			log.Errorf("failed %s: error: %s", item.Topic, err)
+			deadLetter(log, item.Topic, item.Payload, err)
 		}
+	}
+}
 
-	case "issues":
-		log.Infof("received issues: %x", data[1])
-		err := storage.StoreTransactions(data[1])
-		if nil != err {
-			log.Errorf("failed issues: error: %s", err)
-		}
+// copy of the time of last heartbeat for each node
+func (sbsc *subscriber) lastHeartbeats() map[string]time.Time {
+	sbsc.heartbeatLock.Lock()
+	defer sbsc.heartbeatLock.Unlock()
 
-	case "transfer":
-		log.Infof("received transfer: %x", data[1])
-		err := storage.StoreTransactions(data[1])
-		if nil != err {
-			log.Errorf("failed transfer: error: %s", err)
-		}
+	heartbeats := make(map[string]time.Time, len(sbsc.heartbeats))
+	for node, t := range sbsc.heartbeats {
+		heartbeats[node] = t
+	}
+	return heartbeats
+}
 
-	case "heart":
-		log.Infof("received heart: %x from client: %s", data[1], client.BasicInfo())
+// seconds since the last heartbeat of each node for the metrics
+func (sbsc *subscriber) heartbeatAges() []metrics.Sample {
+	sbsc.heartbeatLock.Lock()
+	defer sbsc.heartbeatLock.Unlock()
+
+	samples := make([]metrics.Sample, 0, len(sbsc.heartbeats))
+	for node, t := range sbsc.heartbeats {
+		samples = append(samples, metrics.Sample{
+			Labels: []string{node},
+			Value:  time.Since(t).Seconds(),
+		})
+	}
+	return samples
+}
 
+// keep a payload that could not be stored for a later retry
+func deadLetter(log *logger.L, topic string, payload []byte, storeErr error) {
+	err := deadletter.Add(topic, payload, storeErr)
+	if nil != err {
+		log.Errorf("dead letter: %s  error: %s", topic, err)
 	}
 }
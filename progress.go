@@ -0,0 +1,49 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bitmark-inc/logger"
+)
+
+// interval between progress lines
+const progressInterval = 5 * time.Second
+
+// counts for the progress lines of the block file commands
+type progress struct {
+	log     *logger.L
+	action  string // what count is, e.g. "stored"
+	start   time.Time
+	last    time.Time
+	count   uint64
+	skipped uint64
+	height  uint64
+}
+
+func newProgress(log *logger.L, action string, height uint64) *progress {
+	return &progress{
+		log:    log,
+		action: action,
+		start:  time.Now(),
+		last:   time.Now(),
+		height: height,
+	}
+}
+
+// print a progress line at intervals, or now if final
+func (p *progress) report(final bool) {
+	now := time.Now()
+	if !final && now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+
+	rate := float64(p.count) / now.Sub(p.start).Seconds()
+	fmt.Printf("height: %d  %s: %d  skipped: %d  rate: %.1f blocks/s\n", p.height, p.action, p.count, p.skipped, rate)
+	p.log.Infof("height: %d  %s: %d  skipped: %d", p.height, p.action, p.count, p.skipped)
+}
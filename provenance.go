@@ -0,0 +1,164 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitmark-inc/logger"
+
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// output formats of the provenance command
+const (
+	provenanceJSON = "json"
+	provenanceCSV  = "csv"
+	provenanceDOT  = "dot"
+)
+
+// write the chain of custody of a bitmark or of every bitmark of an
+// asset to stdout
+func provenance(log *logger.L, id string, format string) error {
+
+	records, err := storage.Provenance(id)
+	if nil != err {
+		return err
+	}
+	if 0 == len(records) {
+		return fmt.Errorf("no bitmark or asset: %q", id)
+	}
+	log.Infof("provenance: %q  records: %d  format: %s", id, len(records), format)
+
+	switch format {
+	case provenanceJSON:
+		return provenanceToJSON(os.Stdout, records)
+	case provenanceCSV:
+		return provenanceToCSV(os.Stdout, records)
+	case provenanceDOT:
+		return provenanceToDOT(os.Stdout, id, records)
+	default:
+		return fmt.Errorf("unsupported format: %q", format)
+	}
+}
+
+func provenanceToJSON(w io.Writer, records []storage.ProvenanceRecord) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+func provenanceToCSV(w io.Writer, records []storage.ProvenanceRecord) error {
+	c := csv.NewWriter(w)
+	err := c.Write([]string{
+		"bitmark_id", "tx_id", "previous_id", "kind", "state", "owner",
+		"countersignature", "block_number", "block_offset", "block_time", "expires_at", "head", "depth",
+	})
+	if nil != err {
+		return err
+	}
+	for _, r := range records {
+		err := c.Write([]string{
+			r.BitmarkId,
+			r.TxId,
+			r.PreviousId,
+			r.Kind,
+			r.State,
+			r.Owner,
+			r.Countersignature,
+			strconv.FormatInt(r.BlockNumber, 10),
+			strconv.FormatInt(r.BlockOffset, 10),
+			formatTime(r.BlockTime),
+			formatTime(r.ExpiresAt),
+			r.Head,
+			strconv.FormatInt(r.Depth, 10),
+		})
+		if nil != err {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// one cluster per bitmark, an edge from each record to the next;
+// pending records are dashed and expired ones dotted and grey
+func provenanceToDOT(w io.Writer, id string, records []storage.ProvenanceRecord) error {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "digraph provenance {\n")
+	fmt.Fprintf(b, "  label=%q;\n", "provenance: "+id)
+	fmt.Fprintf(b, "  rankdir=LR;\n")
+	fmt.Fprintf(b, "  node [shape=box, fontname=\"monospace\"];\n")
+
+	cluster := ""
+	for i, r := range records {
+		if r.BitmarkId != cluster {
+			if 0 != i {
+				fmt.Fprintf(b, "  }\n")
+			}
+			cluster = r.BitmarkId
+			fmt.Fprintf(b, "  subgraph %q {\n", "cluster_"+r.BitmarkId)
+			fmt.Fprintf(b, "    label=%q;\n", "bitmark: "+shortId(r.BitmarkId))
+		}
+
+		label := fmt.Sprintf("%s %s\nowner: %s", r.Kind, shortId(r.TxId), shortId(r.Owner))
+		if storage.ProvenanceConfirmed == r.State {
+			label += fmt.Sprintf("\nblock: %d  %s", r.BlockNumber, formatTime(r.BlockTime))
+		} else {
+			label += "\n" + r.State
+		}
+		if "" != r.Countersignature {
+			label += "\ncountersigned"
+		}
+		fmt.Fprintf(b, "    %q [label=%q%s];\n", r.TxId, label, dotStyle(r.State))
+
+		if "" != r.PreviousId {
+			fmt.Fprintf(b, "    %q -> %q [%s];\n", r.PreviousId, r.TxId, strings.TrimPrefix(dotStyle(r.State), ", "))
+		}
+	}
+	if "" != cluster {
+		fmt.Fprintf(b, "  }\n")
+	}
+	fmt.Fprintf(b, "}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// attributes that keep pending and expired branches apart
+func dotStyle(state string) string {
+	switch state {
+	case storage.ProvenancePending:
+		return ", style=dashed, color=blue"
+	case storage.ProvenanceExpired:
+		return ", style=dotted, color=grey, fontcolor=grey"
+	default:
+		return ", style=solid"
+	}
+}
+
+// enough of a long id to recognise it in a graph
+func shortId(id string) string {
+	if len(id) <= 16 {
+		return id
+	}
+	return id[:8] + "…" + id[len(id)-8:]
+}
+
+// RFC 3339 or blank
+func formatTime(t *time.Time) string {
+	if nil == t {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/bitmark-inc/logger"
+
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// recompute every share balance from its increments and decrements,
+// print those that differ from the summation rows and optionally
+// correct them; fails if any differed and were not repaired
+func reconcileShares(log *logger.L, repair bool) error {
+
+	differences, err := storage.ReconcileShares(repair)
+	if nil != err {
+		return err
+	}
+
+	for _, d := range differences {
+		actual := "none"
+		if nil != d.Actual {
+			actual = fmt.Sprintf("%d", *d.Actual)
+		}
+		fmt.Printf("share: %s  owner: %s  expected: %d  summation: %s\n", d.ShareId, d.Owner, d.Expected, actual)
+		log.Warnf("share: %s  owner: %s  expected: %d  summation: %s  repaired: %t", d.ShareId, d.Owner, d.Expected, actual, repair)
+	}
+
+	if repair {
+		fmt.Printf("share balances repaired: %d\n", len(differences))
+		return nil
+	}
+	fmt.Printf("share balances differing: %d\n", len(differences))
+	if 0 != len(differences) {
+		return fmt.Errorf("%d share balances differ", len(differences))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/logger"
+
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// rebuild the tables from block "from" upwards using the packed
+// blocks kept by raw_blocks, without any node
+//
+// the local blocks from "from" are deleted, without recording a
+// reorganisation, and stored again from the kept data rewriting their
+// asset, transaction and share records, following the chain of
+// previous digests as far as the kept data goes; "from" may be one above the local height to carry
+// on after an interrupted reindex
+func reindexBlocks(log *logger.L, from uint64) error {
+
+	if !storage.RawBlocksEnabled() {
+		fmt.Printf("warning: raw_blocks is not set, the reindexed blocks will not be kept again\n")
+		log.Warn("reindex: raw_blocks is not set")
+	}
+
+	height, err := storage.GetBlockHeight()
+	if nil != err {
+		return err
+	}
+	if from <= genesis.BlockNumber || from > height+1 {
+		return fmt.Errorf("invalid start: %d  local height: %d", from, height)
+	}
+
+	// everything to be deleted must be available again
+	if from <= height {
+		missing, err := storage.CountMissingRawBlocks(from, height)
+		if nil != err {
+			return err
+		}
+		if 0 != missing {
+			return fmt.Errorf("%d blocks from: %d to: %d are not kept, they must be fetched from the network", missing, from, height)
+		}
+
+		log.Warnf("reindex: delete blocks: %d to %d", from, height)
+		err = storage.RevertDownToBlock(from)
+		if nil != err {
+			return err
+		}
+	}
+
+	previous, err := storage.DigestForBlock(from - 1)
+	if nil != err {
+		return err
+	}
+
+	progress := newProgress(log, "stored", from-1)
+	for n := from; ; n += 1 {
+		blocks, err := storage.GetRawBlocks(n)
+		if nil != err {
+			return err
+		}
+
+		// the kept block that follows the chain
+		packed := []byte(nil)
+		for _, b := range blocks {
+			header, _, _, err := blockrecord.ExtractHeader(b, 0)
+			if nil == err && header.Number == n && header.PreviousBlock == *previous {
+				packed = b
+				break
+			}
+		}
+		if nil == packed {
+			break
+		}
+
+		err = storage.RebuildBlock(packed)
+		if nil != err {
+			return fmt.Errorf("store block: %d  error: %s", n, err)
+		}
+		previous, err = storage.DigestForBlock(n)
+		if nil != err {
+			return err
+		}
+		progress.count += 1
+		progress.height = n
+		progress.report(false)
+	}
+	progress.report(true)
+
+	if progress.height < height {
+		fmt.Printf("warning: height: %d  is below the previous height: %d\n", progress.height, height)
+		log.Warnf("reindex: height: %d  previous height: %d", progress.height, height)
+	}
+	return nil
+}
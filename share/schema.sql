@@ -201,6 +201,27 @@ DROP INDEX IF EXISTS unique_share_id_owner_summation;
 CREATE UNIQUE INDEX unique_share_id_owner_summation ON SHARE (share_id, share_owner) WHERE share_type = 'summation' ;
 
 
+-- pending transfers received before their previous transaction
+DROP TABLE IF EXISTS orphan;
+
+CREATE TABLE orphan (
+  orphan_tx_id TEXT PRIMARY KEY NOT NULL,
+  orphan_parent_id TEXT NOT NULL,
+  orphan_packed BYTEA NOT NULL,
+  orphan_pay_id TEXT NOT NULL,
+  orphan_created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  orphan_expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- for retry
+DROP INDEX IF EXISTS orphan_parent_id_index;
+CREATE INDEX orphan_parent_id_index ON orphan(orphan_parent_id);
+
+-- for fast expiry
+DROP INDEX IF EXISTS orphan_expires_at_index;
+CREATE INDEX orphan_expires_at_index ON orphan(orphan_expires_at);
+
+
 -- events
 DROP TABLE IF EXISTS event;
 
@@ -214,6 +235,76 @@ CREATE TABLE event (
   notified BOOLEAN DEFAULT FALSE
 );
 
+
+-- synchronisation status of updaterd, always a single row
+DROP TABLE IF EXISTS sync_status;
+
+CREATE TABLE sync_status (
+  ID BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (ID),
+  sync_state TEXT NOT NULL DEFAULT 'Connecting',
+  sync_mode TEXT NOT NULL DEFAULT '',
+  sync_synchronised BOOLEAN NOT NULL DEFAULT FALSE,
+  sync_local_height INT8 NOT NULL DEFAULT 0,
+  sync_remote_height INT8 NOT NULL DEFAULT 0,
+  sync_node TEXT NOT NULL DEFAULT '',
+  sync_last_block_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+  sync_last_error TEXT NOT NULL DEFAULT '',
+  sync_updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO sync_status (ID) VALUES (TRUE);
+
+
+-- audit of every rewind of the local chain
+DROP TABLE IF EXISTS reorg;
+
+CREATE TABLE reorg (
+  reorg_id SERIAL PRIMARY KEY,
+  reorg_reason TEXT NOT NULL DEFAULT '',
+  reorg_old_tip INT8 NOT NULL,                  -- local height before the rewind
+  reorg_old_tip_hash TEXT,
+  reorg_new_tip INT8 DEFAULT NULL,              -- height of the replacing chain, if known
+  reorg_fork_point INT8 NOT NULL,               -- last block kept
+  reorg_removed_digests TEXT[] NOT NULL,        -- in ascending block order
+  reorg_reverted_tx_ids TEXT[] NOT NULL,        -- transactions returned to pending
+  reorg_created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+
+-- packed blocks kept for reindex, only written if raw_blocks is set
+-- not linked to block so the data survives the blocks being deleted
+DROP TABLE IF EXISTS block_data;
+
+CREATE TABLE block_data (
+  block_data_number INT8 NOT NULL,
+  block_data_hash TEXT NOT NULL,
+  block_data_packed BYTEA NOT NULL,
+  block_data_created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (block_data_number, block_data_hash)
+);
+
+
+-- ownership intervals of the confirmed records of each bitmark
+-- the owner holds the bitmark from the block of the record up to, but
+-- not including, the block of the next record: [from, to)
+-- maintained by update_ownership and reverted by delete_down_to_block
+DROP TABLE IF EXISTS ownership;
+
+CREATE TABLE ownership (
+  ownership_tx_id TEXT PRIMARY KEY NOT NULL REFERENCES TRANSACTION(tx_id) ON DELETE CASCADE,
+  ownership_bitmark_id TEXT NOT NULL,
+  ownership_owner TEXT NOT NULL,
+  ownership_from INT8 NOT NULL REFERENCES block(block_number) ON DELETE CASCADE,
+  ownership_offset INT8 NOT NULL DEFAULT 0,     -- of the record in its block
+  ownership_to INT8 DEFAULT NULL                -- NULL => still the owner
+);
+
+DROP INDEX IF EXISTS ownership_bitmark_index;
+CREATE INDEX ownership_bitmark_index ON ownership(ownership_bitmark_id, ownership_from, ownership_offset);
+
+DROP INDEX IF EXISTS ownership_owner_index;
+CREATE INDEX ownership_owner_index ON ownership(ownership_owner, ownership_from);
+
 -- functions
 -- ---------
 
@@ -400,6 +491,64 @@ END;
 $$ LANGUAGE plpgsql;
 
 
+-- keep a transfer until its previous transaction arrives
+-- a repeated broadcast extends the expiry time
+
+DROP FUNCTION IF EXISTS park_orphan(TEXT, TEXT, BYTEA, TEXT);
+
+CREATE FUNCTION park_orphan(_tx_id TEXT, _parent_id TEXT, _packed BYTEA, _pay_id TEXT) RETURNS VOID AS $$
+BEGIN
+  INSERT INTO orphan (orphan_tx_id, orphan_parent_id, orphan_packed, orphan_pay_id, orphan_expires_at)
+         VALUES (_tx_id, _parent_id, _packed, _pay_id, expires_at())
+         ON CONFLICT (orphan_tx_id) DO UPDATE
+         SET orphan_expires_at = expires_at();
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- the orphans whose previous transaction is now stored
+-- each is deleted by delete_orphan in the same transaction as its insert
+
+DROP FUNCTION IF EXISTS take_adoptable_orphans();
+DROP FUNCTION IF EXISTS adoptable_orphans();
+
+CREATE FUNCTION adoptable_orphans() RETURNS TABLE (_tx_id TEXT, _packed BYTEA, _pay_id TEXT) AS $$
+BEGIN
+  RETURN QUERY
+  SELECT orphan_tx_id, orphan_packed, orphan_pay_id
+    FROM orphan
+    WHERE EXISTS (SELECT 1 FROM TRANSACTION WHERE tx_id = orphan_parent_id)
+    ORDER BY orphan_created_at;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- remove an orphan that has been stored
+
+DROP FUNCTION IF EXISTS delete_orphan(TEXT);
+
+CREATE FUNCTION delete_orphan(_tx_id TEXT) RETURNS VOID AS $$
+BEGIN
+  DELETE FROM orphan WHERE orphan_tx_id = _tx_id;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- delete orphans whose parent never arrived
+
+DROP FUNCTION IF EXISTS expire_orphans();
+
+CREATE FUNCTION expire_orphans() RETURNS INT8 AS $$
+DECLARE
+  _local_count INT8;
+BEGIN
+  DELETE FROM orphan WHERE orphan_expires_at < now();
+  GET DIAGNOSTICS _local_count = ROW_COUNT;
+  RETURN _local_count;
+END;
+$$ LANGUAGE plpgsql;
+
+
 -- get the digest of a specific block
 
 DROP FUNCTION IF EXISTS get_block_digest(INT8);
@@ -422,14 +571,43 @@ $$ LANGUAGE plpgsql;
 -- remove blocks before fork recovery
 
 DROP FUNCTION IF EXISTS delete_down_to_block(INT8);
+DROP FUNCTION IF EXISTS delete_down_to_block(INT8, INT8, TEXT);
+
+CREATE FUNCTION delete_down_to_block(_low_block_number INT8, _new_tip INT8 DEFAULT NULL, _reason TEXT DEFAULT '') RETURNS VOID AS $$
+BEGIN
+  -- must be recorded before the transactions are moved
+  IF get_block_height() >= _low_block_number THEN
+    PERFORM record_reorg(_low_block_number, _new_tip, _reason);
+  END IF;
+
+  PERFORM revert_down_to_block(_low_block_number);
+END;
+$$ LANGUAGE plpgsql;
 
-CREATE FUNCTION delete_down_to_block(_low_block_number INT8) RETURNS VOID AS $$
+
+-- remove blocks without recording a reorg, the blocks are stored
+-- again by reindex
+
+DROP FUNCTION IF EXISTS revert_down_to_block(INT8);
+
+CREATE FUNCTION revert_down_to_block(_low_block_number INT8) RETURNS VOID AS $$
 DECLARE
   _share_row RECORD;
   _share_multiplier INTEGER;
   _local_time_now TIMESTAMP WITH TIME ZONE := now();
   _local_expires_at TIMESTAMP WITH TIME ZONE := expires_at();
+  _edition_owners TEXT[];
+  _edition_assets TEXT[];
 BEGIN
+  -- owner/asset pairs that lose issues, their editions are renumbered
+  SELECT COALESCE(array_agg(p.tx_owner), '{}'), COALESCE(array_agg(p.tx_asset_id), '{}')
+    INTO _edition_owners, _edition_assets
+    FROM (SELECT DISTINCT tx_owner, tx_asset_id
+            FROM TRANSACTION
+            WHERE tx_previous_id IS NULL
+              AND tx_asset_id IS NOT NULL
+              AND tx_block_number >= _low_block_number) p;
+
   FOR _block_number IN REVERSE get_block_height() .. _low_block_number LOOP
 
     UPDATE TRANSACTION SET tx_head = 'head'
@@ -470,14 +648,54 @@ BEGIN
           share_expires_at = _local_expires_at
       WHERE share_block_number = _block_number;
 
+    -- intervals started by the block go, those it ended are open again
+    DELETE FROM ownership
+      WHERE ownership_from = _block_number;
+    UPDATE ownership
+      SET ownership_to = NULL
+      WHERE ownership_to = _block_number;
+
     DELETE FROM block
       WHERE block_number = _block_number;
   END LOOP;
+
+  FOR _i IN 1 .. COALESCE(array_length(_edition_owners, 1), 0) LOOP
+    PERFORM recompute_editions(_edition_owners[_i], _edition_assets[_i]);
+  END LOOP;
 END;
 $$ LANGUAGE plpgsql;
 
 
 
+-- record the blocks and transactions about to be removed
+
+DROP FUNCTION IF EXISTS record_reorg(INT8, INT8, TEXT);
+
+CREATE FUNCTION record_reorg(_low_block_number INT8, _new_tip INT8, _reason TEXT) RETURNS VOID AS $$
+DECLARE
+  _local_old_tip INT8 := get_block_height();
+  _reorg_id INT;
+BEGIN
+  INSERT INTO reorg (reorg_reason, reorg_old_tip, reorg_old_tip_hash, reorg_new_tip, reorg_fork_point,
+                     reorg_removed_digests, reorg_reverted_tx_ids)
+    VALUES (_reason,
+            _local_old_tip,
+            get_block_digest(_local_old_tip),
+            NULLIF(_new_tip, 0),
+            _low_block_number - 1,
+            ARRAY(SELECT block_hash FROM block
+                    WHERE block_number >= _low_block_number
+                    ORDER BY block_number),
+            ARRAY(SELECT tx_id FROM TRANSACTION
+                    WHERE tx_block_number >= _low_block_number
+                    ORDER BY tx_block_number, tx_block_offset))
+    RETURNING reorg_id INTO _reorg_id;
+
+  PERFORM notify_chain_reorg(_reorg_id::TEXT);
+END;
+$$ LANGUAGE plpgsql;
+
+
 -- insert a block
 
 DROP FUNCTION IF EXISTS insert_block(INT8, TEXT, TIMESTAMP WITH TIME ZONE);
@@ -513,8 +731,55 @@ END;
 $$ LANGUAGE plpgsql;
 
 
+-- keep a packed block, dropping any other block kept at the same height
+
+DROP FUNCTION IF EXISTS insert_block_data(INT8, TEXT, BYTEA);
+
+CREATE FUNCTION insert_block_data(_block_number INT8, _hash TEXT, _packed BYTEA) RETURNS VOID AS $$
+BEGIN
+  DELETE FROM block_data
+    WHERE block_data_number = _block_number AND block_data_hash <> _hash;
+  INSERT INTO block_data (block_data_number, block_data_hash, block_data_packed)
+         VALUES (_block_number, _hash, _packed)
+         ON CONFLICT (block_data_number, block_data_hash) DO NOTHING;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- number of stored blocks in a range that have no kept packed block
+
+DROP FUNCTION IF EXISTS count_missing_block_data(INT8, INT8);
+
+CREATE FUNCTION count_missing_block_data(_low_block_number INT8, _high_block_number INT8) RETURNS INT8 AS $$
+DECLARE
+  _local_count INT8;
+BEGIN
+  SELECT count(*) INTO _local_count
+    FROM block
+    LEFT JOIN block_data ON block_data_number = block_number AND block_data_hash = block_hash
+    WHERE block_number BETWEEN _low_block_number AND _high_block_number
+      AND block_data_number IS NULL;
+  RETURN _local_count;
+END;
+$$ LANGUAGE plpgsql;
+
+
 -- insert an asset
 
+-- true while reindex stores the kept blocks again, the insert
+-- functions then rewrite every column of an existing record
+-- set for one database transaction by:
+--   SELECT set_config('blockchain.rebuild', 'on', true)
+
+DROP FUNCTION IF EXISTS rebuilding();
+
+CREATE FUNCTION rebuilding() RETURNS BOOLEAN AS $$
+BEGIN
+  RETURN COALESCE(current_setting('blockchain.rebuild', true), '') = 'on';
+END;
+$$ LANGUAGE plpgsql;
+
+
 DROP FUNCTION IF EXISTS insert_asset(TEXT, TEXT, TEXT, JSONB, TEXT, TEXT, status_type, INT8, INT8);
 
 CREATE FUNCTION insert_asset(_asset_id TEXT,
@@ -558,6 +823,22 @@ BEGIN
         -- do nothing, just loop to try the UPDATE again
       END;
 
+    ELSIF rebuilding() THEN
+      -- reindex: rewrite the record from the block
+      UPDATE asset
+        SET asset_name = _name,
+            asset_fingerprint = _fingerprint,
+            asset_metadata = _metadata,
+            asset_registrant = _registrant,
+            asset_signature = _signature,
+            asset_status = _status,
+            asset_sequence = nextval('asset_seq'),
+            asset_block_number = _block_number,
+            asset_block_offset = _block_offset,
+            asset_expires_at = _local_expires_at
+        WHERE asset_id = _asset_id;
+      EXIT;
+
     ELSE
       -- already exists
       IF _local_block_number <= 0 AND (_status <> _local_status OR _block_number <> _local_block_number) THEN
@@ -654,6 +935,35 @@ BEGIN
         -- do nothing, and loop to try the UPDATE again
       END;
 
+    ELSIF rebuilding() THEN
+      -- reindex: rewrite the record from the block
+      IF _previous_id IS NOT NULL THEN
+        SELECT tx_bitmark_id, tx_asset_id INTO _local_bitmark_id, _asset_id
+          FROM TRANSACTION
+          WHERE tx_id = _previous_id LIMIT 1;
+      ELSE
+        _local_bitmark_id := _tx_id;
+      END IF;
+      UPDATE TRANSACTION
+        SET tx_owner = _owner,
+            tx_signature = _signature,
+            tx_countersignature = _countersignature,
+            tx_asset_id = _asset_id,
+            tx_bitmark_id = _local_bitmark_id,
+            tx_previous_id = _previous_id,
+            tx_head = _local_head,
+            tx_status = _status,
+            tx_sequence = nextval('tx_seq'),
+            tx_block_number = _block_number,
+            tx_block_offset = _block_offset,
+            tx_payments = _payments,
+            tx_pay_id = _pay_id,
+            tx_expires_at = _local_expires_at,
+            tx_modified_at = now()
+        WHERE tx_id = _tx_id;
+      _local_previous_id := _previous_id;
+      EXIT;
+
     ELSE
       -- already exists, update
       IF _local_block_number <= 0 AND (_status <> _local_status OR _block_number <> _local_block_number) THEN
@@ -731,24 +1041,47 @@ BEGIN
     _local_updated = TRUE;
   EXCEPTION
     WHEN unique_violation THEN
-      -- update the transaction status only when the tx_status if confirmed
-      UPDATE TRANSACTION
-          SET tx_status = _status,
-              tx_head = _local_head,
-              tx_block_number = _block_number,
-              tx_block_offset = _block_offset,
-              tx_pay_id = _pay_id,
-              tx_expires_at = _local_expires_at
-          WHERE tx_id = _tx_id AND tx_status <> 'confirmed';
-      -- update share status if the transaction has updated
-      IF FOUND THEN
-        UPDATE SHARE
-          SET share_status = _status,
-              share_block_number = _block_number,
-              share_modified_at = _local_time_now,
-              share_expires_at = _local_expires_at
-          WHERE share_tx_id = _tx_id;
+      IF rebuilding() THEN
+        -- reindex: rewrite the record and its share records from the block
+        UPDATE TRANSACTION
+            SET tx_owner = _local_owner,
+                tx_signature = _signature,
+                tx_asset_id = _local_asset_id,
+                tx_bitmark_id = _local_bitmark_id,
+                tx_previous_id = _previous_id,
+                tx_head = _local_head,
+                tx_status = _status,
+                tx_block_number = _block_number,
+                tx_block_offset = _block_offset,
+                tx_shares_info = jsonb_build_object('new', _local_owner, 'share_id', _local_bitmark_id, 'quantity', _quantity),
+                tx_pay_id = _pay_id,
+                tx_expires_at = _local_expires_at,
+                tx_modified_at = _local_time_now
+            WHERE tx_id = _tx_id;
+        DELETE FROM SHARE WHERE share_tx_id = _tx_id;
+        INSERT INTO SHARE (share_id, share_owner, share_quantity, share_status, share_tx_id, share_block_number, share_type, share_modified_at, share_expires_at)
+               VALUES (_local_bitmark_id, _local_owner, _quantity, _status, _tx_id, _block_number, 'increment', _local_time_now, _local_expires_at);
         _local_updated = TRUE;
+      ELSE
+        -- update the transaction status only when the tx_status if confirmed
+        UPDATE TRANSACTION
+            SET tx_status = _status,
+                tx_head = _local_head,
+                tx_block_number = _block_number,
+                tx_block_offset = _block_offset,
+                tx_pay_id = _pay_id,
+                tx_expires_at = _local_expires_at
+            WHERE tx_id = _tx_id AND tx_status <> 'confirmed';
+        -- update share status if the transaction has updated
+        IF FOUND THEN
+          UPDATE SHARE
+            SET share_status = _status,
+                share_block_number = _block_number,
+                share_modified_at = _local_time_now,
+                share_expires_at = _local_expires_at
+            WHERE share_tx_id = _tx_id;
+          _local_updated = TRUE;
+        END IF;
       END IF;
     WHEN OTHERS THEN
       RAISE;
@@ -816,24 +1149,47 @@ BEGIN
     _local_updated = TRUE;
   EXCEPTION
     WHEN unique_violation THEN
-      -- update the transaction status only when the tx_status if confirmed
-      UPDATE TRANSACTION
-          SET tx_status = _status,
-              tx_head = _local_head,
-              tx_block_number = _block_number,
-              tx_block_offset = _block_offset,
-              tx_pay_id = _pay_id,
-              tx_expires_at = _local_expires_at
-          WHERE tx_id = _tx_id AND tx_status <> 'confirmed';
-      -- update share status if the transaction has updated
-      IF FOUND THEN
-        UPDATE SHARE
-          SET share_status = _status,
-              share_block_number = _block_number,
-              share_modified_at = _local_time_now,
-              share_expires_at = _local_expires_at
-          WHERE share_tx_id = _tx_id;
+      IF rebuilding() THEN
+        -- reindex: rewrite the record and its share records from the block
+        UPDATE TRANSACTION
+            SET tx_owner = _owner,
+                tx_signature = _signature,
+                tx_countersignature = _countersignature,
+                tx_head = _local_head,
+                tx_status = _status,
+                tx_block_number = _block_number,
+                tx_block_offset = _block_offset,
+                tx_pay_id = _pay_id,
+                tx_shares_info = _shares,
+                tx_expires_at = _local_expires_at,
+                tx_modified_at = _local_time_now
+            WHERE tx_id = _tx_id;
+        DELETE FROM SHARE WHERE share_tx_id = _tx_id;
+        INSERT INTO SHARE (share_id, share_owner, share_quantity, share_status, share_tx_id, share_block_number, share_type, share_modified_at, share_expires_at)
+               VALUES (_share_id, _recipient, _quantity, _status, _tx_id, _block_number, 'increment', _local_time_now, _local_expires_at);
+        INSERT INTO SHARE (share_id, share_owner, share_quantity, share_status, share_tx_id, share_block_number, share_type, share_modified_at, share_expires_at)
+               VALUES (_share_id, _owner, _quantity, _status, _tx_id, _block_number, 'decrement', _local_time_now, _local_expires_at);
         _local_updated = TRUE;
+      ELSE
+        -- update the transaction status only when the tx_status if confirmed
+        UPDATE TRANSACTION
+            SET tx_status = _status,
+                tx_head = _local_head,
+                tx_block_number = _block_number,
+                tx_block_offset = _block_offset,
+                tx_pay_id = _pay_id,
+                tx_expires_at = _local_expires_at
+            WHERE tx_id = _tx_id AND tx_status <> 'confirmed';
+        -- update share status if the transaction has updated
+        IF FOUND THEN
+          UPDATE SHARE
+            SET share_status = _status,
+                share_block_number = _block_number,
+                share_modified_at = _local_time_now,
+                share_expires_at = _local_expires_at
+            WHERE share_tx_id = _tx_id;
+          _local_updated = TRUE;
+        END IF;
       END IF;
     WHEN OTHERS THEN
       RAISE;
@@ -897,24 +1253,51 @@ BEGIN
     _local_updated = TRUE;
   EXCEPTION
     WHEN unique_violation THEN
-      -- update the transaction status only when the tx_status if confirmed
-      UPDATE TRANSACTION
-          SET tx_status = _status,
-              tx_head = _local_head,
-              tx_block_number = _block_number,
-              tx_block_offset = _block_offset,
-              tx_pay_id = _pay_id,
-              tx_expires_at = _local_expires_at
-          WHERE tx_id = _tx_id AND tx_status <> 'confirmed';
-      -- update share status if the transaction has updated
-      IF FOUND THEN
-        UPDATE SHARE
-          SET share_status = _status,
-              share_block_number = _block_number,
-              share_modified_at = _local_time_now,
-              share_expires_at = _local_expires_at
-          WHERE share_tx_id = _tx_id;
+      IF rebuilding() THEN
+        -- reindex: rewrite the record and its share records from the block
+        UPDATE TRANSACTION
+            SET tx_owner = _owner_one,
+                tx_signature = _signature,
+                tx_countersignature = _countersignature,
+                tx_head = _local_head,
+                tx_status = _status,
+                tx_block_number = _block_number,
+                tx_block_offset = _block_offset,
+                tx_pay_id = _pay_id,
+                tx_shares_info = _swaps,
+                tx_expires_at = _local_expires_at,
+                tx_modified_at = _local_time_now
+            WHERE tx_id = _tx_id;
+        DELETE FROM SHARE WHERE share_tx_id = _tx_id;
+        INSERT INTO SHARE (share_id, share_owner, share_quantity, share_status, share_tx_id, share_block_number, share_type, share_modified_at, share_expires_at)
+               VALUES (_share_one, _owner_two, _quantity_one, _status, _tx_id, _block_number, 'increment', _local_time_now, _local_expires_at);
+        INSERT INTO SHARE (share_id, share_owner, share_quantity, share_status, share_tx_id, share_block_number, share_type, share_modified_at, share_expires_at)
+               VALUES (_share_one, _owner_one, _quantity_one, _status, _tx_id, _block_number, 'decrement', _local_time_now, _local_expires_at);
+        INSERT INTO SHARE (share_id, share_owner, share_quantity, share_status, share_tx_id, share_block_number, share_type, share_modified_at, share_expires_at)
+               VALUES (_share_two, _owner_one, _quantity_two, _status, _tx_id, _block_number, 'increment', _local_time_now, _local_expires_at);
+        INSERT INTO SHARE (share_id, share_owner, share_quantity, share_status, share_tx_id, share_block_number, share_type, share_modified_at, share_expires_at)
+               VALUES (_share_two, _owner_two, _quantity_two, _status, _tx_id, _block_number, 'decrement', _local_time_now, _local_expires_at);
         _local_updated = TRUE;
+      ELSE
+        -- update the transaction status only when the tx_status if confirmed
+        UPDATE TRANSACTION
+            SET tx_status = _status,
+                tx_head = _local_head,
+                tx_block_number = _block_number,
+                tx_block_offset = _block_offset,
+                tx_pay_id = _pay_id,
+                tx_expires_at = _local_expires_at
+            WHERE tx_id = _tx_id AND tx_status <> 'confirmed';
+        -- update share status if the transaction has updated
+        IF FOUND THEN
+          UPDATE SHARE
+            SET share_status = _status,
+                share_block_number = _block_number,
+                share_modified_at = _local_time_now,
+                share_expires_at = _local_expires_at
+            WHERE share_tx_id = _tx_id;
+          _local_updated = TRUE;
+        END IF;
       END IF;
     WHEN OTHERS THEN
       RAISE;
@@ -950,6 +1333,69 @@ END;
 $$ LANGUAGE plpgsql;
 
 
+-- share balances: the summation row of each share and owner must equal
+-- the confirmed increments less the confirmed decrements, records of
+-- reverted blocks (block number -1) are not counted
+-- a missing summation row is returned with a NULL actual
+
+DROP FUNCTION IF EXISTS share_balance_differences();
+
+CREATE FUNCTION share_balance_differences()
+                RETURNS TABLE(_share_id TEXT, _owner TEXT, _expected INT8, _actual INT8) AS $$
+BEGIN
+  RETURN QUERY
+    WITH history AS (
+      SELECT h.share_id, h.share_owner,
+             SUM(CASE WHEN h.share_type = 'increment' THEN h.share_quantity ELSE -h.share_quantity END)::INT8 AS expected
+        FROM SHARE h
+        WHERE h.share_type <> 'summation' AND h.share_block_number > 0
+        GROUP BY h.share_id, h.share_owner
+    ), summation AS (
+      SELECT s.share_id, s.share_owner, s.share_quantity::INT8 AS actual
+        FROM SHARE s
+        WHERE s.share_type = 'summation'
+    )
+    SELECT COALESCE(history.share_id, summation.share_id),
+           COALESCE(history.share_owner, summation.share_owner),
+           COALESCE(history.expected, 0),
+           summation.actual
+      FROM history
+      FULL OUTER JOIN summation ON summation.share_id = history.share_id AND summation.share_owner = history.share_owner
+      WHERE COALESCE(summation.actual, 0) <> COALESCE(history.expected, 0)
+      ORDER BY 1, 2;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- set each differing summation row to the recomputed balance
+-- returns the rows that were changed, with the values before the repair
+
+DROP FUNCTION IF EXISTS repair_share_balances();
+
+CREATE FUNCTION repair_share_balances()
+                RETURNS TABLE(_share_id TEXT, _owner TEXT, _expected INT8, _actual INT8) AS $$
+DECLARE
+  _row RECORD;
+BEGIN
+  -- no share transactions while the balances are recomputed
+  LOCK TABLE SHARE IN SHARE ROW EXCLUSIVE MODE;
+
+  FOR _row IN SELECT * FROM share_balance_differences() LOOP
+    INSERT INTO SHARE (share_id, share_owner, share_quantity, share_status, share_type, share_modified_at)
+           VALUES (_row._share_id, _row._owner, _row._expected, 'confirmed', 'summation', now())
+           ON CONFLICT (share_id, share_owner) WHERE share_type = 'summation' DO UPDATE
+           SET share_quantity = EXCLUDED.share_quantity,
+               share_modified_at = now();
+    _share_id := _row._share_id;
+    _owner := _row._owner;
+    _expected := _row._expected;
+    _actual := _row._actual;
+    RETURN NEXT;
+  END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+
 -- notifications
 
 DROP FUNCTION IF EXISTS notify_new_block(TEXT);
@@ -979,6 +1425,33 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
+DROP FUNCTION IF EXISTS notify_chain_reorg(TEXT);
+CREATE FUNCTION notify_chain_reorg(_reorg_id TEXT) RETURNS VOID AS $$
+DECLARE
+  _local_expires_at TIMESTAMP WITH TIME ZONE := expires_at() + INTERVAL '2 days';
+  _event_id INT;
+  _record RECORD;
+BEGIN
+  SELECT * INTO _record FROM event WHERE NAME = 'chain_reorg' AND VALUE = _reorg_id FOR UPDATE;
+  IF NOT FOUND THEN
+    -- try to insert a reorg event
+    BEGIN
+      INSERT INTO event (NAME, VALUE, expires_at)
+                  VALUES ('chain_reorg', _reorg_id, _local_expires_at)
+                  RETURNING event.ID INTO _event_id;
+      PERFORM pg_notify('chain_reorg', _event_id::TEXT);
+    EXCEPTION WHEN unique_violation THEN
+    END;
+  ELSE
+    UPDATE event SET expires_at = _local_expires_at, notified = false, updated_at = now()
+    WHERE NAME = 'chain_reorg' AND VALUE = _reorg_id
+    RETURNING event.ID INTO _event_id;
+
+    PERFORM pg_notify('chain_reorg', _event_id::TEXT);
+  END IF;
+END;
+$$ LANGUAGE plpgsql;
+
 DROP FUNCTION IF EXISTS notify_new_assets(TEXT);
 CREATE FUNCTION notify_new_assets(_asset_ids TEXT) RETURNS VOID AS $$
 DECLARE
@@ -1076,6 +1549,41 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
+-- record the synchronisation status
+-- notifies 'sync_state' with the new state name when the state changes
+
+DROP FUNCTION IF EXISTS update_sync_status(TEXT, TEXT, BOOLEAN, INT8, TEXT, TEXT);
+
+CREATE FUNCTION update_sync_status(_state TEXT, _mode TEXT, _synchronised BOOLEAN, _remote_height INT8, _node TEXT, _last_error TEXT) RETURNS VOID AS $$
+DECLARE
+  _local_height INT8 := get_block_height();
+  _local_last_block_at TIMESTAMP WITH TIME ZONE;
+  _previous_state TEXT;
+BEGIN
+  SELECT block_created_at INTO _local_last_block_at
+    FROM block
+    WHERE block_number = _local_height;
+
+  SELECT sync_state INTO _previous_state FROM sync_status FOR UPDATE;
+
+  UPDATE sync_status
+    SET sync_state = _state,
+        sync_mode = _mode,
+        sync_synchronised = _synchronised,
+        sync_local_height = _local_height,
+        sync_remote_height = _remote_height,
+        sync_node = _node,
+        sync_last_block_at = _local_last_block_at,
+        sync_last_error = _last_error,
+        sync_updated_at = now();
+
+  IF _previous_state IS DISTINCT FROM _state THEN
+    PERFORM pg_notify('sync_state', _state);
+  END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+
 -- queries
 
 -- query assets
@@ -1211,6 +1719,271 @@ END;
 $$ LANGUAGE plpgsql;
 
 
+-- number the confirmed issues of an owner's asset from zero in block
+-- order, issues that are not in a block have no edition
+-- returns the number of records changed
+
+DROP FUNCTION IF EXISTS recompute_editions(TEXT, TEXT);
+
+CREATE FUNCTION recompute_editions(_owner TEXT, _asset_id TEXT) RETURNS INT8 AS $$
+DECLARE
+  _local_cleared INT8;
+  _local_changed INT8;
+BEGIN
+  UPDATE blockchain.TRANSACTION
+    SET tx_edition = NULL
+    WHERE tx_owner = _owner
+      AND tx_asset_id = _asset_id
+      AND tx_previous_id IS NULL
+      AND tx_block_number <= 0
+      AND tx_edition IS NOT NULL;
+  GET DIAGNOSTICS _local_cleared = ROW_COUNT;
+
+  WITH numbered AS (
+    SELECT tx_id, ROW_NUMBER() OVER (ORDER BY tx_block_number, tx_block_offset) - 1 AS edition
+      FROM blockchain.TRANSACTION
+      WHERE tx_owner = _owner
+        AND tx_asset_id = _asset_id
+        AND tx_previous_id IS NULL
+        AND tx_block_number > 0
+  )
+  UPDATE blockchain.TRANSACTION t
+    SET tx_edition = numbered.edition
+    FROM numbered
+    WHERE t.tx_id = numbered.tx_id
+      AND t.tx_edition IS DISTINCT FROM numbered.edition;
+  GET DIAGNOSTICS _local_changed = ROW_COUNT;
+
+  RETURN _local_cleared + _local_changed;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- issues whose edition is not the one recomputed from block order
+-- _expected is NULL for an issue that is not in a block
+
+DROP FUNCTION IF EXISTS edition_problems();
+
+CREATE FUNCTION edition_problems()
+                RETURNS TABLE(_owner TEXT, _asset_id TEXT, _tx_id TEXT, _block_number INT8, _edition INT8, _expected INT8) AS $$
+BEGIN
+  RETURN QUERY
+    SELECT e.tx_owner, e.tx_asset_id, e.tx_id, e.tx_block_number, e.tx_edition, e.expected
+      FROM (SELECT t.tx_owner, t.tx_asset_id, t.tx_id, t.tx_block_number, t.tx_block_offset, t.tx_edition,
+                   CASE WHEN t.tx_block_number > 0 THEN
+                     ROW_NUMBER() OVER (PARTITION BY t.tx_owner, t.tx_asset_id, t.tx_block_number > 0
+                                        ORDER BY t.tx_block_number, t.tx_block_offset) - 1
+                   END AS expected
+              FROM blockchain.TRANSACTION t
+              WHERE t.tx_previous_id IS NULL
+                AND t.tx_asset_id IS NOT NULL) e
+      WHERE e.tx_edition IS DISTINCT FROM e.expected
+      ORDER BY e.tx_owner, e.tx_asset_id, e.tx_block_number, e.tx_block_offset;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- renumber the editions of every owner's asset with a problem
+-- returns the number of records changed
+
+DROP FUNCTION IF EXISTS repair_editions();
+
+CREATE FUNCTION repair_editions() RETURNS INT8 AS $$
+DECLARE
+  _local_pair RECORD;
+  _local_changed INT8 := 0;
+BEGIN
+  FOR _local_pair IN SELECT DISTINCT _owner, _asset_id FROM edition_problems() LOOP
+    _local_changed := _local_changed + recompute_editions(_local_pair._owner, _local_pair._asset_id);
+  END LOOP;
+  RETURN _local_changed;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- ownership intervals for the confirmed records of a stored block, in
+-- block order so that several records of one bitmark in the block
+-- chain correctly
+
+DROP FUNCTION IF EXISTS update_ownership(INT8);
+
+CREATE FUNCTION update_ownership(_block_number INT8) RETURNS VOID AS $$
+DECLARE
+  _local_tx RECORD;
+BEGIN
+  FOR _local_tx IN
+    SELECT tx_id, tx_bitmark_id, tx_owner, tx_block_offset FROM blockchain.TRANSACTION
+    WHERE tx_block_number = _block_number
+      AND tx_bitmark_id IS NOT NULL
+      AND tx_status = 'confirmed'
+    ORDER BY tx_block_offset, tx_sequence
+  LOOP
+    UPDATE blockchain.ownership
+      SET ownership_to = _block_number
+      WHERE ownership_bitmark_id = _local_tx.tx_bitmark_id
+        AND ownership_to IS NULL
+        AND ownership_tx_id <> _local_tx.tx_id;
+    INSERT INTO blockchain.ownership (ownership_tx_id, ownership_bitmark_id, ownership_owner,
+                                      ownership_from, ownership_offset)
+           VALUES (_local_tx.tx_id, _local_tx.tx_bitmark_id, _local_tx.tx_owner,
+                   _block_number, _local_tx.tx_block_offset)
+           ON CONFLICT (ownership_tx_id) DO NOTHING;
+  END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- recreate all ownership intervals from the confirmed records
+-- returns the number of intervals
+
+DROP FUNCTION IF EXISTS rebuild_ownership();
+
+CREATE FUNCTION rebuild_ownership() RETURNS INT8 AS $$
+DECLARE
+  _local_count INT8;
+BEGIN
+  LOCK TABLE blockchain.ownership IN EXCLUSIVE MODE;
+  DELETE FROM blockchain.ownership;
+
+  INSERT INTO blockchain.ownership (ownership_tx_id, ownership_bitmark_id, ownership_owner,
+                                    ownership_from, ownership_offset, ownership_to)
+    SELECT tx_id, tx_bitmark_id, tx_owner, tx_block_number, tx_block_offset,
+           LEAD(tx_block_number) OVER (PARTITION BY tx_bitmark_id ORDER BY tx_block_number, tx_block_offset, tx_sequence)
+      FROM blockchain.TRANSACTION
+      WHERE tx_block_number > 0
+        AND tx_bitmark_id IS NOT NULL
+        AND tx_status = 'confirmed';
+  GET DIAGNOSTICS _local_count = ROW_COUNT;
+
+  RETURN _local_count;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- the owner of a bitmark once block _block_number was applied
+-- no row if the bitmark was not issued by then
+
+DROP FUNCTION IF EXISTS owner_at_block(TEXT, INT8);
+
+CREATE FUNCTION owner_at_block(_bitmark_id TEXT, _block_number INT8)
+                RETURNS TABLE(_tx_id TEXT, _owner TEXT, _from INT8, _to INT8) AS $$
+BEGIN
+  RETURN QUERY
+    SELECT o.ownership_tx_id, o.ownership_owner, o.ownership_from, o.ownership_to
+      FROM blockchain.ownership o
+      WHERE o.ownership_bitmark_id = _bitmark_id
+        AND o.ownership_from <= _block_number
+        AND (o.ownership_to IS NULL OR o.ownership_to > _block_number);
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- the bitmarks held by an account once block _block_number was applied
+
+DROP FUNCTION IF EXISTS holdings_at_block(TEXT, INT8);
+
+CREATE FUNCTION holdings_at_block(_owner TEXT, _block_number INT8)
+                RETURNS TABLE(_bitmark_id TEXT, _tx_id TEXT, _from INT8, _to INT8) AS $$
+BEGIN
+  RETURN QUERY
+    SELECT o.ownership_bitmark_id, o.ownership_tx_id, o.ownership_from, o.ownership_to
+      FROM blockchain.ownership o
+      WHERE o.ownership_owner = _owner
+        AND o.ownership_from <= _block_number
+        AND (o.ownership_to IS NULL OR o.ownership_to > _block_number)
+      ORDER BY o.ownership_from, o.ownership_offset;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- all ownership intervals of a bitmark, oldest first
+
+DROP FUNCTION IF EXISTS ownership_history(TEXT);
+
+CREATE FUNCTION ownership_history(_bitmark_id TEXT)
+                RETURNS TABLE(_tx_id TEXT, _owner TEXT, _from INT8, _to INT8) AS $$
+BEGIN
+  RETURN QUERY
+    SELECT o.ownership_tx_id, o.ownership_owner, o.ownership_from, o.ownership_to
+      FROM blockchain.ownership o
+      WHERE o.ownership_bitmark_id = _bitmark_id
+      ORDER BY o.ownership_from, o.ownership_offset;
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- the highest stored block created at or before a time, zero if none
+
+DROP FUNCTION IF EXISTS block_at_time(TIMESTAMP WITH TIME ZONE);
+
+CREATE FUNCTION block_at_time(_at TIMESTAMP WITH TIME ZONE) RETURNS INT8 AS $$
+DECLARE
+  _local_block_number INT8;
+BEGIN
+  SELECT MAX(block_number) INTO _local_block_number
+    FROM blockchain.block
+    WHERE block_number > 0
+      AND block_created_at <= _at;
+  RETURN COALESCE(_local_block_number, 0);
+END;
+$$ LANGUAGE plpgsql;
+
+
+-- chain of custody: the issue records of a bitmark id, or of every
+-- bitmark of an asset id, and all records that follow them through
+-- tx_previous_id, pending branches included
+-- _state: 'confirmed' in a block, 'pending' awaiting a block, or
+-- 'expired' for pending records that expired or were reverted by a
+-- reorg and never confirmed again (block -1)
+-- ordered by bitmark issue, then depth along the chain
+
+DROP FUNCTION IF EXISTS provenance(TEXT);
+
+CREATE FUNCTION provenance(_id TEXT)
+                RETURNS TABLE(_tx_id TEXT, _bitmark_id TEXT, _asset_id TEXT, _previous_id TEXT,
+                              _kind TEXT, _state TEXT, _owner TEXT,
+                              _signature TEXT, _countersignature TEXT,
+                              _block_number INT8, _block_offset INT8, _block_created_at TIMESTAMP WITH TIME ZONE,
+                              _expires_at TIMESTAMP WITH TIME ZONE, _head head_type, _depth INT8) AS $$
+BEGIN
+  RETURN QUERY
+    WITH RECURSIVE chain AS (
+      SELECT t.tx_id, t.tx_sequence AS root_sequence, 0::INT8 AS depth
+        FROM blockchain.TRANSACTION t
+        WHERE t.tx_previous_id IS NULL
+          AND (t.tx_id = _id OR t.tx_asset_id = _id)
+      UNION ALL
+      SELECT t.tx_id, c.root_sequence, c.depth + 1
+        FROM blockchain.TRANSACTION t
+        JOIN chain c ON t.tx_previous_id = c.tx_id
+    )
+    SELECT t.tx_id, t.tx_bitmark_id, t.tx_asset_id, t.tx_previous_id,
+           CASE
+             WHEN t.tx_previous_id IS NULL AND t.tx_asset_id IS NULL THEN 'foundation'
+             WHEN t.tx_previous_id IS NULL THEN 'issue'
+             WHEN t.tx_shares_info IS NOT NULL THEN 'share'
+             ELSE 'transfer'
+           END,
+           -- a reverted record (block number -1) keeps the short expiry time
+           -- and is pending until it expires, expire_records gives an
+           -- expired record the long expiry time
+           CASE
+             WHEN t.tx_status = 'confirmed' THEN 'confirmed'
+             WHEN t.tx_expires_at <= now() THEN 'expired'
+             WHEN t.tx_block_number < 0
+               AND t.tx_expires_at - t.tx_modified_at > expires_at() - now() THEN 'expired'
+             ELSE 'pending'
+           END,
+           t.tx_owner, t.tx_signature, t.tx_countersignature,
+           t.tx_block_number, t.tx_block_offset, b.block_created_at,
+           t.tx_expires_at, t.tx_head, c.depth
+      FROM chain c
+      JOIN blockchain.TRANSACTION t ON t.tx_id = c.tx_id
+      LEFT JOIN blockchain.block b ON b.block_number = t.tx_block_number AND t.tx_block_number > 0
+      ORDER BY c.root_sequence, c.depth, t.tx_sequence;
+END;
+$$ LANGUAGE plpgsql;
+
 
 -- finished
 SET search_path TO DEFAULT;
@@ -0,0 +1,98 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+const (
+	// bitmarkHeads:
+	// returns a row for each bitmark without exactly one head record,
+	// records of reverted blocks and expired records (block number -1) are not counted
+	//   1:  bitmark_id     TEXT
+	//   2:  heads          INT8
+	bitmarkHeadsSQL = `SELECT tx_bitmark_id, COUNT(*) FILTER (WHERE tx_head = 'head')
+                             FROM blockchain.transaction
+                             WHERE tx_bitmark_id IS NOT NULL
+                               AND tx_block_number >= 0
+                             GROUP BY tx_bitmark_id
+                             HAVING COUNT(*) FILTER (WHERE tx_head = 'head') <> 1
+                             ORDER BY tx_bitmark_id;`
+
+	// headSuccessors:
+	// returns a row for each head record that is the previous record of
+	// another, neither may be in a reverted block or expired (block number -1)
+	//   1:  bitmark_id     TEXT
+	//   2:  tx_id          TEXT
+	//   3:  successor      TEXT
+	headSuccessorsSQL = `SELECT h.tx_bitmark_id, h.tx_id, s.tx_id
+                               FROM blockchain.transaction h
+                               JOIN blockchain.transaction s ON s.tx_previous_id = h.tx_id
+                                                           AND s.tx_block_number >= 0
+                               WHERE h.tx_head = 'head'
+                                 AND h.tx_block_number >= 0
+                               ORDER BY h.tx_bitmark_id, h.tx_id;`
+)
+
+// HeadProblem - a bitmark whose head/prior chain is inconsistent
+// either the number of head records is not one (TxId and Successor
+// empty), or a head record has a successor (Heads is 1)
+//
+// records with block number -1, from a reverted block or expired,
+// are ignored: a reverted record is 'moved' until it is stored again
+// and is not the successor of its previous record
+type HeadProblem struct {
+	BitmarkId string `json:"bitmark_id"`
+	Heads     int    `json:"heads"`
+	TxId      string `json:"tx_id,omitempty"`
+	Successor string `json:"successor,omitempty"`
+}
+
+// CheckHeads - every bitmark must have exactly one head record and
+// that record must be the last of its chain
+func CheckHeads() ([]HeadProblem, error) {
+	if nil == globalData.database {
+		return nil, fault.ErrNotInitialised
+	}
+
+	problems := []HeadProblem{}
+
+	rows, err := globalData.database.Query(bitmarkHeadsSQL)
+	if nil != err {
+		return nil, err
+	}
+	for rows.Next() {
+		p := HeadProblem{}
+		err := rows.Scan(&p.BitmarkId, &p.Heads)
+		if nil != err {
+			rows.Close()
+			return nil, err
+		}
+		problems = append(problems, p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if nil != err {
+		return nil, err
+	}
+
+	rows, err = globalData.database.Query(headSuccessorsSQL)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := HeadProblem{
+			Heads: 1,
+		}
+		err := rows.Scan(&p.BitmarkId, &p.TxId, &p.Successor)
+		if nil != err {
+			return nil, err
+		}
+		problems = append(problems, p)
+	}
+	return problems, rows.Err()
+}
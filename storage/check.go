@@ -0,0 +1,43 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+)
+
+// CheckBlock - validate a packed block without storing it
+// the header must unpack, every transaction must unpack and the
+// merkle root of the transaction ids must match the header
+func CheckBlock(packedBlock []byte) (*blockrecord.Header, blockdigest.Digest, error) {
+
+	header, digest, data, err := blockrecord.ExtractHeader(packedBlock, 0)
+	if nil != err {
+		return nil, digest, err
+	}
+
+	testnet := mode.IsTesting()
+	txIds := make([]merkle.Digest, header.TransactionCount)
+	for i := uint16(0); i < header.TransactionCount; i += 1 {
+		_, n, err := transactionrecord.Packed(data).Unpack(testnet)
+		if nil != err {
+			return nil, digest, err
+		}
+		txIds[i] = merkle.NewDigest(data[:n])
+		data = data[n:]
+	}
+
+	fullMerkleTree := merkle.FullMerkleTree(txIds)
+	if fullMerkleTree[len(fullMerkleTree)-1] != header.MerkleRoot {
+		return nil, digest, fault.ErrMerkleRootDoesNotMatch
+	}
+
+	return header, digest, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+// editions number the issues of each owner's asset from zero in block
+// order; update_editions assigns them as blocks are stored and
+// delete_down_to_block renumbers the owner/asset pairs of the issues
+// it removes
+
+const (
+	// editionProblems returns a row for each issue with a wrong edition:
+	//   1:  owner          TEXT
+	//   2:  asset_id       TEXT
+	//   3:  tx_id          TEXT
+	//   4:  block_number   INT8
+	//   5:  edition        INT8  (NULL if none)
+	//   6:  expected       INT8  (NULL if not in a block)
+	editionProblemsSQL = `SELECT * FROM blockchain.edition_problems();`
+
+	// repairEditions returns:
+	//   1:  changed        INT8
+	repairEditionsSQL = `SELECT blockchain.repair_editions();`
+)
+
+// EditionProblem - an issue whose edition does not follow block order
+type EditionProblem struct {
+	Owner       string `json:"owner"`
+	AssetId     string `json:"asset_id"`
+	TxId        string `json:"tx_id"`
+	BlockNumber int64  `json:"block_number"` // -1 => reverted
+	Edition     *int64 `json:"edition"`      // nil => none
+	Expected    *int64 `json:"expected"`     // nil => should have none
+}
+
+// CheckEditions - all issues whose edition is not the recomputed one
+func CheckEditions() ([]EditionProblem, error) {
+	if nil == globalData.database {
+		return nil, fault.ErrNotInitialised
+	}
+
+	rows, err := globalData.database.Query(editionProblemsSQL)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []EditionProblem{}
+	for rows.Next() {
+		p := EditionProblem{}
+		var edition, expected sql.NullInt64
+		err := rows.Scan(&p.Owner, &p.AssetId, &p.TxId, &p.BlockNumber, &edition, &expected)
+		if nil != err {
+			return nil, err
+		}
+		if edition.Valid {
+			p.Edition = &edition.Int64
+		}
+		if expected.Valid {
+			p.Expected = &expected.Int64
+		}
+		problems = append(problems, p)
+	}
+	return problems, rows.Err()
+}
+
+// RepairEditions - renumber every owner/asset pair with a problem
+// returns the number of records changed
+func RepairEditions() (int64, error) {
+	if nil == globalData.database {
+		return 0, fault.ErrNotInitialised
+	}
+
+	var changed int64
+	err := globalData.database.QueryRow(repairEditionsSQL).Scan(&changed)
+	return changed, err
+}
@@ -51,11 +51,28 @@ loop:
 			break loop
 
 		case <-time.After(expiryInterval):
-			log.Info("removing any expired records")
-			err := deleteExpiredRecords(exp.database)
+			err := exp.expire()
 			if nil != err {
 				log.Errorf("delete error: %s", err)
 			}
 		}
 	}
 }
+
+// remove expired records and time the operation
+func (exp *expiry) expire() error {
+	exp.log.Info("removing any expired records")
+	start := time.Now()
+	err := deleteExpiredRecords(exp.database)
+	expiryDuration.ObserveDuration(start)
+	if nil != err {
+		return err
+	}
+
+	n, err := expireOrphans(exp.database)
+	if nil != err {
+		return err
+	}
+	exp.log.Infof("orphans: expired: %d  remaining: %d", n, recountOrphans())
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"github.com/bitmark-inc/updaterd/metrics"
+)
+
+// buckets for the number of blocks removed by a rewind
+var reorgDepthBuckets = []float64{1, 2, 3, 5, 10, 20, 50, 100, 500, 1000}
+
+// storage metrics
+var (
+	localHeightGauge = metrics.NewGauge(
+		"updaterd_local_block_height",
+		"highest block number stored in the database",
+	)
+	blocksStoredCounter = metrics.NewCounter(
+		"updaterd_blocks_stored_total",
+		"number of blocks stored",
+	)
+	transactionsStoredCounter = metrics.NewCounterVec(
+		"updaterd_transactions_stored_total",
+		"number of transactions stored by transaction type and status",
+		"type", "status",
+	)
+	storeBlockDuration = metrics.NewHistogram(
+		"updaterd_store_block_duration_seconds",
+		"time taken to validate and store a block",
+		metrics.DefaultBuckets,
+	)
+	reorgCounter = metrics.NewCounter(
+		"updaterd_reorg_total",
+		"number of times stored blocks were removed to follow a fork",
+	)
+	reorgDepth = metrics.NewHistogram(
+		"updaterd_reorg_depth_blocks",
+		"number of blocks removed by each rewind",
+		reorgDepthBuckets,
+	)
+	expiryDuration = metrics.NewHistogram(
+		"updaterd_expiry_duration_seconds",
+		"time taken by each run of the record expiry",
+		metrics.DefaultBuckets,
+	)
+	reconcileDuration = metrics.NewHistogram(
+		"updaterd_share_reconcile_duration_seconds",
+		"time taken by each share balance reconciliation",
+		metrics.DefaultBuckets,
+	)
+	shareDifferencesGauge = metrics.NewGauge(
+		"updaterd_share_balance_differences",
+		"share balances that differed from their history at the last reconciliation",
+	)
+	sharesRepairedCounter = metrics.NewCounter(
+		"updaterd_share_balances_repaired_total",
+		"number of share summation rows corrected by reconciliation",
+	)
+)
+
+// to count transactions by type before the database commit
+type txTally map[string]uint64
+
+// add the counts to the metrics once the data is committed
+func (t txTally) commit(status statusType) {
+	for kind, n := range t {
+		transactionsStoredCounter.With(kind, status.String()).Add(n)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+	"github.com/bitmark-inc/logger"
+)
+
+// transfers whose previous transaction is not yet stored are kept
+// packed in the orphan table until the parent arrives or they expire
+
+const (
+	// transactionExists:
+	//   1:  tx_id          TEXT
+	// returns:
+	//   1:  exists         BOOLEAN
+	transactionExistsSQL = `SELECT EXISTS (SELECT 1 FROM blockchain.transaction WHERE tx_id = $1);`
+
+	// parkOrphan:
+	//   1:  tx_id          TEXT
+	//   2:  parent_id      TEXT
+	//   3:  packed         BYTEA
+	//   4:  pay_id         TEXT
+	parkOrphanSQL = `SELECT blockchain.park_orphan($1, $2, $3, $4);`
+
+	// adoptableOrphans:
+	// returns the orphans whose parent is stored
+	//   1:  tx_id          TEXT
+	//   2:  packed         BYTEA
+	//   3:  pay_id         TEXT
+	adoptableOrphansSQL = `SELECT * FROM blockchain.adoptable_orphans();`
+
+	// deleteOrphan:
+	//   1:  tx_id          TEXT
+	deleteOrphanSQL = `SELECT blockchain.delete_orphan($1);`
+
+	// expireOrphans:
+	// returns:
+	//   1:  count          INT8
+	expireOrphansSQL = `SELECT blockchain.expire_orphans();`
+
+	// countOrphans:
+	// returns:
+	//   1:  count          INT8
+	countOrphansSQL = `SELECT count(*) FROM blockchain.orphan;`
+)
+
+// only one adoption runs at a time so that concurrent stores cannot
+// adopt the same orphan twice, the count of parked orphans lets the
+// adoption skip its query when there are none
+var (
+	adoption    sync.Mutex
+	orphanCount int64 = -1 // -1 => unknown, adoption always runs
+)
+
+// record orphans parked by a committed database transaction
+func orphansParked(n int) {
+	if n > 0 {
+		atomic.AddInt64(&orphanCount, int64(n))
+	}
+}
+
+// an orphan ready to be stored again
+type orphan struct {
+	txId   string
+	packed []byte
+	payId  string
+}
+
+// true if the parent of the transfer is already stored
+// i.e. the transfer can be inserted now
+func parentExists(transfer transactionrecord.BitmarkTransfer, db *sql.Tx) (bool, error) {
+	parentId, err := transfer.GetLink().MarshalText()
+	if nil != err {
+		return false, err
+	}
+
+	exists := false
+	err = db.QueryRow(transactionExistsSQL, string(parentId)).Scan(&exists)
+	return exists, err
+}
+
+// keep a transfer until its parent is stored
+func parkOrphan(txId merkle.Digest, transfer transactionrecord.BitmarkTransfer, packed []byte, payId string, db *sql.Tx, log *logger.L) error {
+	id, err := txId.MarshalText()
+	if nil != err {
+		return err
+	}
+	parentId, err := transfer.GetLink().MarshalText()
+	if nil != err {
+		return err
+	}
+
+	_, err = db.Exec(parkOrphanSQL, string(id), string(parentId), packed, payId)
+	if nil != err {
+		log.Errorf("parkOrphanSQL: id: %s  parent: %s  error: %s", id, parentId, err)
+		return err
+	}
+	log.Infof("parked orphan: id: %s  missing parent: %s", id, parentId)
+
+	return nil
+}
+
+// remove an orphan within the transaction that stores it
+func deleteOrphan(txId string, db *sql.Tx, log *logger.L) error {
+	_, err := db.Exec(deleteOrphanSQL, txId)
+	if nil != err {
+		log.Errorf("deleteOrphanSQL: id: %s  error: %s", txId, err)
+	}
+	return err
+}
+
+// store any orphans whose parent has arrived
+// storing one may allow another so repeat until none are left
+//
+// an orphan is only removed when it is stored, one that fails stays
+// parked until a later retry or its expiry
+func retryOrphans(log *logger.L) {
+
+	adoption.Lock()
+	defer adoption.Unlock()
+
+	if 0 == atomic.LoadInt64(&orphanCount) {
+		return
+	}
+
+	stored := 0
+	failed := make(map[string]struct{})
+	for {
+		orphans, err := adoptableOrphans()
+		if nil != err {
+			log.Errorf("retry orphans error: %s", err)
+			return
+		}
+
+		tried := 0
+		for _, o := range orphans {
+			if _, ok := failed[o.txId]; ok {
+				continue
+			}
+			tried += 1
+
+			log.Infof("retry orphan: id: %s", o.txId)
+			err := storeTransactions(o.packed, o.payId, o.txId)
+			if nil != err {
+				log.Errorf("retry orphan: id: %s  error: %s", o.txId, err)
+				failed[o.txId] = struct{}{}
+				continue
+			}
+			stored += 1
+		}
+		if 0 == tried {
+			break
+		}
+	}
+
+	remaining := recountOrphans()
+
+	if stored > 0 || len(failed) > 0 {
+		log.Infof("orphans: stored: %d  failed: %d  remaining: %d", stored, len(failed), remaining)
+	}
+}
+
+// the orphans whose parents are stored, oldest first
+func adoptableOrphans() ([]orphan, error) {
+	rows, err := globalData.database.Query(adoptableOrphansSQL)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orphans := make([]orphan, 0)
+	for rows.Next() {
+		var o orphan
+		err := rows.Scan(&o.txId, &o.packed, &o.payId)
+		if nil != err {
+			return nil, err
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, rows.Err()
+}
+
+// delete orphans that have waited too long, returns the number deleted
+func expireOrphans(db *sql.DB) (int64, error) {
+	n := int64(0)
+	err := db.QueryRow(expireOrphansSQL).Scan(&n)
+	return n, err
+}
+
+// current number of orphans, kept for the next adoption
+func recountOrphans() int64 {
+	n := countOrphans()
+	atomic.StoreInt64(&orphanCount, n)
+	return n
+}
+
+// current number of orphans, -1 on error
+func countOrphans() int64 {
+	n := int64(0)
+	err := globalData.database.QueryRow(countOrphansSQL).Scan(&n)
+	if nil != err {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// the ownership table holds, for each confirmed record of a bitmark,
+// the blocks during which its owner held the bitmark; StoreBlock adds
+// the intervals of each block and delete_down_to_block reverts them
+
+const (
+	// updateOwnership:
+	//   1:  block_number   INT8
+	updateOwnershipSQL = `SELECT blockchain.update_ownership($1);`
+
+	// rebuildOwnership returns:
+	//   1:  count          INT8
+	rebuildOwnershipSQL = `SELECT blockchain.rebuild_ownership();`
+
+	// ownerAtBlock:
+	//   1:  bitmark_id     TEXT
+	//   2:  block_number   INT8
+	// returns at most one row:
+	//   1:  tx_id          TEXT
+	//   2:  owner          TEXT
+	//   3:  from           INT8
+	//   4:  to             INT8  (NULL if still owned)
+	ownerAtBlockSQL = `SELECT * FROM blockchain.owner_at_block($1, $2);`
+
+	// holdingsAtBlock:
+	//   1:  owner          TEXT
+	//   2:  block_number   INT8
+	// returns a row for each bitmark held:
+	//   1:  bitmark_id     TEXT
+	//   2:  tx_id          TEXT
+	//   3:  from           INT8
+	//   4:  to             INT8  (NULL if still owned)
+	holdingsAtBlockSQL = `SELECT * FROM blockchain.holdings_at_block($1, $2);`
+
+	// ownershipHistory:
+	//   1:  bitmark_id     TEXT
+	// returns a row for each interval, oldest first:
+	//   1:  tx_id          TEXT
+	//   2:  owner          TEXT
+	//   3:  from           INT8
+	//   4:  to             INT8  (NULL if still owned)
+	ownershipHistorySQL = `SELECT * FROM blockchain.ownership_history($1);`
+
+	// blockAtTime:
+	//   1:  at             TIMESTAMP WITH TIME ZONE
+	// returns:
+	//   1:  block_number   INT8  (zero if none)
+	blockAtTimeSQL = `SELECT blockchain.block_at_time($1);`
+)
+
+// OwnershipInterval - the owner of a bitmark from block From up to,
+// but not including, block To
+type OwnershipInterval struct {
+	BitmarkId string  `json:"bitmark_id"`
+	TxId      string  `json:"tx_id"` // record that gave ownership
+	Owner     string  `json:"owner"`
+	From      uint64  `json:"from"`
+	To        *uint64 `json:"to"` // nil => still the owner
+}
+
+// add the intervals for the records of the block being stored
+func updateOwnership(blockNumber uint64, db *sql.Tx, log *logger.L) error {
+	_, err := db.Exec(updateOwnershipSQL, blockNumber)
+	if err, ok := err.(*pq.Error); ok {
+		log.Errorf("updateOwnership: block: %d  error: %s", blockNumber, err)
+	}
+
+	return err
+}
+
+// RebuildOwnership - recreate all intervals from the confirmed records
+// returns the number of intervals
+func RebuildOwnership() (int64, error) {
+	if nil == globalData.database {
+		return 0, fault.ErrNotInitialised
+	}
+
+	var count int64
+	err := globalData.database.QueryRow(rebuildOwnershipSQL).Scan(&count)
+	return count, err
+}
+
+// OwnerAt - the ownership of a bitmark once the block was applied
+// nil if the bitmark did not exist then
+func OwnerAt(bitmarkId string, blockNumber uint64) (*OwnershipInterval, error) {
+	if nil == globalData.database {
+		return nil, fault.ErrNotInitialised
+	}
+
+	o := &OwnershipInterval{
+		BitmarkId: bitmarkId,
+	}
+	var to sql.NullInt64
+	err := globalData.database.QueryRow(ownerAtBlockSQL, bitmarkId, blockNumber).Scan(&o.TxId, &o.Owner, &o.From, &to)
+	if sql.ErrNoRows == err {
+		return nil, nil
+	} else if nil != err {
+		return nil, err
+	}
+	o.To = intervalEnd(to)
+	return o, nil
+}
+
+// HoldingsAt - the bitmarks held by an account once the block was applied
+func HoldingsAt(owner string, blockNumber uint64) ([]OwnershipInterval, error) {
+	if nil == globalData.database {
+		return nil, fault.ErrNotInitialised
+	}
+
+	rows, err := globalData.database.Query(holdingsAtBlockSQL, owner, blockNumber)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	intervals := []OwnershipInterval{}
+	for rows.Next() {
+		o := OwnershipInterval{
+			Owner: owner,
+		}
+		var to sql.NullInt64
+		err := rows.Scan(&o.BitmarkId, &o.TxId, &o.From, &to)
+		if nil != err {
+			return nil, err
+		}
+		o.To = intervalEnd(to)
+		intervals = append(intervals, o)
+	}
+	return intervals, rows.Err()
+}
+
+// OwnershipHistory - every interval of a bitmark, oldest first
+func OwnershipHistory(bitmarkId string) ([]OwnershipInterval, error) {
+	if nil == globalData.database {
+		return nil, fault.ErrNotInitialised
+	}
+
+	rows, err := globalData.database.Query(ownershipHistorySQL, bitmarkId)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	intervals := []OwnershipInterval{}
+	for rows.Next() {
+		o := OwnershipInterval{
+			BitmarkId: bitmarkId,
+		}
+		var to sql.NullInt64
+		err := rows.Scan(&o.TxId, &o.Owner, &o.From, &to)
+		if nil != err {
+			return nil, err
+		}
+		o.To = intervalEnd(to)
+		intervals = append(intervals, o)
+	}
+	return intervals, rows.Err()
+}
+
+// BlockAtTime - the highest stored block created at or before the
+// time, zero if none; for queries by date
+func BlockAtTime(at time.Time) (uint64, error) {
+	if nil == globalData.database {
+		return 0, fault.ErrNotInitialised
+	}
+
+	var blockNumber uint64
+	err := globalData.database.QueryRow(blockAtTimeSQL, at.UTC()).Scan(&blockNumber)
+	return blockNumber, err
+}
+
+// end of an interval, nil if open
+func intervalEnd(to sql.NullInt64) *uint64 {
+	if !to.Valid {
+		return nil
+	}
+	n := uint64(to.Int64)
+	return &n
+}
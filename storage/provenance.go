@@ -0,0 +1,87 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+const (
+	// provenance:
+	//   1:  id             TEXT  (bitmark id or asset id)
+	// returns a row for each record, see ProvenanceRecord
+	provenanceSQL = `SELECT * FROM blockchain.provenance($1);`
+)
+
+// states of a provenance record
+const (
+	ProvenanceConfirmed = "confirmed" // in a block
+	ProvenancePending   = "pending"   // waiting for a block, including one reverted by a reorganisation
+	ProvenanceExpired   = "expired"   // not in a block by its expiry time
+)
+
+// ProvenanceRecord - one record in the chain of custody of a bitmark
+type ProvenanceRecord struct {
+	TxId             string     `json:"tx_id"`
+	BitmarkId        string     `json:"bitmark_id"`
+	AssetId          string     `json:"asset_id,omitempty"`    // blank for block ownership
+	PreviousId       string     `json:"previous_id,omitempty"` // blank for an issue
+	Kind             string     `json:"kind"`                  // foundation, issue, transfer or share
+	State            string     `json:"state"`
+	Owner            string     `json:"owner"`
+	Signature        string     `json:"signature"`
+	Countersignature string     `json:"countersignature,omitempty"`
+	BlockNumber      int64      `json:"block_number"` // 0 => pending, -1 => reverted or expired, see State
+	BlockOffset      int64      `json:"block_offset"`
+	BlockTime        *time.Time `json:"block_time,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	Head             string     `json:"head"`  // head, prior or moved
+	Depth            int64      `json:"depth"` // records from the issue
+}
+
+// Provenance - every record of a bitmark, or of all bitmarks of an
+// asset, from each issue along tx_previous_id; pending and expired
+// branches are included and ordered after their parent
+func Provenance(id string) ([]ProvenanceRecord, error) {
+	if nil == globalData.database {
+		return nil, fault.ErrNotInitialised
+	}
+
+	rows, err := globalData.database.Query(provenanceSQL, id)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []ProvenanceRecord{}
+	for rows.Next() {
+		r := ProvenanceRecord{}
+		var bitmarkId, assetId, previousId, countersignature sql.NullString
+		var blockTime, expiresAt sql.NullTime
+		err := rows.Scan(&r.TxId, &bitmarkId, &assetId, &previousId,
+			&r.Kind, &r.State, &r.Owner,
+			&r.Signature, &countersignature,
+			&r.BlockNumber, &r.BlockOffset, &blockTime,
+			&expiresAt, &r.Head, &r.Depth)
+		if nil != err {
+			return nil, err
+		}
+		r.BitmarkId = bitmarkId.String
+		r.AssetId = assetId.String
+		r.PreviousId = previousId.String
+		r.Countersignature = countersignature.String
+		if blockTime.Valid {
+			r.BlockTime = &blockTime.Time
+		}
+		if expiresAt.Valid {
+			r.ExpiresAt = &expiresAt.Time
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
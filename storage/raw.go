@@ -0,0 +1,110 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// with raw_blocks set the packed data of each stored block is kept
+// in the block_data table, keyed by height and digest, so that the
+// other tables can be rebuilt without fetching the blocks again
+
+const (
+	// insertBlockData:
+	//   1:  block_number   INT8
+	//   2:  hash           TEXT
+	//   3:  packed         BYTEA
+	insertBlockDataSQL = `SELECT blockchain.insert_block_data($1, $2, $3);`
+
+	// getBlockData:
+	//   1:  block_number   INT8
+	//   2:  hash           TEXT
+	// returns:
+	//   1:  packed         BYTEA
+	getBlockDataSQL = `SELECT block_data_packed FROM blockchain.block_data WHERE block_data_number = $1 AND block_data_hash = $2;`
+
+	// getBlockDataAt:
+	//   1:  block_number   INT8
+	// returns a row for each digest kept at the height
+	//   1:  packed         BYTEA
+	getBlockDataAtSQL = `SELECT block_data_packed FROM blockchain.block_data WHERE block_data_number = $1;`
+
+	// countMissingBlockData:
+	//   1:  low            INT8
+	//   2:  high           INT8
+	// returns:
+	//   1:  count          INT8
+	countMissingBlockDataSQL = `SELECT blockchain.count_missing_block_data($1, $2);`
+)
+
+// RawBlocksEnabled - true if StoreBlock keeps the packed blocks
+func RawBlocksEnabled() bool {
+	return globalData.rawBlocks
+}
+
+// keep the packed block as part of the block transaction
+func insertBlockData(blockNumber uint64, digest blockdigest.Digest, packedBlock []byte, db *sql.Tx, log *logger.L) error {
+	_, err := db.Exec(insertBlockDataSQL, blockNumber, digest.String(), packedBlock)
+	if nil != err {
+		log.Errorf("insertBlockDataSQL: number: %d  error: %s", blockNumber, err)
+	}
+	return err
+}
+
+// GetRawBlock - the kept packed block with the digest, nil if not kept
+func GetRawBlock(blockNumber uint64, digest blockdigest.Digest) ([]byte, error) {
+	if nil == globalData.database {
+		return nil, fault.ErrNotInitialised
+	}
+
+	var packed []byte
+	err := globalData.database.QueryRow(getBlockDataSQL, blockNumber, digest.String()).Scan(&packed)
+	if sql.ErrNoRows == err {
+		return nil, nil
+	}
+	return packed, err
+}
+
+// GetRawBlocks - all kept packed blocks at a height
+// normally one, but a fork can leave others until the height is stored again
+func GetRawBlocks(blockNumber uint64) ([][]byte, error) {
+	if nil == globalData.database {
+		return nil, fault.ErrNotInitialised
+	}
+
+	rows, err := globalData.database.Query(getBlockDataAtSQL, blockNumber)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := [][]byte{}
+	for rows.Next() {
+		var packed []byte
+		err := rows.Scan(&packed)
+		if nil != err {
+			return nil, err
+		}
+		blocks = append(blocks, packed)
+	}
+	return blocks, rows.Err()
+}
+
+// CountMissingRawBlocks - stored blocks from low to high without
+// packed data for their digest
+func CountMissingRawBlocks(low uint64, high uint64) (uint64, error) {
+	if nil == globalData.database {
+		return 0, fault.ErrNotInitialised
+	}
+
+	var count uint64
+	err := globalData.database.QueryRow(countMissingBlockDataSQL, low, high).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/logger"
+)
+
+// the summation share rows are maintained incrementally by the insert
+// functions and by delete_down_to_block; reconciliation recomputes each
+// balance from the increment and decrement rows and compares
+
+const (
+	// shareBalanceDifferences returns a row for each differing balance:
+	//   1:  share_id       TEXT
+	//   2:  owner          TEXT
+	//   3:  expected       INT8
+	//   4:  actual         INT8  (NULL if there is no summation row)
+	shareBalanceDifferencesSQL = `SELECT * FROM blockchain.share_balance_differences();`
+
+	// repairShareBalances returns the same rows as they were before repair
+	repairShareBalancesSQL = `SELECT * FROM blockchain.repair_share_balances();`
+)
+
+// ShareDifference - a summation row that does not match the history
+type ShareDifference struct {
+	ShareId  string `json:"share_id"`
+	Owner    string `json:"owner"`
+	Expected int64  `json:"expected"`
+	Actual   *int64 `json:"actual"` // nil => no summation row
+}
+
+// ReconcileShares - the share balances that differ from their
+// increment and decrement history; if repair is set the summation rows
+// are corrected and the differences returned are those before repair
+func ReconcileShares(repair bool) ([]ShareDifference, error) {
+	if nil == globalData.database {
+		return nil, fault.ErrNotInitialised
+	}
+	return reconcileShares(globalData.database, repair)
+}
+
+func reconcileShares(db *sql.DB, repair bool) ([]ShareDifference, error) {
+
+	query := shareBalanceDifferencesSQL
+	if repair {
+		query = repairShareBalancesSQL
+	}
+
+	start := time.Now()
+	rows, err := db.Query(query)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	differences := []ShareDifference{}
+	for rows.Next() {
+		d := ShareDifference{}
+		var actual sql.NullInt64
+		err := rows.Scan(&d.ShareId, &d.Owner, &d.Expected, &actual)
+		if nil != err {
+			return nil, err
+		}
+		if actual.Valid {
+			d.Actual = &actual.Int64
+		}
+		differences = append(differences, d)
+	}
+	err = rows.Err()
+	if nil != err {
+		return nil, err
+	}
+
+	reconcileDuration.ObserveDuration(start)
+	if repair {
+		shareDifferencesGauge.Set(0)
+		sharesRepairedCounter.Add(uint64(len(differences)))
+	} else {
+		shareDifferencesGauge.Set(float64(len(differences)))
+	}
+	return differences, nil
+}
+
+// data for the background reconciliation
+type reconciler struct {
+	log      *logger.L
+	database *sql.DB
+	interval time.Duration
+	repair   bool
+}
+
+// initialise the reconciliation, interval 0 => not run
+func (rec *reconciler) initialise(database *sql.DB, interval time.Duration, repair bool) error {
+
+	log := logger.New("reconcile")
+	rec.log = log
+
+	log.Info("initialising…")
+
+	rec.database = database
+	rec.interval = interval
+	rec.repair = repair
+
+	return nil
+}
+
+// background for the share reconciliation
+func (rec *reconciler) Run(args interface{}, shutdown <-chan struct{}) {
+
+	if 0 == rec.interval {
+		<-shutdown
+		return
+	}
+
+	log := rec.log
+
+	log.Infof("starting…  interval: %s  repair: %t", rec.interval, rec.repair)
+
+loop:
+	for {
+		select {
+		case <-shutdown:
+			break loop
+
+		case <-time.After(rec.interval):
+			differences, err := reconcileShares(rec.database, rec.repair)
+			if nil != err {
+				log.Errorf("reconcile error: %s", err)
+				continue loop
+			}
+			for _, d := range differences {
+				actual := "none"
+				if nil != d.Actual {
+					actual = fmt.Sprintf("%d", *d.Actual)
+				}
+				log.Warnf("share: %s  owner: %s  expected: %d  summation: %s  repaired: %t", d.ShareId, d.Owner, d.Expected, actual, rec.repair)
+			}
+			log.Infof("share balances differing: %d", len(differences))
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/bitmark-inc/logger"
 	"strings"
 	"sync"
+	"time"
 )
 
 // holds the database handle
@@ -18,7 +19,9 @@ var globalData struct {
 	sync.Mutex
 	log        *logger.L
 	database   *sql.DB
+	rawBlocks  bool // keep packed blocks in block_data
 	exp        expiry
+	rec        reconciler
 	background *background.T
 }
 
@@ -35,6 +38,10 @@ type Configuration struct {
 	SslCert     string `gluamapper:"sslcert" json:"sslcert"`         // Cert file location. The file must contain PEM encoded data.
 	SslKey      string `gluamapper:"sslkey" json:"sslkey"`           // Key file location. The file must contain PEM encoded data.
 	SslRootCert string `gluamapper:"sslrootcert" json:"sslrootcert"` // The location of the root certificate file. The file must contain PEM encoded data.
+	RawBlocks   bool   `gluamapper:"raw_blocks" json:"raw_blocks"`   // Keep the packed blocks so the tables can be rebuilt by reindex.
+
+	ReconcileInterval int  `gluamapper:"reconcile_interval" json:"reconcile_interval"` // Seconds between share balance reconciliations, zero => never.
+	ReconcileRepair   bool `gluamapper:"reconcile_repair" json:"reconcile_repair"`     // Correct the share balances found to differ.
 }
 
 // open up the database connection
@@ -68,6 +75,7 @@ func Initialise(database Configuration) error {
 		return err
 	}
 	globalData.database = db
+	globalData.rawBlocks = database.RawBlocks
 
 	// // ensure that the database is compatible
 	// versionValue, err := globalData.database.Get(versionKey, nil)
@@ -85,12 +93,16 @@ func Initialise(database Configuration) error {
 	if err := globalData.exp.initialise(db); nil != err {
 		return err
 	}
+	if err := globalData.rec.initialise(db, time.Duration(database.ReconcileInterval)*time.Second, database.ReconcileRepair); nil != err {
+		return err
+	}
 
 	// start background processes
 	globalData.log.Info("start background…")
 
 	var processes = background.Processes{
 		&globalData.exp,
+		&globalData.rec,
 	}
 
 	globalData.background = background.Start(processes, globalData.log)
@@ -118,6 +130,30 @@ func Finalise() {
 	globalData.database = nil
 }
 
+// Ping - check that the database is reachable
+func Ping() error {
+	globalData.Lock()
+	db := globalData.database
+	globalData.Unlock()
+
+	if nil == db {
+		return fault.ErrNotInitialised
+	}
+	return db.Ping()
+}
+
+// RunExpiry - remove expired records immediately
+func RunExpiry() error {
+	globalData.Lock()
+	initialised := nil != globalData.database
+	globalData.Unlock()
+
+	if !initialised {
+		return fault.ErrNotInitialised
+	}
+	return globalData.exp.expire()
+}
+
 // produce "name='value'
 func quote(name string, value string) string {
 	if "" == name || "" == value {
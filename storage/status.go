@@ -0,0 +1,61 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"github.com/bitmark-inc/bitmarkd/fault"
+)
+
+const (
+	// updateSyncStatus:
+	//   1:  state          TEXT
+	//   2:  mode           TEXT
+	//   3:  synchronised   BOOLEAN
+	//   4:  remote_height  INT8
+	//   5:  node           TEXT
+	//   6:  last_error     TEXT
+	updateSyncStatusSQL = `SELECT blockchain.update_sync_status($1, $2, $3, $4, $5, $6);`
+)
+
+// SyncStatus - the values written to the sync_status table
+// the local height and last block time are read from the block table,
+// LocalHeight only lets the caller detect that blocks were stored
+type SyncStatus struct {
+	State        string
+	Mode         string
+	Synchronised bool
+	LocalHeight  uint64
+	RemoteHeight uint64
+	Node         string
+	LastError    string
+}
+
+// UpdateSyncStatus - record the synchronisation status
+func UpdateSyncStatus(status SyncStatus) error {
+	globalData.Lock()
+	db := globalData.database
+	log := globalData.log
+	globalData.Unlock()
+
+	if nil == db {
+		return fault.ErrNotInitialised
+	}
+
+	_, err := db.Exec(updateSyncStatusSQL,
+		status.State,
+		status.Mode,
+		status.Synchronised,
+		status.RemoteHeight,
+		status.Node,
+		status.LastError,
+	)
+	if nil != err {
+		log.Errorf("updateSyncStatusSQL: state: %s  error: %s", status.State, err)
+		return err
+	}
+	log.Debugf("updateSyncStatusSQL: state: %s", status.State)
+
+	return nil
+}
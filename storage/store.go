@@ -9,6 +9,7 @@ import (
 	"database/sql/driver"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -126,12 +127,25 @@ const (
 
 	// deleteDownToBlock:
 	//   1:  block_number   INT8
-	deleteDownToBlockSQL = `SELECT blockchain.delete_down_to_block($1);`
+	//   2:  new_tip        INT8  (zero if not known)
+	//   3:  reason         TEXT
+	deleteDownToBlockSQL = `SELECT blockchain.delete_down_to_block($1, $2, $3);`
+
+	// revertDownToBlock:
+	//   1:  block_number   INT8
+	revertDownToBlockSQL = `SELECT blockchain.revert_down_to_block($1);`
+
+	// rebuildMode: existing records are rewritten by the insert
+	// functions until the end of the database transaction
+	rebuildModeSQL = `SELECT set_config('blockchain.rebuild', 'on', true);`
 
 	// deleteExpiredRecords:
 	deleteExpiredRecordsSQL = `SELECT blockchain.expire_records();`
 )
 
+// payments of a transfer that cannot be stored
+var errUnexpectedCurrencies = errors.New("currencies has unexpected value")
+
 // PostgreSQL error codes
 const (
 	not_null_violation = "not_null_violation"
@@ -151,7 +165,18 @@ const (
 
 // store an incoming block checking to make sure it is valid first
 func StoreBlock(packedBlock []byte) error {
+	return storeBlock(packedBlock, false)
+}
+
+// store a block again for reindex, every column of records that
+// already exist is rewritten from the block
+func RebuildBlock(packedBlock []byte) error {
+	return storeBlock(packedBlock, true)
+}
+
+func storeBlock(packedBlock []byte, rebuild bool) error {
 
+	start := time.Now()
 	testnet := mode.IsTesting()
 
 	newAssets := []string{}
@@ -185,7 +210,7 @@ func StoreBlock(packedBlock []byte) error {
 			previousBlock.String(), header.PreviousBlock.String())
 
 		// revert local block
-		if err := DeleteDownToBlock(h - blockRevertLimit); err != nil {
+		if err := DeleteDownToBlock(h-blockRevertLimit, header.Number, ReorgRevert); err != nil {
 			log.Criticalf("fail to revert block: error: %s", err)
 		}
 		return fault.ErrPreviousBlockDigestDoesNotMatch
@@ -193,11 +218,15 @@ func StoreBlock(packedBlock []byte) error {
 
 	type txn struct {
 		unpacked interface{}
+		record   *txRecord
 	}
 
 	txs := make([]txn, header.TransactionCount)
 	txIds := make([]merkle.Digest, header.TransactionCount)
 
+	// the id of the foundation record
+	foundationTxId := blockrecord.FoundationTxId(header, digest)
+
 	// check all transactions are valid
 	for i := uint16(0); i < header.TransactionCount; i += 1 {
 		transaction, n, err := transactionrecord.Packed(data).Unpack(testnet)
@@ -208,6 +237,11 @@ func StoreBlock(packedBlock []byte) error {
 		txs[i].unpacked = transaction
 		txIds[i] = merkle.NewDigest(data[:n])
 
+		txs[i].record, err = convertTransaction(txIds[i], foundationTxId, transaction)
+		if nil != err {
+			return err
+		}
+
 		data = data[n:]
 	}
 
@@ -227,11 +261,9 @@ func StoreBlock(packedBlock []byte) error {
 
 	createdOn := time.Unix(int64(header.Timestamp), 0).UTC()
 
-	assetStatus := statusPending
-	transferStatus := statusPending
+	status := statusPending
 	if blockNumber != 0 {
-		assetStatus = statusConfirmed
-		transferStatus = statusConfirmed
+		status = statusConfirmed
 	}
 
 	// start the database transaction
@@ -241,12 +273,20 @@ func StoreBlock(packedBlock []byte) error {
 		return err
 	}
 
+	if rebuild {
+		_, err = db.Exec(rebuildModeSQL)
+		if nil != err {
+			db.Rollback()
+			return err
+		}
+	}
+
 	// Note: after here, do not: return err
 	//       instead, do:        errX=err; goto rollback
 	errX := error(nil)
 
-	// create the foundation record
-	foundationTxId := blockrecord.FoundationTxId(header, digest)
+	// count of each type of transaction stored
+	tally := make(txTally)
 
 	// store the block
 	err = insertBlock(blockNumber, digest, createdOn, db, log)
@@ -254,6 +294,13 @@ func StoreBlock(packedBlock []byte) error {
 		errX = err
 		goto rollback
 	}
+	if globalData.rawBlocks {
+		err = insertBlockData(blockNumber, digest, packedBlock, db, log)
+		if nil != err {
+			errX = err
+			goto rollback
+		}
+	}
 
 	// extract data from old base records (old headers records 0..1)
 	{
@@ -279,79 +326,39 @@ func StoreBlock(packedBlock []byte) error {
 		}
 
 		if nil != f {
-			_, err := insertFoundation(foundationTxId, f, transferStatus, blockNumber, 0, "", db, log)
+			record, err := foundationRecord(foundationTxId, f)
+			if nil == err {
+				err = record.insert(status, blockNumber, 0, "", db, log)
+			}
 			if nil != err {
 				errX = err
 				goto rollback
 			}
+			tally[record.kind] += 1
 		}
 	}
 
 	// store transactions
 	for i, item := range txs {
-		blockOffset := uint64(i)
-		txId := txIds[i]
-		switch tx := item.unpacked.(type) {
-
-		case *transactionrecord.OldBaseData:
-			// no action here
-
-		case *transactionrecord.AssetData:
-			id, err := insertAsset(tx, assetStatus, blockNumber, blockOffset, db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
-			newAssets = append(newAssets, id)
-
-		case *transactionrecord.BitmarkIssue:
-			id, err := insertIssue(txId, tx, transferStatus, blockNumber, blockOffset, "", db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
-			newIssues = append(newIssues, id)
-
-		case *transactionrecord.BitmarkTransferUnratified, *transactionrecord.BitmarkTransferCountersigned, *transactionrecord.BlockOwnerTransfer:
-			id, err := insertTransfer(txId, tx.(transactionrecord.BitmarkTransfer), transferStatus, blockNumber, blockOffset, "", db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
-			newTransfers = append(newTransfers, id)
-
-		case *transactionrecord.BitmarkShare:
-			_, err := insertShare(txId, tx, transferStatus, blockNumber, blockOffset, "", db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
-
-		case *transactionrecord.ShareGrant:
-			_, err := insertShareGrant(txId, tx, transferStatus, blockNumber, blockOffset, "", db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
-
-		case *transactionrecord.ShareSwap:
-			_, err := insertSwapTransaction(txId, tx, transferStatus, blockNumber, blockOffset, "", db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
-
-		case *transactionrecord.BlockFoundation:
-			id, err := insertFoundation(foundationTxId, tx, transferStatus, blockNumber, 0, "", db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
-			newIssues = append(newIssues, id)
-
-		default:
-			globalData.log.Criticalf("unhandled transaction: %v", tx)
-			logger.Panicf("unhandled transaction: %v", tx)
+		record := item.record
+		if nil == record {
+			// old base data, stored above
+			continue
+		}
+		err := record.insert(status, blockNumber, uint64(i), "", db, log)
+		if nil != err {
+			errX = err
+			goto rollback
+		}
+		tally[record.kind] += 1
+
+		switch record.kind {
+		case "asset":
+			newAssets = append(newAssets, record.id)
+		case "issue", "foundation":
+			newIssues = append(newIssues, record.id)
+		case "transfer":
+			newTransfers = append(newTransfers, record.id)
 		}
 	}
 
@@ -359,6 +366,10 @@ func StoreBlock(packedBlock []byte) error {
 		errX = err
 		goto rollback
 	}
+	if err := updateOwnership(blockNumber, db, log); err != nil {
+		errX = err
+		goto rollback
+	}
 
 	err = db.Commit()
 	if nil != err {
@@ -367,6 +378,11 @@ func StoreBlock(packedBlock []byte) error {
 		goto rollback
 	}
 
+	blocksStoredCounter.Inc()
+	localHeightGauge.Set(float64(blockNumber))
+	tally.commit(status)
+	storeBlockDuration.ObserveDuration(start)
+
 	// Ignore the block which is created 72 hours before
 	if time.Now().UTC().Sub(createdOn) < 72*time.Hour {
 		if len(newAssets) != 0 {
@@ -396,6 +412,9 @@ func StoreBlock(packedBlock []byte) error {
 		}
 	}
 
+	// issues in this block may be the parents of orphans
+	retryOrphans(log)
+
 	return nil
 
 rollback:
@@ -405,11 +424,30 @@ rollback:
 
 // store transactions
 func StoreTransactions(packedTransactions []byte) error {
-	log := globalData.log
 	if nil == globalData.database {
 		return fault.ErrNotInitialised
 	}
 
+	// payment identifier for whole block as a hex string
+	d := sha3.Sum384(packedTransactions)
+	payId := hex.EncodeToString(d[:])
+
+	err := storeTransactions(packedTransactions, payId, "")
+	if nil != err {
+		return err
+	}
+
+	// these transactions may be the parents of orphans
+	retryOrphans(globalData.log)
+
+	return nil
+}
+
+// store pending transactions, transfers without a parent are parked as orphans
+// an adopted orphan is deleted in the same database transaction
+func storeTransactions(packedTransactions []byte, payId string, orphanId string) error {
+	log := globalData.log
+
 	// start the database transaction
 	db, err := globalData.database.Begin()
 	if nil != err {
@@ -425,12 +463,11 @@ func StoreTransactions(packedTransactions []byte) error {
 
 	blockNumber := uint64(0)
 	blockOffset := uint64(0)
-	assetStatus := statusPending
-	transferStatus := statusPending
+	status := statusPending
 
-	// payment identifier for whole block as a hex string
-	d := sha3.Sum384(packedTransactions)
-	payId := hex.EncodeToString(d[:])
+	// count of each type of transaction stored
+	tally := make(txTally)
+	parked := 0
 
 	for 0 != len(packedTransactions) {
 		transaction, n, err := transactionrecord.Packed(packedTransactions).Unpack(testnet)
@@ -440,79 +477,67 @@ func StoreTransactions(packedTransactions []byte) error {
 		}
 		txId := transactionrecord.Packed(packedTransactions[:n]).MakeLink()
 
-		switch tx := transaction.(type) {
-
-		case *transactionrecord.OldBaseData:
-			// no action needed here
-
-		case *transactionrecord.AssetData:
-			_, err := insertAsset(tx, assetStatus, blockNumber, blockOffset, db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
-
-		case *transactionrecord.BitmarkIssue:
-			_, err := insertIssue(txId, tx, transferStatus, blockNumber, blockOffset, "", db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
+		record, err := convertTransaction(txId, txId, transaction)
+		if nil != err {
+			errX = err
+			goto rollback
+		}
 
-			if id, err := txId.MarshalText(); err == nil {
-				if err := notifyPendingTx(string(id), globalData.database, log); err != nil {
-					log.Errorf("pending tx notify error: %s", err)
-				}
-			} else {
-				log.Errorf("pending tx notify error: %s", err)
-			}
+		switch tx := transaction.(type) {
 
 		case *transactionrecord.BitmarkTransferUnratified, *transactionrecord.BitmarkTransferCountersigned, *transactionrecord.BlockOwnerTransfer:
-			_, err := insertTransfer(txId, tx.(transactionrecord.BitmarkTransfer), transferStatus, blockNumber, blockOffset, payId, db, log)
+			transfer := tx.(transactionrecord.BitmarkTransfer)
+			found, err := parentExists(transfer, db)
 			if nil != err {
 				errX = err
 				goto rollback
 			}
-
-			if id, err := txId.MarshalText(); err == nil {
-				if err := notifyPendingTx(string(id), globalData.database, log); err != nil {
-					log.Errorf("pending tx notify error: %s", err)
+			if !found {
+				err := parkOrphan(txId, transfer, packedTransactions[:n], payId, db, log)
+				if nil != err {
+					errX = err
+					goto rollback
 				}
-			} else {
-				log.Errorf("pending tx notify error: %s", err)
+				parked += 1
+				packedTransactions = packedTransactions[n:]
+				continue
 			}
 
-		case *transactionrecord.BitmarkShare:
-			_, err := insertShare(txId, tx, transferStatus, blockNumber, blockOffset, payId, db, log)
-			if nil != err {
-				errX = err
-				goto rollback
-			}
+		case *transactionrecord.BlockFoundation:
+			// only stored from a block
+			record = nil
+		}
 
-		case *transactionrecord.ShareGrant:
-			_, err := insertShareGrant(txId, tx, transferStatus, blockNumber, blockOffset, payId, db, log)
-			if nil != err {
-				errX = err
-				goto rollback
+		if nil != record {
+			// issues are not recorded with the pay id
+			recordPayId := payId
+			if "issue" == record.kind {
+				recordPayId = ""
 			}
-
-		case *transactionrecord.ShareSwap:
-			_, err := insertSwapTransaction(txId, tx, transferStatus, blockNumber, blockOffset, payId, db, log)
+			err := record.insert(status, blockNumber, blockOffset, recordPayId, db, log)
 			if nil != err {
 				errX = err
 				goto rollback
 			}
+			tally[record.kind] += 1
 
-		case *transactionrecord.BlockFoundation:
-			// no action needed here
-
-		default:
-			globalData.log.Criticalf("unhandled transaction: %v", tx)
-			logger.Panicf("unhandled transaction: %v", tx)
+			if "issue" == record.kind || "transfer" == record.kind {
+				if err := notifyPendingTx(record.id, globalData.database, log); err != nil {
+					log.Errorf("pending tx notify error: %s", err)
+				}
+			}
 		}
 		packedTransactions = packedTransactions[n:]
 	}
 
+	if "" != orphanId {
+		err := deleteOrphan(orphanId, db, log)
+		if nil != err {
+			errX = err
+			goto rollback
+		}
+	}
+
 	err = db.Commit()
 	if nil != err {
 		log.Errorf("transaction commit error: %s", err)
@@ -520,6 +545,9 @@ func StoreTransactions(packedTransactions []byte) error {
 		goto rollback
 	}
 
+	tally.commit(status)
+	orphansParked(parked)
+
 	return nil
 
 rollback:
@@ -618,20 +646,96 @@ func insertBlock(blockNumber uint64, digest blockdigest.Digest, createdOn time.T
 	return nil
 }
 
-func insertAsset(asset *transactionrecord.AssetData, status statusType, blockNumber uint64, blockOffset uint64, db *sql.Tx, log *logger.L) (string, error) {
+// a transaction converted for its insert function
+//
+// every conversion that can fail is done before the record is made so
+// that VerifyBlock finds the same problems as StoreBlock
+type txRecord struct {
+	kind   string // for the tally
+	id     string // for notifications
+	insert func(status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) error
+}
+
+// convert one unpacked transaction, nil for old base data, which
+// StoreBlock combines into a foundation record
+//
+// foundationTxId is the id for a block foundation, a type that is not
+// handled is fatal
+func convertTransaction(txId merkle.Digest, foundationTxId merkle.Digest, transaction interface{}) (*txRecord, error) {
+	switch tx := transaction.(type) {
+
+	case *transactionrecord.OldBaseData:
+		return nil, nil
+
+	case *transactionrecord.AssetData:
+		return assetRecord(tx)
+
+	case *transactionrecord.BitmarkIssue:
+		return issueRecord(txId, tx)
+
+	case *transactionrecord.BitmarkTransferUnratified, *transactionrecord.BitmarkTransferCountersigned, *transactionrecord.BlockOwnerTransfer:
+		return transferRecord(txId, tx.(transactionrecord.BitmarkTransfer))
+
+	case *transactionrecord.BitmarkShare:
+		return shareRecord(txId, tx)
+
+	case *transactionrecord.ShareGrant:
+		return grantRecord(txId, tx)
+
+	case *transactionrecord.ShareSwap:
+		return swapRecord(txId, tx)
+
+	case *transactionrecord.BlockFoundation:
+		return foundationRecord(foundationTxId, tx)
+
+	default:
+		globalData.log.Criticalf("unhandled transaction: %v", tx)
+		logger.Panicf("unhandled transaction: %v", tx)
+	}
+	return nil, nil
+}
+
+func assetRecord(asset *transactionrecord.AssetData) (*txRecord, error) {
 	assetId := asset.AssetId()
 	id, err := assetId.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 	name := asset.Name
 	fingerprint := asset.Fingerprint
-	packedMetadata := asset.Metadata
 	registrant := asset.Registrant.String()
 	signature, err := asset.Signature.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
+	}
+	metadata, err := assetMetadata(asset.Metadata)
+	if nil != err {
+		return nil, err
+	}
+
+	insert := func(status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) error {
+		_, err := db.Exec(insertAssetSQL, id, name, fingerprint, metadata, registrant, signature, status, blockNumber, blockOffset)
+		if nil != err {
+			log.Errorf("insertAsset: "+
+				"assetId: %q, name: %q, fingerprint: %q, metadata: %q, "+
+				"registrant: %q, signature: %q, status: %q, block: %d  error: %s",
+				id, name, fingerprint, metadata,
+				registrant, signature, status, blockNumber, err)
+			return err
+		}
+		log.Debugf("insertAsset: "+
+			"assetId: %q, name: %q, fingerprint: %q, metadata: %q, "+
+			"registrant: %q, signature: %q, status: %q, block: %d",
+			id, name, fingerprint, metadata,
+			registrant, signature, status, blockNumber)
+		return nil
 	}
+	return &txRecord{kind: "asset", id: assetId.String(), insert: insert}, nil
+}
+
+// split the NUL separated metadata of an asset into a JSON object
+// a trailing key without a value is dropped
+func assetMetadata(packedMetadata string) ([]byte, error) {
 	m := strings.Split(packedMetadata, "\u0000")
 	metaMap := make(map[string]string)
 	if 1 == len(m)%2 {
@@ -642,200 +746,200 @@ func insertAsset(asset *transactionrecord.AssetData, status statusType, blockNum
 			metaMap[m[i]] = m[i+1]
 		}
 	}
-	metadata, err := json.Marshal(metaMap)
-	if nil != err {
-		return "", err
-	}
-
-	_, err = db.Exec(insertAssetSQL, id, name, fingerprint, metadata, registrant, signature, status, blockNumber, blockOffset)
-	if nil != err {
-		log.Errorf("insertAsset: "+
-			"assetId: %q, name: %q, fingerprint: %q, metadata: %q, "+
-			"registrant: %q, signature: %q, status: %q, block: %d  error: %s",
-			id, name, fingerprint, metadata,
-			registrant, signature, status, blockNumber, err)
-		return "", err
-	}
-	log.Debugf("insertAsset: "+
-		"assetId: %q, name: %q, fingerprint: %q, metadata: %q, "+
-		"registrant: %q, signature: %q, status: %q, block: %d",
-		id, name, fingerprint, metadata,
-		registrant, signature, status, blockNumber)
-
-	return assetId.String(), nil
+	return json.Marshal(metaMap)
 }
 
-func insertIssue(txId merkle.Digest, issue *transactionrecord.BitmarkIssue, status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) (string, error) {
+func issueRecord(txId merkle.Digest, issue *transactionrecord.BitmarkIssue) (*txRecord, error) {
 	id, err := txId.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 	owner := issue.Owner.String()
 	signature, err := issue.Signature.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 	asset_id, err := issue.AssetId.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
-	_, err = db.Exec(insertBitmarkSQL, id, owner, signature, "", asset_id, nil, status, nil, payId, blockNumber, blockOffset)
-	if nil != err {
-		log.Errorf("insertIssue: "+
+	insert := func(status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) error {
+		_, err := db.Exec(insertBitmarkSQL, id, owner, signature, "", asset_id, nil, status, nil, payId, blockNumber, blockOffset)
+		if nil != err {
+			log.Errorf("insertIssue: "+
+				"id: %q, owner: %q, signature: %q, asset_id: %q, "+
+				"status: %q, block: %d  error: %s",
+				id, owner, signature, asset_id,
+				status, blockNumber, err)
+			return err
+		}
+		log.Debugf("insertIssue: "+
 			"id: %q, owner: %q, signature: %q, asset_id: %q, "+
-			"status: %q, block: %d  error: %s",
+			"status: %q, block: %d to DB",
 			id, owner, signature, asset_id,
-			status, blockNumber, err)
-		return "", err
+			status, blockNumber)
+		return nil
 	}
-	log.Debugf("insertIssue: "+
-		"id: %q, owner: %q, signature: %q, asset_id: %q, "+
-		"status: %q, block: %d to DB",
-		id, owner, signature, asset_id,
-		status, blockNumber)
-
-	return string(id), nil
+	return &txRecord{kind: "issue", id: string(id), insert: insert}, nil
 }
 
-func insertFoundation(txId merkle.Digest, issue *transactionrecord.BlockFoundation, status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) (string, error) {
+func foundationRecord(txId merkle.Digest, issue *transactionrecord.BlockFoundation) (*txRecord, error) {
 	id, err := txId.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 	owner := issue.Owner.String()
 	signature, err := issue.Signature.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	currencies, err := json.Marshal(issue.Payments)
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
-	_, err = db.Exec(insertBitmarkSQL, id, owner, signature, "", nil, nil, status, currencies, payId, blockNumber, blockOffset)
-	if nil != err {
-		log.Errorf("insertFoundation: "+
+	insert := func(status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) error {
+		_, err := db.Exec(insertBitmarkSQL, id, owner, signature, "", nil, nil, status, currencies, payId, blockNumber, blockOffset)
+		if nil != err {
+			log.Errorf("insertFoundation: "+
+				"id: %q, owner: %q, signature: %q, currencies: %q, "+
+				"status: %q, block: %d  error: %s",
+				id, owner, signature, currencies,
+				status, blockNumber, err)
+			return err
+		}
+		log.Debugf("insertFoundation: "+
 			"id: %q, owner: %q, signature: %q, currencies: %q, "+
-			"status: %q, block: %d  error: %s",
+			"status: %q, block: %d to DB",
 			id, owner, signature, currencies,
-			status, blockNumber, err)
-		return "", err
+			status, blockNumber)
+		return nil
 	}
-	log.Debugf("insertFoundation: "+
-		"id: %q, owner: %q, signature: %q, currencies: %q, "+
-		"status: %q, block: %d to DB",
-		id, owner, signature, currencies,
-		status, blockNumber)
-
-	return string(id), nil
+	return &txRecord{kind: "foundation", id: string(id), insert: insert}, nil
 }
 
-func insertTransfer(txId merkle.Digest, transfer transactionrecord.BitmarkTransfer, status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) (string, error) {
+func transferRecord(txId merkle.Digest, transfer transactionrecord.BitmarkTransfer) (*txRecord, error) {
 	id, err := txId.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 	owner := transfer.GetOwner().String()
 	countersignature, err := transfer.GetCountersignature().MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 	signature, err := transfer.GetSignature().MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 	previous_id, err := transfer.GetLink().MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
-	var currencies *string
-	if payments := transfer.GetCurrencies(); nil != payments {
-		c, err := json.Marshal(payments)
-		if nil != err {
-			return "", err
-		}
-		c1 := string(c)
-		if "null" == c1 || "{}" == c1 {
-			log.Criticalf("currencies has unxpected value: %q", c1)
-			logger.Panicf("currencies has unxpected value: %q", c1)
-		}
-		currencies = &c1
+	currencies, err := transferCurrencies(transfer)
+	if errUnexpectedCurrencies == err {
+		globalData.log.Criticalf("currencies has unxpected value: %q", *currencies)
+		logger.Panicf("currencies has unxpected value: %q", *currencies)
+	} else if nil != err {
+		return nil, err
 	}
 
-	_, err = db.Exec(insertBitmarkSQL, id, owner, signature, countersignature, nil, previous_id, status, currencies, payId, blockNumber, blockOffset)
-	if err, ok := err.(*pq.Error); ok {
-		log.Errorf("insertTransfer: "+
-			"id: %q, owner: %q, signature: %q, countersignature: %q, previous_id: %q, "+
-			"status: %q, block: %d  error: %s",
-			id, owner, signature, countersignature, previous_id,
-			status, blockNumber, err)
-
-		if err.Code.Name() == not_null_violation { // pre_id transfer is not in DB
-			log.Criticalf("Database is corrupt: block: %d insert transfer: %q  previous transfer: %q does not exist (%v)",
-				blockNumber, txId, previous_id, err.Code.Name())
-			logger.Panicf("Database is corrupt: block: %d insert transfer: %q  previous transfer: %q does not exist (%v)",
-				blockNumber, txId, previous_id, err.Code.Name())
+	insert := func(status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) error {
+		_, err := db.Exec(insertBitmarkSQL, id, owner, signature, countersignature, nil, previous_id, status, currencies, payId, blockNumber, blockOffset)
+		if err, ok := err.(*pq.Error); ok {
+			log.Errorf("insertTransfer: "+
+				"id: %q, owner: %q, signature: %q, countersignature: %q, previous_id: %q, "+
+				"status: %q, block: %d  error: %s",
+				id, owner, signature, countersignature, previous_id,
+				status, blockNumber, err)
+
+			if err.Code.Name() == not_null_violation { // pre_id transfer is not in DB
+				log.Criticalf("Database is corrupt: block: %d insert transfer: %q  previous transfer: %q does not exist (%v)",
+					blockNumber, txId, previous_id, err.Code.Name())
+				logger.Panicf("Database is corrupt: block: %d insert transfer: %q  previous transfer: %q does not exist (%v)",
+					blockNumber, txId, previous_id, err.Code.Name())
+			}
+			return err
 		}
-		return "", err
-	}
 
-	log.Debugf("insertTransfer: "+
-		"id: %q, owner: %q, signature: %q, previous_id: %q, "+
-		"status: %q, block: %d",
-		id, owner, signature, previous_id,
-		status, blockNumber)
+		log.Debugf("insertTransfer: "+
+			"id: %q, owner: %q, signature: %q, previous_id: %q, "+
+			"status: %q, block: %d",
+			id, owner, signature, previous_id,
+			status, blockNumber)
+		return nil
+	}
+	return &txRecord{kind: "transfer", id: string(id), insert: insert}, nil
+}
 
-	return string(id), nil
+// payments of a transfer as JSON, nil if it has none
+// an empty payment map gives errUnexpectedCurrencies with the JSON
+func transferCurrencies(transfer transactionrecord.BitmarkTransfer) (*string, error) {
+	payments := transfer.GetCurrencies()
+	if nil == payments {
+		return nil, nil
+	}
+	c, err := json.Marshal(payments)
+	if nil != err {
+		return nil, err
+	}
+	c1 := string(c)
+	if "null" == c1 || "{}" == c1 {
+		return &c1, errUnexpectedCurrencies
+	}
+	return &c1, nil
 }
 
-func insertShare(txId merkle.Digest, share *transactionrecord.BitmarkShare, status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) (string, error) {
+func shareRecord(txId merkle.Digest, share *transactionrecord.BitmarkShare) (*txRecord, error) {
 	id, err := txId.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	signature, err := share.GetSignature().MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	previous_id, err := share.GetLink().MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
-	_, err = db.Exec(insertShareSQL, id, share.Quantity, signature, previous_id, payId, status, blockNumber, blockOffset)
-	if err, ok := err.(*pq.Error); ok {
-		log.Errorf("insertShare: "+
-			"id: %q, signature: %q, previous_id: %q, status: %q, block: %d  error: %s",
-			id, signature, previous_id, status, blockNumber, err)
+	insert := func(status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) error {
+		_, err := db.Exec(insertShareSQL, id, share.Quantity, signature, previous_id, payId, status, blockNumber, blockOffset)
+		if err, ok := err.(*pq.Error); ok {
+			log.Errorf("insertShare: "+
+				"id: %q, signature: %q, previous_id: %q, status: %q, block: %d  error: %s",
+				id, signature, previous_id, status, blockNumber, err)
+		}
+		return err
 	}
-
-	return string(id), err
+	return &txRecord{kind: "share", id: string(id), insert: insert}, nil
 }
 
-func insertShareGrant(txId merkle.Digest, grant *transactionrecord.ShareGrant, status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) (string, error) {
+func grantRecord(txId merkle.Digest, grant *transactionrecord.ShareGrant) (*txRecord, error) {
 	id, err := txId.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	shareId, err := grant.ShareId.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	signature, err := grant.Signature.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	countersignature, err := grant.Countersignature.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	shareInfo, err := json.Marshal(map[string]interface{}{
@@ -845,44 +949,46 @@ func insertShareGrant(txId merkle.Digest, grant *transactionrecord.ShareGrant, s
 		"quantity": grant.Quantity,
 	})
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
-	_, err = db.Exec(insertGrantSQL, id, shareId, grant.Quantity, grant.Owner.String(), grant.Recipient.String(),
-		signature, countersignature, payId, shareInfo, status, blockNumber, blockOffset)
-	if err, ok := err.(*pq.Error); ok {
-		log.Errorf("insertShareGrant: "+
-			"id: %q, shareId: %q, quantity: %q, owner: %q, recipient: %q, signature: %q, countersignature: %q, status: %q, block: %d  error: %s",
-			id, shareId, grant.Quantity, grant.Owner.String(), grant.Recipient.String(), signature, countersignature, status, blockNumber, err)
+	insert := func(status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) error {
+		_, err := db.Exec(insertGrantSQL, id, shareId, grant.Quantity, grant.Owner.String(), grant.Recipient.String(),
+			signature, countersignature, payId, shareInfo, status, blockNumber, blockOffset)
+		if err, ok := err.(*pq.Error); ok {
+			log.Errorf("insertShareGrant: "+
+				"id: %q, shareId: %q, quantity: %q, owner: %q, recipient: %q, signature: %q, countersignature: %q, status: %q, block: %d  error: %s",
+				id, shareId, grant.Quantity, grant.Owner.String(), grant.Recipient.String(), signature, countersignature, status, blockNumber, err)
+		}
+		return err
 	}
-
-	return string(id), err
+	return &txRecord{kind: "grant", id: string(id), insert: insert}, nil
 }
 
-func insertSwapTransaction(txId merkle.Digest, swap *transactionrecord.ShareSwap, status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) (string, error) {
+func swapRecord(txId merkle.Digest, swap *transactionrecord.ShareSwap) (*txRecord, error) {
 	id, err := txId.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	shareOne, err := swap.ShareIdOne.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	shareTwo, err := swap.ShareIdTwo.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	signature, err := swap.Signature.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	countersignature, err := swap.Countersignature.MarshalText()
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
 	swapInfo, err := json.Marshal(map[string]interface{}{
@@ -894,23 +1000,25 @@ func insertSwapTransaction(txId merkle.Digest, swap *transactionrecord.ShareSwap
 		"owner_two":    swap.OwnerTwo,
 	})
 	if nil != err {
-		return "", err
+		return nil, err
 	}
 
-	_, err = db.Exec(insertSwapSQL, id,
-		shareOne, swap.QuantityOne, swap.OwnerOne.String(),
-		shareTwo, swap.QuantityTwo, swap.OwnerTwo.String(),
-		signature, countersignature, payId, swapInfo, status, blockNumber, blockOffset)
-	if err, ok := err.(*pq.Error); ok {
-		log.Errorf("insertSwap: "+
-			"id: %q, shareOne: %q, quantityOne: %q, ownerOne: %q, shareOne: %q, quantityOne: %q, ownerOne: %q,"+
-			"signature: %q, countersignature: %q, status: %q, block: %d  error: %s",
-			id, shareOne, swap.QuantityOne, swap.OwnerOne.String(),
+	insert := func(status statusType, blockNumber uint64, blockOffset uint64, payId string, db *sql.Tx, log *logger.L) error {
+		_, err := db.Exec(insertSwapSQL, id,
+			shareOne, swap.QuantityOne, swap.OwnerOne.String(),
 			shareTwo, swap.QuantityTwo, swap.OwnerTwo.String(),
-			signature, countersignature, status, blockNumber, err)
+			signature, countersignature, payId, swapInfo, status, blockNumber, blockOffset)
+		if err, ok := err.(*pq.Error); ok {
+			log.Errorf("insertSwap: "+
+				"id: %q, shareOne: %q, quantityOne: %q, ownerOne: %q, shareOne: %q, quantityOne: %q, ownerOne: %q,"+
+				"signature: %q, countersignature: %q, status: %q, block: %d  error: %s",
+				id, shareOne, swap.QuantityOne, swap.OwnerOne.String(),
+				shareTwo, swap.QuantityTwo, swap.OwnerTwo.String(),
+				signature, countersignature, status, blockNumber, err)
+		}
+		return err
 	}
-
-	return string(id), err
+	return &txRecord{kind: "swap", id: string(id), insert: insert}, nil
 }
 
 func updateEditions(blockNumber uint64, db *sql.Tx, log *logger.L) error {
@@ -931,19 +1039,59 @@ func GetBlockHeight() (uint64, error) {
 		return 0, err
 	}
 	if blockNumber <= genesis.BlockNumber {
+		localHeightGauge.Set(float64(genesis.BlockNumber))
 		if mode.IsTesting() {
 			return genesis.BlockNumber, nil
 		} else {
 			return genesis.BlockNumber, nil
 		}
 	}
+	localHeightGauge.Set(float64(blockNumber))
 
 	return blockNumber, nil
 }
 
+// reasons for removing blocks, recorded in the reorg table
+const (
+	ReorgFork   = "fork"   // connector found a different chain on the node
+	ReorgRevert = "revert" // incoming block did not follow the local chain
+	ReorgRewind = "rewind" // requested by the administrator
+	ReorgImport = "import" // block file diverged from the local chain
+)
+
 // delete all blocks up from and including the start value
-func DeleteDownToBlock(startBlockNumber uint64) error {
-	_, err := globalData.database.Exec(deleteDownToBlockSQL, startBlockNumber)
+// newTip is the height of the replacing chain, zero if not known
+func DeleteDownToBlock(startBlockNumber uint64, newTip uint64, reason string) error {
+	h, err := GetBlockHeight()
+	if nil != err {
+		return err
+	}
+
+	_, err = globalData.database.Exec(deleteDownToBlockSQL, startBlockNumber, newTip, reason)
+	if nil != err {
+		return err
+	}
+
+	if h >= startBlockNumber {
+		reorgCounter.Inc()
+		reorgDepth.Observe(float64(h - startBlockNumber + 1))
+	}
+
+	// refresh the height metric
+	_, err = GetBlockHeight()
+	return err
+}
+
+// delete all blocks up from and including the start value so that
+// reindex can store them again, no reorganisation is recorded
+func RevertDownToBlock(startBlockNumber uint64) error {
+	_, err := globalData.database.Exec(revertDownToBlockSQL, startBlockNumber)
+	if nil != err {
+		return err
+	}
+
+	// refresh the height metric
+	_, err = GetBlockHeight()
 	return err
 }
 
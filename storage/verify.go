@@ -0,0 +1,84 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/blockrecord"
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/merkle"
+	"github.com/bitmark-inc/bitmarkd/mode"
+	"github.com/bitmark-inc/bitmarkd/transactionrecord"
+)
+
+// TransactionProblem - a transaction of a block that StoreBlock could
+// not store
+type TransactionProblem struct {
+	Offset int           // position in the block
+	TxId   merkle.Digest // transaction id
+	Type   string        // decoded record type
+	Error  string
+}
+
+// VerifyBlock - decode a packed block as StoreBlock would and prepare
+// every transaction for insertion, without the database
+//
+// the error is for the block as a whole (header, unpack or merkle
+// root), problems are the transactions that would fail, including any
+// that StoreBlock does not handle; database constraints, such as a
+// transfer whose previous record is missing, are not checked
+func VerifyBlock(packedBlock []byte) (*blockrecord.Header, blockdigest.Digest, []TransactionProblem, error) {
+
+	header, digest, data, err := blockrecord.ExtractHeader(packedBlock, 0)
+	if nil != err {
+		return nil, digest, nil, err
+	}
+
+	testnet := mode.IsTesting()
+	foundationTxId := blockrecord.FoundationTxId(header, digest)
+	problems := []TransactionProblem{}
+	txIds := make([]merkle.Digest, header.TransactionCount)
+	for i := 0; i < int(header.TransactionCount); i += 1 {
+		transaction, n, err := transactionrecord.Packed(data).Unpack(testnet)
+		if nil != err {
+			return nil, digest, nil, fmt.Errorf("transaction: %d  error: %s", i, err)
+		}
+		txIds[i] = merkle.NewDigest(data[:n])
+		data = data[n:]
+
+		err = verifyTransaction(txIds[i], foundationTxId, transaction)
+		if nil != err {
+			problems = append(problems, TransactionProblem{
+				Offset: i,
+				TxId:   txIds[i],
+				Type:   fmt.Sprintf("%T", transaction),
+				Error:  err.Error(),
+			})
+		}
+	}
+
+	fullMerkleTree := merkle.FullMerkleTree(txIds)
+	if fullMerkleTree[len(fullMerkleTree)-1] != header.MerkleRoot {
+		return nil, digest, nil, fault.ErrMerkleRootDoesNotMatch
+	}
+
+	return header, digest, problems, nil
+}
+
+// convert one transaction as StoreBlock does; a panic, such as for a
+// transaction type that is not handled, is returned as an error
+func verifyTransaction(txId merkle.Digest, foundationTxId merkle.Digest, transaction interface{}) (err error) {
+
+	defer func() {
+		if r := recover(); nil != r {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	_, err = convertTransaction(txId, foundationTxId, transaction)
+	return err
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/logger"
+
+	"github.com/bitmark-inc/updaterd/peer"
+	"github.com/bitmark-inc/updaterd/peer/rpc"
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// one line of the verify-chain report
+type chainFinding struct {
+	Check  string `json:"check"`            // digest, node_height, heads or summary
+	Block  uint64 `json:"block,omitempty"`  // height of a digest finding
+	Node   string `json:"node,omitempty"`   // public key of the node
+	Local  string `json:"local,omitempty"`  // local digest
+	Remote string `json:"remote,omitempty"` // node digest
+	Height uint64 `json:"height,omitempty"` // node height
+	Error  string `json:"error,omitempty"`
+
+	*storage.HeadProblem
+
+	Summary *chainSummary `json:"summary,omitempty"`
+}
+
+// totals at the end of the report
+type chainSummary struct {
+	Start      uint64 `json:"start"`
+	End        uint64 `json:"end"`
+	Nodes      int    `json:"nodes"`
+	Mismatches int    `json:"mismatches"`
+	Errors     int    `json:"errors"`
+	Heads      int    `json:"head_problems"`
+}
+
+// compare the local digest of every stored height from start to end
+// with the digests of all eligible nodes, then check that each bitmark
+// has exactly one head record
+//
+// the report is written to stdout as one JSON object per line, the
+// last line is the summary; end 0 => local height
+func verifyChain(log *logger.L, configuration *peer.Configuration, start uint64, end uint64) error {
+
+	height, err := storage.GetBlockHeight()
+	if nil != err {
+		return err
+	}
+	if start <= genesis.BlockNumber {
+		start = genesis.BlockNumber + 1
+	}
+	if 0 == end || end > height {
+		end = height
+	}
+
+	node, err := peer.Connect(configuration)
+	if nil != err {
+		return fmt.Errorf("connect to node: %s", err)
+	}
+	defer node.Close()
+
+	report := json.NewEncoder(os.Stdout)
+	summary := &chainSummary{
+		Start: start,
+		End:   end,
+	}
+	write := func(f *chainFinding) {
+		err := report.Encode(f)
+		if nil != err {
+			log.Errorf("verify-chain: report error: %s", err)
+		}
+	}
+
+	// each node is compared up to its own height
+	type remote struct {
+		client *rpc.Client
+		height uint64
+	}
+	nodes := []remote{}
+	for _, client := range node.Clients() {
+		h, err := client.Height(context.Background())
+		if nil != err {
+			summary.Errors += 1
+			write(&chainFinding{Check: "node_height", Node: client.PublicKey(), Error: err.Error()})
+			continue
+		}
+		if h < end {
+			write(&chainFinding{Check: "node_height", Node: client.PublicKey(), Height: h})
+		}
+		nodes = append(nodes, remote{client: client, height: h})
+	}
+	summary.Nodes = len(nodes)
+	log.Infof("verify-chain: blocks: %d to %d  nodes: %d", start, end, len(nodes))
+
+	digests := 0
+	for n := start; n <= end; n += 1 {
+		digests += 1
+		local, err := storage.DigestForBlock(n)
+		if nil != err {
+			summary.Errors += 1
+			write(&chainFinding{Check: "digest", Block: n, Error: "local: " + err.Error()})
+			continue
+		}
+
+		for _, r := range nodes {
+			if n > r.height {
+				continue
+			}
+			d, err := r.client.Digest(context.Background(), n)
+			if nil != err {
+				summary.Errors += 1
+				write(&chainFinding{Check: "digest", Block: n, Node: r.client.PublicKey(), Local: local.String(), Error: err.Error()})
+				continue
+			}
+			if d != *local {
+				summary.Mismatches += 1
+				write(&chainFinding{Check: "digest", Block: n, Node: r.client.PublicKey(), Local: local.String(), Remote: d.String()})
+			}
+		}
+	}
+	log.Infof("verify-chain: digests: %d  mismatches: %d  errors: %d", digests, summary.Mismatches, summary.Errors)
+
+	problems, err := storage.CheckHeads()
+	if nil != err {
+		return err
+	}
+	for i := range problems {
+		write(&chainFinding{Check: "heads", HeadProblem: &problems[i]})
+	}
+	summary.Heads = len(problems)
+
+	write(&chainFinding{Check: "summary", Summary: summary})
+
+	if 0 != summary.Mismatches || 0 != summary.Errors || 0 != summary.Heads {
+		return fmt.Errorf("mismatches: %d  errors: %d  head problems: %d", summary.Mismatches, summary.Errors, summary.Heads)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bitmark-inc/bitmarkd/blockdigest"
+	"github.com/bitmark-inc/bitmarkd/genesis"
+	"github.com/bitmark-inc/logger"
+
+	"github.com/bitmark-inc/updaterd/peer"
+	"github.com/bitmark-inc/updaterd/storage"
+)
+
+// blocks requested from the node together
+const verifyWindow = 100
+
+// fetch blocks start to end from a node and decode them as the
+// connector would store them, without writing anything
+//
+// start 0 => one above the local height, end 0 => node height; every
+// block and transaction that would fail is printed and the command
+// fails at the end if there were any
+func verifySync(log *logger.L, configuration *peer.Configuration, start uint64, end uint64) error {
+
+	height, err := storage.GetBlockHeight()
+	if nil != err {
+		return err
+	}
+	if 0 == start {
+		start = height + 1
+	}
+	if start <= genesis.BlockNumber {
+		start = genesis.BlockNumber + 1
+	}
+
+	node, err := peer.Connect(configuration)
+	if nil != err {
+		return fmt.Errorf("connect to node: %s", err)
+	}
+	defer node.Close()
+
+	if 0 == end || end > node.Height() {
+		end = node.Height()
+	}
+	if start > end {
+		fmt.Printf("nothing to verify: start: %d  node: %s  height: %d\n", start, node.Client(), node.Height())
+		return nil
+	}
+
+	log.Infof("verify: blocks: %d to %d  node: %s", start, end, node.Client())
+	fmt.Printf("verify: blocks: %d to %d  node: %s\n", start, end, node.Client())
+
+	// the chain must continue from the local block below start
+	previous := (*blockdigest.Digest)(nil)
+	if start-1 <= height {
+		previous, err = storage.DigestForBlock(start - 1)
+		if nil != err {
+			return err
+		}
+	}
+
+	failures := 0
+	transactions := 0
+	progress := newProgress(log, "verified", start-1)
+	for n := start; n <= end; {
+		count := verifyWindow
+		if end-n+1 < uint64(count) {
+			count = int(end - n + 1)
+		}
+
+		blocks, err := node.Client().Blocks(context.Background(), n, count)
+		if nil != err && 0 == len(blocks) {
+			return fmt.Errorf("fetch block: %d  error: %s", n, err)
+		}
+
+		for _, packed := range blocks {
+			header, digest, problems, err := storage.VerifyBlock(packed)
+			if nil != err {
+				failures += 1
+				fmt.Printf("block: %d  error: %s\n", n, err)
+				log.Errorf("verify: block: %d  error: %s", n, err)
+				previous = nil
+				n += 1
+				continue
+			}
+
+			if header.Number != n {
+				failures += 1
+				fmt.Printf("block: %d  error: node sent block: %d\n", n, header.Number)
+				log.Errorf("verify: block: %d  node sent: %d", n, header.Number)
+			} else if nil != previous && *previous != header.PreviousBlock {
+				failures += 1
+				fmt.Printf("block: %d  error: previous digest: %s  expected: %s\n", n, header.PreviousBlock, previous)
+				log.Errorf("verify: block: %d  previous digest: %s  expected: %s", n, header.PreviousBlock, previous)
+			}
+			previous = &digest
+
+			for _, p := range problems {
+				failures += 1
+				fmt.Printf("block: %d  offset: %d  txid: %s  type: %s  error: %s\n", n, p.Offset, p.TxId, p.Type, p.Error)
+				log.Errorf("verify: block: %d  offset: %d  txid: %s  type: %s  error: %s", n, p.Offset, p.TxId, p.Type, p.Error)
+			}
+			transactions += int(header.TransactionCount)
+
+			progress.count += 1
+			progress.height = n
+			progress.report(false)
+			n += 1
+		}
+		if nil != err {
+			return fmt.Errorf("fetch block: %d  error: %s", n, err)
+		}
+	}
+	progress.report(true)
+
+	fmt.Printf("blocks: %d  transactions: %d  failures: %d\n", progress.count, transactions, failures)
+	if 0 != failures {
+		return fmt.Errorf("%d failures", failures)
+	}
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 //
 // prefix:
 //   REQ socket this adds an item before send
+//   DEALER socket this adds an item after the request id and delimiter
 //   SUB socket this adds/changes subscription
 type Client struct {
 	sync.Mutex
@@ -28,6 +29,7 @@ type Client struct {
 	publicKey       []byte
 	privateKey      []byte
 	serverPublicKey []byte
+	host            string // unresolved "host:port", see resolve.go
 	address         string
 	prefix          string
 	v6              bool
@@ -37,6 +39,7 @@ type Client struct {
 	events          zmq.State
 	timeout         time.Duration
 	timestamp       time.Time
+	notify          chan<- Event // connection events, nil => no monitor
 }
 
 const (
@@ -47,7 +50,8 @@ const (
 
 type globalClientDataType struct {
 	sync.Mutex
-	clients map[*zmq.Socket]*Client
+	clients  map[*zmq.Socket]*Client
+	monitors uint64 // to give each monitor a unique inproc name
 }
 
 var globalClientData = globalClientDataType{
@@ -184,21 +188,11 @@ func (client *Client) openSocket() error {
 		goto failure
 	}
 
-	// ***** FIX THIS: enabling this causes complete failure
-	// ***** FIX THIS: socket disconnects, perhaps after IVL value
 	// heartbeat (constants from socket.go)
-	// err = socket.SetHeartbeatIvl(heartbeatInterval)
-	// if nil != err {
-	// 	goto failure
-	// }
-	// err = socket.SetHeartbeatTimeout(heartbeatTimeout)
-	// if nil != err {
-	// 	goto failure
-	// }
-	// err = socket.SetHeartbeatTtl(heartbeatTTL)
-	// if nil != err {
-	// 	goto failure
-	// }
+	err = setHeartbeat(socket)
+	if nil != err {
+		goto failure
+	}
 
 	// see socket.go for constants
 	err = socket.SetMaxmsgsize(maximumPacketSize)
@@ -212,6 +206,14 @@ func (client *Client) openSocket() error {
 		goto failure
 	}
 
+	// monitor before connect so that the first connection is seen
+	if nil != client.notify {
+		err = client.startMonitor(socket)
+		if nil != err {
+			goto failure
+		}
+	}
+
 	// new connection
 	err = socket.Connect(client.address)
 	if nil != err {
@@ -290,6 +292,53 @@ func (client *Client) Connect(conn *util.Connection, serverPublicKey []byte, pre
 	return client.openSocket()
 }
 
+// Notify - send connection events of this client to ch
+//
+// must be called before Connect, the monitor is restarted with each
+// new socket; events are dropped if ch is full so the monitor never
+// blocks
+func (client *Client) Notify(ch chan<- Event) {
+	client.Lock()
+	defer client.Unlock()
+	client.notify = ch
+}
+
+// attach a monitor to a new socket
+// must be called with the lock held
+func (client *Client) startMonitor(socket *zmq.Socket) error {
+	globalClientData.Lock()
+	globalClientData.monitors += 1
+	n := globalClientData.monitors
+	globalClientData.Unlock()
+
+	monitor, err := NewMonitor(socket, fmt.Sprintf("inproc://zmqutil-monitor-%d", n), monitorEvents())
+	if nil != err {
+		return err
+	}
+	go client.watch(monitor, client.notify)
+	return nil
+}
+
+// forward monitor events until the monitored socket is closed
+func (client *Client) watch(monitor *zmq.Socket, ch chan<- Event) {
+	defer monitor.Close()
+
+	for {
+		e, address, _, err := monitor.RecvEvent(0)
+		if nil != err || zmq.EVENT_MONITOR_STOPPED == e {
+			return
+		}
+		t, ok := eventType(e)
+		if !ok {
+			continue
+		}
+		select {
+		case ch <- Event{Type: t, Client: client, Address: address}:
+		default:
+		}
+	}
+}
+
 // check if connected to a node
 func (client *Client) IsConnected() bool {
 	return "" != client.address
@@ -349,6 +398,35 @@ func (client *Client) Send(items ...interface{}) error {
 	client.Lock()
 	defer client.Unlock()
 
+	return client.send(items)
+}
+
+// send a message on a DEALER socket
+//
+// the id and an empty delimiter form the envelope that a REP server
+// returns unchanged with its reply
+func (client *Client) SendWithId(id []byte, items ...interface{}) error {
+	client.Lock()
+	defer client.Unlock()
+
+	if nil == client.socket || "" == client.address {
+		return fault.ErrNotConnected
+	}
+
+	_, err := client.socket.SendBytes(id, zmq.SNDMORE)
+	if nil != err {
+		return err
+	}
+	_, err = client.socket.Send("", zmq.SNDMORE)
+	if nil != err {
+		return err
+	}
+	return client.send(items)
+}
+
+// send the prefix and items, must be called with the lock held
+func (client *Client) send(items []interface{}) error {
+
 	if nil == client.socket || "" == client.address {
 		return fault.ErrNotConnected
 	}
@@ -441,6 +519,39 @@ func (client *Client) Receive(flags zmq.Flag) ([][]byte, error) {
 	return data, err
 }
 
+// wait up to timeout for a message, true if one can be received
+// without waiting
+func (client *Client) Readable(timeout time.Duration) (bool, error) {
+	client.Lock()
+	socket := client.socket
+	address := client.address
+	client.Unlock()
+
+	if nil == socket || "" == address {
+		return false, fault.ErrNotConnected
+	}
+
+	poller := zmq.NewPoller()
+	poller.Add(socket, zmq.POLLIN)
+	polled, err := poller.Poll(timeout)
+	if nil != err {
+		return false, err
+	}
+	return 0 != len(polled), nil
+}
+
+// receive a reply on a DEALER socket, separating the request id
+func (client *Client) ReceiveWithId(flags zmq.Flag) ([]byte, [][]byte, error) {
+	data, err := client.Receive(flags)
+	if nil != err {
+		return nil, nil, err
+	}
+	if len(data) < 2 || 0 != len(data[1]) {
+		return nil, nil, fault.ErrInvalidPeerResponse
+	}
+	return data[0], data[2:], nil
+}
+
 // add poller to client
 func (client *Client) BeginPolling(poller *Poller, events zmq.State) *zmq.Socket {
 
@@ -31,3 +31,81 @@ func NewMonitor(socket *zmq.Socket, connection string, event zmq.Event) (*zmq.So
 
 	return mon, nil
 }
+
+// handshake events, from libzmq 4.3 onwards
+// not named by this version of the zmq4 package
+const (
+	eventHandshakeFailedNoDetail = zmq.Event(0x0800)
+	eventHandshakeSucceeded      = zmq.Event(0x1000)
+	eventHandshakeFailedProtocol = zmq.Event(0x2000)
+	eventHandshakeFailedAuth     = zmq.Event(0x4000)
+)
+
+// EventType - connection state change of a client
+type EventType int
+
+// connection events reported to Notify channels
+const (
+	EventConnected       EventType = iota // TCP connection made
+	EventReady           EventType = iota // ZMTP/CURVE handshake completed
+	EventDisconnected    EventType = iota // connection lost, including heartbeat timeout
+	EventHandshakeFailed EventType = iota // ZMTP protocol error
+	EventAuthFailed      EventType = iota // CURVE keys rejected
+	EventRetry           EventType = iota // connect failed, libzmq will try again
+)
+
+// Event - a connection event of a client
+type Event struct {
+	Type    EventType
+	Client  *Client
+	Address string
+}
+
+// convert a monitor event, false if not of interest
+func eventType(e zmq.Event) (EventType, bool) {
+	switch e {
+	case zmq.EVENT_CONNECTED:
+		return EventConnected, true
+	case eventHandshakeSucceeded:
+		return EventReady, true
+	case zmq.EVENT_DISCONNECTED:
+		return EventDisconnected, true
+	case eventHandshakeFailedNoDetail, eventHandshakeFailedProtocol:
+		return EventHandshakeFailed, true
+	case eventHandshakeFailedAuth:
+		return EventAuthFailed, true
+	case zmq.EVENT_CONNECT_RETRIED:
+		return EventRetry, true
+	default:
+		return 0, false
+	}
+}
+
+// the monitor events to request from libzmq
+func monitorEvents() zmq.Event {
+	events := zmq.EVENT_CONNECTED | zmq.EVENT_CONNECT_RETRIED | zmq.EVENT_DISCONNECTED | zmq.EVENT_MONITOR_STOPPED
+	major, minor, _ := zmq.Version()
+	if major > 4 || (4 == major && minor >= 3) {
+		events |= eventHandshakeFailedNoDetail | eventHandshakeSucceeded | eventHandshakeFailedProtocol | eventHandshakeFailedAuth
+	}
+	return events
+}
+
+func (t EventType) String() string {
+	switch t {
+	case EventConnected:
+		return "connected"
+	case EventReady:
+		return "ready"
+	case EventDisconnected:
+		return "disconnected"
+	case EventHandshakeFailed:
+		return "handshake-failed"
+	case EventAuthFailed:
+		return "auth-failed"
+	case EventRetry:
+		return "retry"
+	default:
+		return "unknown"
+	}
+}
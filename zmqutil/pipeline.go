@@ -0,0 +1,232 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package zmqutil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	zmq "github.com/pebbe/zmq4"
+)
+
+// longest wait for a reply before checking whether the client has
+// reconnected with a new socket
+const pipelineCheckInterval = time.Second
+
+// to give each pipeline a unique stop signal
+var pipelineCount uint64
+
+// errors for the pipeline
+var (
+	ErrPipelineClosed  = errors.New("pipeline closed")
+	ErrNotDealerSocket = errors.New("pipeline requires a DEALER socket")
+)
+
+// many outstanding requests over a single DEALER client
+//
+// each request carries an 8 byte id that the server returns in the
+// reply envelope, so replies are matched to their callers in any order
+//
+// zmq sockets are not thread safe, so callers only queue their
+// requests and the receive loop does all the sending and receiving
+type Pipeline struct {
+	sync.Mutex
+
+	client  *Client
+	nextId  uint64
+	pending map[uint64]chan [][]byte
+	queue   []pipelineRequest // waiting to be sent
+	closed  bool
+	done    chan struct{}
+
+	// wake the receive loop to send the queue or on close
+	wake       *zmq.Socket // polled by the receive loop
+	wakeSender *zmq.Socket // only used with the lock held
+}
+
+// a request waiting to be sent by the receive loop
+type pipelineRequest struct {
+	id    []byte
+	items []interface{}
+	sent  chan error
+}
+
+// create a pipeline and start its receive loop
+// the client must use a DEALER socket and be connected
+func NewPipeline(client *Client) (*Pipeline, error) {
+	if zmq.DEALER != client.socketType {
+		return nil, ErrNotDealerSocket
+	}
+
+	signal := fmt.Sprintf("inproc://pipeline-signal-%d", atomic.AddUint64(&pipelineCount, 1))
+	wake, wakeSender, err := NewSignalPair(signal)
+	if nil != err {
+		return nil, err
+	}
+
+	p := &Pipeline{
+		client:     client,
+		pending:    make(map[uint64]chan [][]byte),
+		done:       make(chan struct{}),
+		wake:       wake,
+		wakeSender: wakeSender,
+	}
+	go p.receive()
+
+	return p, nil
+}
+
+// send a request and wait for its reply or the end of the context
+func (p *Pipeline) Call(ctx context.Context, items ...interface{}) ([][]byte, error) {
+
+	reply := make(chan [][]byte, 1)
+	sent := make(chan error, 1)
+
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		return nil, ErrPipelineClosed
+	}
+	p.nextId += 1
+	n := p.nextId
+	p.pending[n] = reply
+
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, n)
+
+	// one wake is enough for everything queued before the loop sends
+	p.queue = append(p.queue, pipelineRequest{id: id, items: items, sent: sent})
+	if 1 == len(p.queue) {
+		p.wakeSender.SendMessage("send")
+	}
+	p.Unlock()
+
+	defer func() {
+		p.Lock()
+		delete(p.pending, n)
+		p.Unlock()
+	}()
+
+	select {
+	case err := <-sent:
+		if nil != err {
+			return nil, err
+		}
+	case <-p.done:
+		return nil, ErrPipelineClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case data := <-reply:
+		return data, nil
+	case <-p.done:
+		return nil, ErrPipelineClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// number of requests waiting for replies
+func (p *Pipeline) Outstanding() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.pending)
+}
+
+// stop the receive loop, waiting callers return ErrPipelineClosed
+func (p *Pipeline) Close() {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+	p.wakeSender.SendMessage("stop")
+}
+
+// send the queued requests and deliver replies to their callers
+// until closed, replies for abandoned requests are discarded
+func (p *Pipeline) receive() {
+	defer p.wakeSender.Close()
+	defer p.wake.Close()
+
+	for {
+		// the socket is replaced when the client reconnects
+		p.client.Lock()
+		socket := p.client.socket
+		connected := "" != p.client.address
+		p.client.Unlock()
+
+		poller := zmq.NewPoller()
+		poller.Add(p.wake, zmq.POLLIN)
+		if nil != socket && connected {
+			poller.Add(socket, zmq.POLLIN)
+		}
+
+		polled, err := poller.Poll(pipelineCheckInterval)
+		if nil != err {
+			// socket closed by a reconnect, wait for the new one
+			time.Sleep(pipelineCheckInterval)
+			continue
+		}
+
+		for _, s := range polled {
+			if p.wake == s.Socket {
+				message, _ := p.wake.Recv(0)
+				if "stop" == message {
+					return
+				}
+				p.send()
+				continue
+			}
+			p.deliver()
+		}
+	}
+}
+
+// send everything queued, the result is passed to each caller
+func (p *Pipeline) send() {
+	p.Lock()
+	queue := p.queue
+	p.queue = nil
+	p.Unlock()
+
+	for _, r := range queue {
+		r.sent <- p.client.SendWithId(r.id, r.items...)
+	}
+}
+
+// pass all waiting replies to their callers
+func (p *Pipeline) deliver() {
+	for {
+		id, data, err := p.client.ReceiveWithId(zmq.DONTWAIT)
+		if fault.ErrInvalidPeerResponse == err {
+			continue // malformed envelope, discard
+		} else if nil != err {
+			return // none waiting or socket error
+		}
+		if 8 != len(id) {
+			continue
+		}
+
+		p.Lock()
+		reply, ok := p.pending[binary.BigEndian.Uint64(id)]
+		p.Unlock()
+
+		if ok {
+			reply <- data
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2014-2020 Bitmark Inc.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package zmqutil
+
+import (
+	"net"
+
+	"github.com/bitmark-inc/bitmarkd/fault"
+	"github.com/bitmark-inc/bitmarkd/util"
+)
+
+// connect to a "host:port" endpoint where host may be a DNS name
+//
+// the name is kept so that Resolve can look it up again when the
+// node moves, e.g. a restarted container
+//
+// if the lookup fails the endpoint is still kept, so a later Resolve
+// can connect once the name exists, see IsLookupError
+func (client *Client) ConnectHost(hostPort string, serverPublicKey []byte, prefix string) error {
+	client.Lock()
+	client.host = hostPort
+	client.prefix = prefix
+	copy(client.serverPublicKey, serverPublicKey)
+	client.Unlock()
+
+	conn, err := resolveHost(hostPort, "")
+	if nil != err {
+		return err
+	}
+	return client.Connect(conn, serverPublicKey, prefix)
+}
+
+// IsLookupError - true if a DNS lookup failed, which may succeed later
+func IsLookupError(err error) bool {
+	_, ok := err.(*net.DNSError)
+	return ok
+}
+
+// the endpoint as configured, blank if only connected by Connect
+func (client *Client) Host() string {
+	client.Lock()
+	defer client.Unlock()
+	return client.host
+}
+
+// look up the host name again and reconnect if the address changed
+// returns true if reconnected
+//
+// the current address is kept while the name still resolves to it so
+// that round robin DNS does not cause needless reconnections
+func (client *Client) Resolve() (bool, error) {
+	client.Lock()
+	host := client.host
+	current := client.address
+	prefix := client.prefix
+	serverPublicKey := make([]byte, len(client.serverPublicKey))
+	copy(serverPublicKey, client.serverPublicKey)
+	client.Unlock()
+
+	if "" == host {
+		return false, nil
+	}
+
+	conn, err := resolveHost(host, current)
+	if nil != err {
+		return false, err
+	}
+	address, _ := conn.CanonicalIPandPort("tcp://")
+	if address == current {
+		return false, nil
+	}
+
+	err = client.Connect(conn, serverPublicKey, prefix)
+	if nil != err {
+		return false, err
+	}
+	return true, nil
+}
+
+// resolve "host:port", preferring the current address if it is still
+// one of the results
+func resolveHost(hostPort string, current string) (*util.Connection, error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if nil != err {
+		return nil, fault.ErrInvalidIpAddress
+	}
+
+	// literal addresses need no lookup
+	if nil != net.ParseIP(host) {
+		return util.NewConnection(hostPort)
+	}
+
+	ips, err := net.LookupIP(host)
+	if nil != err {
+		return nil, err
+	}
+	if 0 == len(ips) {
+		return nil, fault.ErrInvalidIpAddress
+	}
+
+	selected := (*util.Connection)(nil)
+	for _, ip := range ips {
+		conn, err := util.NewConnection(net.JoinHostPort(ip.String(), port))
+		if nil != err {
+			return nil, err
+		}
+		if nil == selected {
+			selected = conn
+		}
+		if address, _ := conn.CanonicalIPandPort("tcp://"); address == current {
+			return conn, nil
+		}
+	}
+	return selected, nil
+}
@@ -19,13 +19,14 @@ const (
 	maximumPacketSize = 5000000 // 5 MB
 )
 
-// ***** FIX THIS: enabling this causes complete failure
-// ***** FIX THIS: socket disconnects, perhaps after IVL value
-// const (
-// 	heartbeatInterval = 15 * time.Second
-// 	heartbeatTimeout  = 60 * time.Second
-// 	heartbeatTTL      = 60 * time.Second
-// )
+// ZMTP heartbeats: a PING is sent after each interval without traffic
+// and the connection is dropped if nothing arrives within the timeout,
+// the TTL tells the remote to drop its side after the same time
+const (
+	heartbeatInterval = 15 * time.Second
+	heartbeatTimeout  = 60 * time.Second
+	heartbeatTTL      = 60 * time.Second
+)
 
 // return a pair of connected PAIR sockets
 // for shutdown signalling
@@ -170,21 +171,10 @@ func NewServerSocket(socketType zmq.Type, zapDomain string, privateKey []byte, p
 		goto failure
 	}
 
-	// ***** FIX THIS: enabling this causes complete failure
-	// ***** FIX THIS: socket disconnects, perhaps after IVL value
-	// heartbeat
-	// err = socket.SetHeartbeatIvl(heartbeatInterval)
-	// if nil != err {
-	// 	goto failure
-	// }
-	// err = socket.SetHeartbeatTimeout(heartbeatTimeout)
-	// if nil != err {
-	// 	goto failure
-	// }
-	// err = socket.SetHeartbeatTtl(heartbeatTTL)
-	// if nil != err {
-	// 	goto failure
-	// }
+	err = setHeartbeat(socket)
+	if nil != err {
+		goto failure
+	}
 
 	err = socket.SetMaxmsgsize(maximumPacketSize)
 	if nil != err {
@@ -196,3 +186,19 @@ func NewServerSocket(socketType zmq.Type, zapDomain string, privateKey []byte, p
 failure:
 	return nil, err
 }
+
+// enable ZMTP heartbeats
+// not an error if libzmq is older than 4.2, keepalives still apply
+func setHeartbeat(socket *zmq.Socket) error {
+	err := socket.SetHeartbeatIvl(heartbeatInterval)
+	if zmq.ErrorNotImplemented42 == err {
+		return nil
+	} else if nil != err {
+		return err
+	}
+	err = socket.SetHeartbeatTimeout(heartbeatTimeout)
+	if nil != err {
+		return err
+	}
+	return socket.SetHeartbeatTtl(heartbeatTTL)
+}